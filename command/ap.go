@@ -1,9 +1,9 @@
 package command
 
 import (
+	"context"
 	"fmt"
 	"strings"
-	"wnetctl/config"
 	"wnetctl/site"
 )
 
@@ -18,6 +18,10 @@ func GetApCommand(argv []string) Command {
 		cmd = new(apReplace)
 	case "remove":
 		cmd = new(apRemove)
+	case "trust":
+		cmd = new(apTrust)
+	case "forget-key":
+		cmd = new(apForgetKey)
 	default:
 		cmd = apHelp(true)
 	}
@@ -40,10 +44,12 @@ func (this apHelp) HelpRequested() bool {
 func (this apHelp) HelpMessage() string {
 	messages := []string{
 		"Access Point commands:",
-		"add <apName> -t apType -a apIp",
+		"add <apName> -t apType -a apIp [-port sshPort]",
 		"tune <apName> [-2c channel] [-2p power] [-5c channel] [-5p power]",
 		"replace <apName> -t apType -i apIp",
-		"remove <apName>"}
+		"remove <apName>",
+		"trust <apName>       record SSH host key the access point presents now",
+		"forget-key <apName>  remove SSH host key of the access point from known hosts"}
 	return strings.Join(messages, "\n  ")
 }
 
@@ -51,7 +57,7 @@ func (this apHelp) ParseArgs(argv []string) error {
 	return nil
 }
 
-func (this apHelp) Execute() error {
+func (this apHelp) Execute(ctx context.Context) error {
 	fmt.Println(this.HelpMessage())
 	return nil
 }
@@ -68,12 +74,14 @@ type apAdd struct {
 
 func (this *apAdd) Init() {
 	this.GenericCommand.Init()
-	this.usageMessage = "Usage: wnetctl ap add <apName> -t apType -a apIp"
+	this.usageMessage = "Usage: wnetctl ap add <apName> -t apType -a apIp [-port sshPort]"
 	this.model = new(site.AccessPointRequest)
 	this.flags.StringVar(&this.model.Ip, "a", "", "access point IP address")
 	this.flags.StringVar(&this.model.Ip, "addr", "", "access point IP address")
 	this.flags.StringVar(&this.model.Model, "t", "", "access point device type")
 	this.flags.StringVar(&this.model.Model, "type", "", "access point device type")
+	this.flags.IntVar(&this.model.Port, "port", 0, "access point SSH port, 22 if not set")
+	this.applyFlags()
 }
 
 func (this *apAdd) ParseArgs(argv []string) error {
@@ -89,12 +97,14 @@ func (this *apAdd) ParseArgs(argv []string) error {
 	return nil
 }
 
-func (this *apAdd) Execute() error {
-	siteManager, err := config.GetCurrentSiteManager(getSiteManager)
-	if err == nil {
-		_, err = siteManager.AddAccessPoint(this.model)
+func (this *apAdd) Execute(ctx context.Context) error {
+	siteManager, err := this.currentSiteManager()
+	if err != nil {
+		return err
 	}
-	return err
+	defer siteManager.Close()
+	_, report, err := siteManager.AddAccessPoint(ctx, this.model)
+	return printReport(report, err)
 }
 
 type apTune struct {
@@ -111,7 +121,7 @@ func (this *apTune) ParseArgs(argv []string) error {
 	panic("implement me")
 }
 
-func (this *apTune) Execute() error {
+func (this *apTune) Execute(ctx context.Context) error {
 	//TODO implement me
 	panic("implement me")
 }
@@ -124,6 +134,7 @@ type apRemove struct {
 func (this *apRemove) Init() {
 	this.GenericCommand.Init()
 	this.usageMessage = "Usage: wnetctl ap remove <apName ...>"
+	this.applyFlags()
 }
 
 func (this *apRemove) ParseArgs(argv []string) error {
@@ -141,18 +152,18 @@ func (this *apRemove) ParseArgs(argv []string) error {
 	return nil
 }
 
-func (this *apRemove) Execute() error {
+func (this *apRemove) Execute(ctx context.Context) error {
 	if this.helpRequested {
 		fmt.Println(this.HelpMessage())
 		return nil
 	}
-	siteManager, err := config.GetCurrentSiteManager(getSiteManager)
+	siteManager, err := this.currentSiteManager()
 	if err != nil {
 		return err
 	}
+	defer siteManager.Close()
 	for _, name := range this.names {
-		err = siteManager.RemoveAccessPoint(name)
-		if err != nil {
+		if err = printReport(siteManager.RemoveAccessPoint(ctx, name)); err != nil {
 			return err
 		}
 	}
@@ -173,7 +184,82 @@ func (this *apReplace) ParseArgs(argv []string) error {
 	panic("implement me")
 }
 
-func (this *apReplace) Execute() error {
+func (this *apReplace) Execute(ctx context.Context) error {
 	//TODO implement me
 	panic("implement me")
 }
+
+type apTrust struct {
+	apCommand
+}
+
+func (this *apTrust) Init() {
+	this.GenericCommand.Init()
+	this.usageMessage = "Usage: wnetctl ap trust <apName>\nRecords SSH host key the access point presents, replacing known one"
+}
+
+func (this *apTrust) ParseArgs(argv []string) error {
+	if err := this.flags.Parse(argv); err != nil {
+		this.helpRequested = true
+		return nil
+	}
+	if this.flags.NArg() != 1 {
+		this.helpRequested = true
+	} else {
+		this.name = this.flags.Arg(0)
+	}
+	return nil
+}
+
+func (this *apTrust) Execute(ctx context.Context) error {
+	if this.helpRequested {
+		fmt.Println(this.HelpMessage())
+		return nil
+	}
+	siteManager, err := this.currentSiteManager()
+	if err != nil {
+		return err
+	}
+	defer siteManager.Close()
+	fingerprint, err := siteManager.TrustAccessPoint(ctx, this.name)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Trusted host key of %s: %s\n", this.name, fingerprint)
+	return nil
+}
+
+type apForgetKey struct {
+	apCommand
+}
+
+func (this *apForgetKey) Init() {
+	this.GenericCommand.Init()
+	this.usageMessage = "Usage: wnetctl ap forget-key <apName>"
+}
+
+func (this *apForgetKey) ParseArgs(argv []string) error {
+	if err := this.flags.Parse(argv); err != nil {
+		this.helpRequested = true
+		return nil
+	}
+	if this.flags.NArg() != 1 {
+		this.helpRequested = true
+	} else {
+		this.name = this.flags.Arg(0)
+	}
+	return nil
+}
+
+func (this *apForgetKey) Execute(ctx context.Context) error {
+	if this.helpRequested {
+		fmt.Println(this.HelpMessage())
+		return nil
+	}
+	siteManager, err := this.currentSiteManager()
+	if err != nil {
+		return err
+	}
+	defer siteManager.Close()
+	return siteManager.ForgetAccessPointKey(this.name)
+}
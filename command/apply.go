@@ -0,0 +1,47 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"wnetctl/site"
+)
+
+type applyCommand struct {
+	GenericCommand
+}
+
+func GetApplyCommand(argv []string) Command {
+	cmd := new(applyCommand)
+	cmd.Init()
+	if cmd.ParseArgs(argv) != nil {
+		cmd.helpRequested = true
+	}
+	return cmd
+}
+
+func (this *applyCommand) Init() {
+	this.GenericCommand.Init()
+	this.usageMessage = "Usage: wnetctl apply [-confirm-timeout seconds] [-parallel n] [-timeout seconds]\n" +
+		"Change network and wireless configuration of access points of the current site where it differs from the site definition"
+	this.applyFlags()
+}
+
+func (this *applyCommand) ParseArgs(argv []string) error {
+	return this.flags.Parse(argv)
+}
+
+func (this *applyCommand) Execute(ctx context.Context) error {
+	siteManager, err := this.currentSiteManager()
+	if err != nil {
+		return err
+	}
+	defer siteManager.Close()
+	plans, report, err := siteManager.Reconcile(ctx)
+	site.WritePlans(os.Stdout, plans)
+	if err = printReport(report, err); err != nil {
+		return err
+	}
+	fmt.Println("Access points match the site")
+	return nil
+}
@@ -1,10 +1,13 @@
 package command
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"fmt"
 	"strings"
+	"time"
+	"wnetctl/config"
 	"wnetctl/openwrt"
 	"wnetctl/site"
 )
@@ -16,16 +19,22 @@ type Object interface {
 type Command interface {
 	Object
 	ParseArgs(argv []string) error
-	Execute() error
+	Execute(ctx context.Context) error
 	HelpRequested() bool
 	HelpMessage() string
 }
 
+const defaultParallelism = 8
+const defaultTimeout = 120
+
 type GenericCommand struct {
 	helpRequested bool
 	usageMessage  string
 	//helpMessage   string
-	flags *flag.FlagSet
+	flags          *flag.FlagSet
+	confirmTimeout int
+	parallelism    int
+	timeout        int
 }
 
 type Help bool
@@ -36,7 +45,10 @@ func (this Help) HelpRequested() bool {
 
 func (this Help) HelpMessage() string {
 	help := []string{"Usage: wnetctl <object> <command> <options>",
-		"where <object> is one of: site, device, ap, ssid, station",
+		"where <object> is one of: site, device, ap, ssid, station, steering",
+		"or: wnetctl plan <options>  to show changes of access points configuration the site would make",
+		"or: wnetctl apply <options>  to make configuration of access points match the site",
+		"or: wnetctl status <options>  to check access points, exiting with non-zero status if any is unreachable or drifted",
 		"commands are object specific, although \"help\" command supported for each object explaining available commands",
 		"also each command has any of -h, -help --help options with details about options and parameters"}
 	return strings.Join(help, "\n  ")
@@ -46,7 +58,7 @@ func (this Help) ParseArgs(argv []string) error {
 	return nil
 }
 
-func (this Help) Execute() error {
+func (this Help) Execute(ctx context.Context) error {
 	fmt.Println(this.HelpMessage())
 	return nil
 }
@@ -82,6 +94,44 @@ func (this *GenericCommand) Init() {
 	this.flags.BoolVar(&this.helpRequested, "help", false, "Display help message")
 }
 
+// applyFlags adds options controlling how changes are applied to access points, for commands changing them.
+func (this *GenericCommand) applyFlags() {
+	this.flags.IntVar(&this.confirmTimeout, "confirm-timeout", 0,
+		"seconds an access point waits for confirmation after the change, restoring previous configuration if it becomes unreachable")
+	this.accessFlags()
+}
+
+// accessFlags adds options controlling how access points are reached, for commands connecting to them.
+func (this *GenericCommand) accessFlags() {
+	this.flags.IntVar(&this.parallelism, "parallel", defaultParallelism, "number of access points processed concurrently")
+	this.flags.IntVar(&this.timeout, "timeout", defaultTimeout, "seconds to process a single access point, 0 means no limit")
+}
+
+// currentSiteManager returns manager of the current site with apply options from command line.
+func (this *GenericCommand) currentSiteManager() (site.SiteManager, error) {
+	siteManager, err := config.GetCurrentSiteManager(getSiteManager)
+	if err != nil {
+		return nil, err
+	}
+	if this.confirmTimeout < 0 || this.timeout < 0 {
+		return nil, errors.New("Timeout can't be negative")
+	}
+	siteManager.SetApplyOptions(&site.ApplyOptions{
+		ConfirmTimeout: time.Duration(this.confirmTimeout) * time.Second,
+		Parallelism:    this.parallelism,
+		Timeout:        time.Duration(this.timeout) * time.Second,
+	})
+	return siteManager, nil
+}
+
+// printReport prints outcome of a change on each access point when the change was applied, err is passed through.
+func printReport(report *site.ApplyReport, err error) error {
+	if report != nil {
+		fmt.Println(report)
+	}
+	return err
+}
+
 func getSiteManager(siteType, name, filepath string) (site.SiteManager, error) {
 	switch siteType {
 	case "openwrt":
@@ -1,6 +1,7 @@
 package command
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"strings"
@@ -37,7 +38,7 @@ func (this deviceHelp) ParseArgs(argv []string) error {
 	return nil
 }
 
-func (this deviceHelp) Execute() error {
+func (this deviceHelp) Execute(ctx context.Context) error {
 	fmt.Println(this.HelpMessage())
 	return nil
 }
@@ -86,9 +87,9 @@ func (this *deviceAdd) Init() {
 	this.flags.StringVar(&this.device.WLan2.Device, "w2dev", "", "2.4GHz WLAN network device (i.e. wlan0)")
 	this.flags.StringVar(&this.device.WLan2.Interface, "w2if", "", "2.4GHz WLAN interface (i.e. radio0)")
 	this.flags.StringVar(&this.device.WLan2.Driver, "w2drv", "", "2.4GHz WLAN network device driver (i.e. linux module name)")
-	this.flags.StringVar(&this.device.WLan2.Device, "w5dev", "", "5GHz WLAN network device (i.e. wlan0)")
-	this.flags.StringVar(&this.device.WLan2.Interface, "w5if", "", "5GHz WLAN interface (i.e. radio0)")
-	this.flags.StringVar(&this.device.WLan2.Driver, "w5drv", "", "5GHz WLAN network device driver (i.e. linux module name)")
+	this.flags.StringVar(&this.device.WLan5.Device, "w5dev", "", "5GHz WLAN network device (i.e. wlan1)")
+	this.flags.StringVar(&this.device.WLan5.Interface, "w5if", "", "5GHz WLAN interface (i.e. radio1)")
+	this.flags.StringVar(&this.device.WLan5.Driver, "w5drv", "", "5GHz WLAN network device driver (i.e. linux module name)")
 	this.flags.StringVar(&this.importPath, "i", "", "Import from a file containing device information instead of passing values in options")
 	this.flags.StringVar(&this.importPath, "import", "", "Path to a file containing device information instead of passing values in options")
 
@@ -96,15 +97,13 @@ func (this *deviceAdd) Init() {
 }
 
 func (this *deviceAdd) ParseArgs(argv []string) error {
-	this.device = new(site.AccessPointDevice)
-
 	if err := this.flags.Parse(argv); err != nil {
 		this.helpRequested = true
 	}
 	return nil
 }
 
-func (this *deviceAdd) Execute() error {
+func (this *deviceAdd) Execute(ctx context.Context) error {
 	if this.helpRequested {
 		fmt.Println(this.HelpMessage())
 		return nil
@@ -153,7 +152,7 @@ func (this *deviceRemove) ParseArgs(argv []string) error {
 	return nil
 }
 
-func (this *deviceRemove) Execute() error {
+func (this *deviceRemove) Execute(ctx context.Context) error {
 	if this.helpRequested {
 		fmt.Println(this.HelpMessage())
 		return nil
@@ -184,7 +183,7 @@ func (this *deviceList) ParseArgs(argv []string) error {
 	return nil
 }
 
-func (this *deviceList) Execute() error {
+func (this *deviceList) Execute(ctx context.Context) error {
 	if this.helpRequested {
 		fmt.Println(this.HelpMessage())
 		return nil
@@ -0,0 +1,190 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"wnetctl/config"
+	"wnetctl/site"
+)
+
+func GetJumpHostCommand(argv []string) Command {
+	var cmd Command
+	if len(argv) == 0 {
+		return jumpHostHelp(true)
+	}
+	switch argv[0] {
+	case "add":
+		cmd = new(jumpHostAdd)
+	case "list":
+		cmd = new(jumpHostList)
+	case "remove":
+		cmd = new(jumpHostRemove)
+	default:
+		cmd = jumpHostHelp(true)
+	}
+	cmd.Init()
+	if cmd.ParseArgs(argv[1:]) != nil {
+		return jumpHostHelp(true)
+	}
+	return cmd
+}
+
+type jumpHostHelp bool
+
+func (this jumpHostHelp) Init() {
+}
+
+func (this jumpHostHelp) HelpRequested() bool {
+	return true
+}
+
+func (this jumpHostHelp) HelpMessage() string {
+	help := []string{"Usage: wnetctl site jump <command> [options]\nAccess points are reached through jump hosts in order they are added. Available commands are:",
+		"add <user@host[:port]> -host-key key [-k keyFile]",
+		"list",
+		"remove <host[:port]>",
+		"help"}
+	msg := strings.Join(help, "\n  ")
+	help = []string{msg, "Use wnetctl site jump <command> -h for details about distinct command."}
+	return strings.Join(help, "\n")
+}
+
+func (this jumpHostHelp) ParseArgs(argv []string) error {
+	return nil
+}
+
+func (this jumpHostHelp) Execute(ctx context.Context) error {
+	fmt.Println(this.HelpMessage())
+	return nil
+}
+
+type jumpHostAdd struct {
+	GenericCommand
+	jump    *site.JumpHost
+	address string
+}
+
+func (this *jumpHostAdd) Init() {
+	this.GenericCommand.Init()
+	this.usageMessage = "Usage: wnetctl site jump add <user@host[:port]> <options>\n" +
+		"Add a jump host to the end of the chain access points of the current site are reached through"
+	this.jump = new(site.JumpHost)
+	this.flags.StringVar(&this.jump.HostKey, "host-key", "", "public key of the jump host in authorized_keys format or a line ssh-keyscan prints")
+	this.flags.StringVar(&this.jump.Key, "k", "", "path to SSH private key for the jump host, the site key is used if not set")
+	this.flags.StringVar(&this.jump.Key, "key", "", "path to SSH private key for the jump host, the site key is used if not set")
+}
+
+func (this *jumpHostAdd) ParseArgs(argv []string) error {
+	if len(argv) > 0 && !strings.HasPrefix(argv[0], "-") {
+		this.address = argv[0]
+		argv = argv[1:]
+	}
+	if err := this.flags.Parse(argv); err != nil || this.flags.NArg() > 0 {
+		this.helpRequested = true
+	}
+	this.helpRequested = this.helpRequested || this.address == "" || this.jump.HostKey == ""
+	return nil
+}
+
+func (this *jumpHostAdd) Execute(ctx context.Context) error {
+	if this.helpRequested {
+		fmt.Println(this.HelpMessage())
+		return nil
+	}
+	if err := parseJumpAddress(this.jump, this.address); err != nil {
+		return err
+	}
+	siteManager, err := config.GetCurrentSiteManager(getSiteManager)
+	if err != nil {
+		return err
+	}
+	return siteManager.AddJumpHost(this.jump)
+}
+
+// parseJumpAddress sets user, host and port of the jump host from user@host[:port] address.
+func parseJumpAddress(jump *site.JumpHost, address string) error {
+	user, host, found := strings.Cut(address, "@")
+	if !found || user == "" || host == "" {
+		return errors.New("Jump host address must be user@host[:port], got \"" + address + "\"")
+	}
+	jump.User = user
+	jump.Host = strings.Trim(host, "[]")
+	if h, port, err := net.SplitHostPort(host); err == nil {
+		jump.Host = h
+		if jump.Port, err = strconv.Atoi(port); err != nil || jump.Port <= 0 || jump.Port > 65535 {
+			return errors.New("Invalid port of jump host " + address)
+		}
+	}
+	return nil
+}
+
+type jumpHostList struct {
+	GenericCommand
+}
+
+func (this *jumpHostList) Init() {
+	this.GenericCommand.Init()
+	this.usageMessage = "Usage: wnetctl site jump list\nShow jump hosts of the current site in order of hops"
+}
+
+func (this *jumpHostList) ParseArgs(argv []string) error {
+	if err := this.flags.Parse(argv); err != nil || this.flags.NArg() > 0 {
+		this.helpRequested = true
+	}
+	return nil
+}
+
+func (this *jumpHostList) Execute(ctx context.Context) error {
+	if this.helpRequested {
+		fmt.Println(this.HelpMessage())
+		return nil
+	}
+	siteManager, err := config.GetCurrentSiteManager(getSiteManager)
+	if err != nil {
+		return err
+	}
+	for _, jump := range siteManager.GetSite().JumpHosts {
+		fmt.Println(jump.String())
+	}
+	return nil
+}
+
+type jumpHostRemove struct {
+	GenericCommand
+	hosts []string
+}
+
+func (this *jumpHostRemove) Init() {
+	this.GenericCommand.Init()
+	this.usageMessage = "Usage: wnetctl site jump remove <host[:port] ...>"
+}
+
+func (this *jumpHostRemove) ParseArgs(argv []string) error {
+	if err := this.flags.Parse(argv); err != nil || this.flags.NArg() < 1 {
+		this.helpRequested = true
+		return nil
+	}
+	this.hosts = this.flags.Args()
+	return nil
+}
+
+func (this *jumpHostRemove) Execute(ctx context.Context) error {
+	if this.helpRequested {
+		fmt.Println(this.HelpMessage())
+		return nil
+	}
+	siteManager, err := config.GetCurrentSiteManager(getSiteManager)
+	if err != nil {
+		return err
+	}
+	for _, host := range this.hosts {
+		if err = siteManager.RemoveJumpHost(host); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"wnetctl/site"
+)
+
+type planCommand struct {
+	GenericCommand
+}
+
+func GetPlanCommand(argv []string) Command {
+	cmd := new(planCommand)
+	cmd.Init()
+	if cmd.ParseArgs(argv) != nil {
+		cmd.helpRequested = true
+	}
+	return cmd
+}
+
+func (this *planCommand) Init() {
+	this.GenericCommand.Init()
+	this.usageMessage = "Usage: wnetctl plan [-parallel n] [-timeout seconds]\n" +
+		"Show network and wireless configuration changes needed to bring access points of the current site to its definition"
+	this.accessFlags()
+}
+
+func (this *planCommand) ParseArgs(argv []string) error {
+	return this.flags.Parse(argv)
+}
+
+func (this *planCommand) Execute(ctx context.Context) error {
+	siteManager, err := this.currentSiteManager()
+	if err != nil {
+		return err
+	}
+	defer siteManager.Close()
+	plans, err := siteManager.Plan(ctx)
+	site.WritePlans(os.Stdout, plans)
+	if err != nil {
+		return err
+	}
+	failed := 0
+	for _, plan := range plans {
+		if plan.Err != nil {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("Configuration of %d access point(s) could not be compared", failed)
+	}
+	return nil
+}
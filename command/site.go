@@ -1,12 +1,14 @@
 package command
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
 	"strings"
 	"wnetctl/config"
 	"wnetctl/site"
+	"wnetctl/sshclient"
 )
 
 func GetSiteCommand(argv []string) Command {
@@ -22,6 +24,8 @@ func GetSiteCommand(argv []string) Command {
 		cmd = new(siteExport)
 	case "import":
 		cmd = new(siteImport)
+	case "jump":
+		return GetJumpHostCommand(argv[1:])
 	default:
 		cmd = siteHelp(true)
 	}
@@ -54,7 +58,7 @@ func (this *siteList) ParseArgs(argv []string) error {
 	return nil
 }
 
-func (this *siteList) Execute() error {
+func (this *siteList) Execute(ctx context.Context) error {
 	if this.helpRequested {
 		fmt.Println(this.HelpMessage())
 		return nil
@@ -97,7 +101,7 @@ func (this *siteSelect) ParseArgs(argv []string) error {
 	return nil
 }
 
-func (this *siteSelect) Execute() error {
+func (this *siteSelect) Execute(ctx context.Context) error {
 	if this.helpRequested {
 		fmt.Println(this.HelpMessage())
 		return nil
@@ -125,7 +129,9 @@ func (this *siteInit) Init() {
 	this.flags.StringVar(&this.siteModel.SsidSuffix2, "s2", "", "suffix appended to any SSID in 2.4 GHz band")
 	this.flags.StringVar(&this.siteModel.SsidSuffix5, "s5", "", "suffix appended to any SSID in 5 GHz band")
 
-	this.usageMessage = "Usage: wnetctl site init site_name <options>"
+	this.usageMessage = "Usage: wnetctl site init site_name <options>\n" +
+		"Passphrase of protected SSH private key is not saved, it is taken from " + sshclient.PassphraseEnv +
+		" environment variable or prompted for"
 }
 
 func (this *siteInit) ParseArgs(argv []string) error {
@@ -145,7 +151,7 @@ func (this *siteInit) ParseArgs(argv []string) error {
 	return nil
 }
 
-func (this *siteInit) Execute() error {
+func (this *siteInit) Execute(ctx context.Context) error {
 	if this.helpRequested {
 		fmt.Println(this.HelpMessage())
 		return nil
@@ -198,7 +204,7 @@ func (this *siteExport) ParseArgs(argv []string) error {
 	return nil
 }
 
-func (this *siteExport) Execute() error {
+func (this *siteExport) Execute(ctx context.Context) error {
 	if this.helpRequested {
 		fmt.Println(this.HelpMessage())
 		return nil
@@ -249,7 +255,7 @@ func (this *siteImport) ParseArgs(argv []string) error {
 	panic("implement me")
 }
 
-func (this *siteImport) Execute() error {
+func (this *siteImport) Execute(ctx context.Context) error {
 	//TODO implement me
 	panic("implement me")
 }
@@ -270,6 +276,7 @@ func (this siteHelp) HelpMessage() string {
 		"select  Selects site so any further commands are applied to it. For more details use wnetctl site select -h",
 		"export  Exports site configuration file. For more details use wnetctl site export -h",
 		"import  Imports site configuration file. For more details use wnetctl site import -h",
+		"jump    Manages jump hosts access points are reached through. For more details use wnetctl site jump help",
 		"help    Show this help text."}
 	return strings.Join(help, "\n  ")
 }
@@ -278,7 +285,7 @@ func (this siteHelp) ParseArgs(argv []string) error {
 	return nil
 }
 
-func (this siteHelp) Execute() error {
+func (this siteHelp) Execute(ctx context.Context) error {
 	fmt.Println(this.HelpMessage())
 	return nil
 }
@@ -1,6 +1,75 @@
 package command
 
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"wnetctl/config"
+	"wnetctl/radius"
+	"wnetctl/site"
+)
+
 func GetSsidCommand(argv []string) Command {
+	var cmd Command
+	if len(argv) == 0 {
+		return ssidHelp(true)
+	}
+	switch argv[0] {
+	case "add":
+		cmd = new(ssidAdd)
+	case "list":
+		cmd = new(ssidList)
+	case "show":
+		cmd = new(ssidShow)
+	case "update":
+		cmd = new(ssidUpdate)
+	case "remove":
+		cmd = new(ssidRemove)
+	case "test-radius":
+		cmd = new(ssidTestRadius)
+	default:
+		cmd = ssidHelp(true)
+	}
+	cmd.Init()
+	if cmd.ParseArgs(argv[1:]) != nil {
+		return ssidHelp(true)
+	}
+	return cmd
+}
+
+type ssidHelp bool
+
+func (this ssidHelp) Init() {
+}
+
+func (this ssidHelp) HelpRequested() bool {
+	return true
+}
+
+func (this ssidHelp) HelpMessage() string {
+	help := []string{"Usage: wnetctl ssid <command> [options]\nAvailable commands are:",
+		"add <ssidName> [-a auth] [-p password] [-v vlan] [-r] [-w] [-f] [-radius-server hosts] [-radius-port port] [-radius-secret secret] [-acct-server hosts] [-acct-port port] [-acct-secret secret] [-nas-id id] [-dynamic-vlan]",
+		"list",
+		"show <ssidName>",
+		"update <ssidName> [-n newName] [-a auth] [-p password] [-v vlan] [-r] [-w] [-f] [-radius-server hosts] [-radius-port port] [-radius-secret secret] [-acct-server hosts] [-acct-port port] [-acct-secret secret] [-nas-id id] [-dynamic-vlan]",
+		"remove <ssidName ...>",
+		"test-radius <ssidName> -u user -p password",
+		"help"}
+	msg := strings.Join(help, "\n  ")
+	help = []string{msg, "Use wnetctl ssid <command> -h for details about distinct command."}
+	return strings.Join(help, "\n")
+}
+
+func (this ssidHelp) ParseArgs(argv []string) error {
+	return nil
+}
+
+func (this ssidHelp) Execute(ctx context.Context) error {
+	fmt.Println(this.HelpMessage())
 	return nil
 }
 
@@ -8,3 +77,398 @@ type SsidCommand struct {
 	GenericCommand
 	name string
 }
+
+// ssidFlags binds SSID attributes to command line flags shared by add and update commands.
+func (this *SsidCommand) ssidFlags(ssid *site.SSID) {
+	this.flags.StringVar(&ssid.Name, "n", "", "SSID (wireless network name)")
+	this.flags.StringVar(&ssid.Name, "name", "", "SSID (wireless network name)")
+	authUsage := "authentication mode, one of " + strings.Join(site.AuthModes, ", ") + "; " + site.AuthWpa2Psk +
+		" when password is given, " + site.AuthOpen + " otherwise"
+	this.flags.StringVar(&ssid.Auth, "a", "", authUsage)
+	this.flags.StringVar(&ssid.Auth, "auth", "", authUsage)
+	this.flags.StringVar(&ssid.Password, "p", "", "wireless network password")
+	this.flags.StringVar(&ssid.Password, "password", "", "wireless network password")
+	this.flags.IntVar(&ssid.Vlan, "v", 0, "VLAN id, 0 puts SSID to the default (untagged) network")
+	this.flags.IntVar(&ssid.Vlan, "vlan", 0, "VLAN id, 0 puts SSID to the default (untagged) network")
+	this.flags.BoolVar(&ssid.Restricted, "r", false, "restricted network, clients are isolated from each other")
+	this.flags.BoolVar(&ssid.Restricted, "restricted", false, "restricted network, clients are isolated from each other")
+	this.flags.BoolVar(&ssid.Whitelisted, "w", false, "whitelisted network, only stations listed for the SSID may connect")
+	this.flags.BoolVar(&ssid.Whitelisted, "whitelisted", false, "whitelisted network, only stations listed for the SSID may connect")
+	this.flags.BoolVar(&ssid.FastRoaming, "f", false, "fast roaming (802.11r), clients move between access points without full authentication")
+	this.flags.BoolVar(&ssid.FastRoaming, "fast-roaming", false, "fast roaming (802.11r), clients move between access points without full authentication")
+	ssid.Radius = new(site.Radius)
+	this.flags.Var(listFlag{&ssid.Radius.AuthServers}, "radius-server", "comma separated RADIUS authentication servers of enterprise auth modes")
+	this.flags.IntVar(&ssid.Radius.AuthPort, "radius-port", 0, fmt.Sprintf("RADIUS authentication port, 0 is %d", site.RadiusAuthPort))
+	this.flags.StringVar(&ssid.Radius.AuthSecret, "radius-secret", "", "shared secret of RADIUS authentication servers")
+	this.flags.Var(listFlag{&ssid.Radius.AcctServers}, "acct-server", "comma separated RADIUS accounting servers")
+	this.flags.IntVar(&ssid.Radius.AcctPort, "acct-port", 0, fmt.Sprintf("RADIUS accounting port, 0 is %d", site.RadiusAcctPort))
+	this.flags.StringVar(&ssid.Radius.AcctSecret, "acct-secret", "", "shared secret of RADIUS accounting servers")
+	this.flags.StringVar(&ssid.Radius.NasId, "nas-id", "", "NAS identifier access points send to RADIUS servers, BSSID by default")
+	this.flags.BoolVar(&ssid.Radius.DynamicVlan, "dynamic-vlan", false, "put clients to VLANs assigned by RADIUS server")
+}
+
+// listFlag is a flag taking comma separated values.
+type listFlag struct {
+	values *[]string
+}
+
+func (this listFlag) String() string {
+	if this.values == nil {
+		return ""
+	}
+	return strings.Join(*this.values, ",")
+}
+
+func (this listFlag) Set(value string) error {
+	*this.values = strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' })
+	return nil
+}
+
+func findSsid(siteManager site.SiteManager, name string) (*site.SSID, error) {
+	for _, ssid := range siteManager.GetSSIDs() {
+		if ssid.Name == name {
+			return ssid, nil
+		}
+	}
+	return nil, errors.New("SSID \"" + name + "\" not found")
+}
+
+type ssidAdd struct {
+	SsidCommand
+	ssid *site.SSID
+}
+
+func (this *ssidAdd) Init() {
+	this.GenericCommand.Init()
+	this.usageMessage = "Usage: wnetctl ssid add <ssidName> <options>"
+	this.ssid = site.NewSSID()
+	this.ssidFlags(this.ssid)
+	this.applyFlags()
+}
+
+func (this *ssidAdd) ParseArgs(argv []string) error {
+	if err := this.flags.Parse(argv); err != nil {
+		this.helpRequested = true
+		return nil
+	}
+	if this.flags.NArg() > 1 || this.flags.NArg() == 1 && this.ssid.Name != "" {
+		this.helpRequested = true
+	} else if this.flags.NArg() == 1 {
+		this.ssid.Name = this.flags.Arg(0)
+	}
+	this.helpRequested = this.helpRequested || this.ssid.Name == ""
+	return nil
+}
+
+func (this *ssidAdd) Execute(ctx context.Context) error {
+	if this.helpRequested {
+		fmt.Println(this.HelpMessage())
+		return nil
+	}
+	if this.ssid.Vlan < 0 || this.ssid.Vlan > 4094 {
+		return fmt.Errorf("VLAN id must be in range 0..4094, got %d", this.ssid.Vlan)
+	}
+	if this.ssid.Radius.Empty() {
+		this.ssid.Radius = nil
+	}
+	siteManager, err := this.currentSiteManager()
+	if err != nil {
+		return err
+	}
+	defer siteManager.Close()
+	return printReport(siteManager.AddSSID(ctx, this.ssid))
+}
+
+type ssidList struct {
+	GenericCommand
+}
+
+func (this *ssidList) Init() {
+	this.GenericCommand.Init()
+	this.usageMessage = "Usage: wnetctl ssid list"
+}
+
+func (this *ssidList) ParseArgs(argv []string) error {
+	if err := this.flags.Parse(argv); err != nil {
+		this.helpRequested = true
+	}
+	if this.flags.NArg() > 0 {
+		this.helpRequested = true
+	}
+	return nil
+}
+
+func (this *ssidList) Execute(ctx context.Context) error {
+	if this.helpRequested {
+		fmt.Println(this.HelpMessage())
+		return nil
+	}
+	siteManager, err := config.GetCurrentSiteManager(getSiteManager)
+	if err != nil {
+		return err
+	}
+	for _, ssid := range siteManager.GetSSIDs() {
+		fmt.Println(ssid.String())
+	}
+	return nil
+}
+
+type ssidShow struct {
+	SsidCommand
+}
+
+func (this *ssidShow) Init() {
+	this.GenericCommand.Init()
+	this.usageMessage = "Usage: wnetctl ssid show <ssidName>"
+}
+
+func (this *ssidShow) ParseArgs(argv []string) error {
+	if err := this.flags.Parse(argv); err != nil {
+		this.helpRequested = true
+		return nil
+	}
+	if this.flags.NArg() != 1 {
+		this.helpRequested = true
+	} else {
+		this.name = this.flags.Arg(0)
+	}
+	return nil
+}
+
+func (this *ssidShow) Execute(ctx context.Context) error {
+	if this.helpRequested {
+		fmt.Println(this.HelpMessage())
+		return nil
+	}
+	siteManager, err := config.GetCurrentSiteManager(getSiteManager)
+	if err != nil {
+		return err
+	}
+	ssid, err := findSsid(siteManager, this.name)
+	if err != nil {
+		return err
+	}
+	info := []string{ssid.String(),
+		fmt.Sprintf("restricted: %t, whitelisted: %t, fast roaming: %t", ssid.Restricted, ssid.Whitelisted, ssid.FastRoaming),
+		ssid.Radius.String(),
+		fmt.Sprintf("stations: %d", len(ssid.Stations))}
+	fmt.Println(strings.Join(info, "\n  "))
+	return nil
+}
+
+type ssidUpdate struct {
+	SsidCommand
+	update *site.SSID
+}
+
+func (this *ssidUpdate) Init() {
+	this.GenericCommand.Init()
+	this.usageMessage = "Usage: wnetctl ssid update <ssidName> <options>\nOnly attributes passed in options are changed, " +
+		"RADIUS settings are dropped when auth mode is changed to a non-enterprise one, password when it is changed to a non-personal one"
+	this.update = site.NewSSID()
+	this.ssidFlags(this.update)
+	this.applyFlags()
+}
+
+func (this *ssidUpdate) ParseArgs(argv []string) error {
+	if err := this.flags.Parse(argv); err != nil {
+		this.helpRequested = true
+		return nil
+	}
+	if this.flags.NArg() != 1 {
+		this.helpRequested = true
+	} else {
+		this.name = this.flags.Arg(0)
+	}
+	return nil
+}
+
+func (this *ssidUpdate) Execute(ctx context.Context) error {
+	if this.helpRequested {
+		fmt.Println(this.HelpMessage())
+		return nil
+	}
+	siteManager, err := this.currentSiteManager()
+	if err != nil {
+		return err
+	}
+	defer siteManager.Close()
+	ssid, err := findSsid(siteManager, this.name)
+	if err != nil {
+		return err
+	}
+	rename, radiusSet, passwordSet := false, false, false
+	this.flags.Visit(func(f *flag.Flag) {
+		radiusSet = radiusSet || slices.Contains(radiusFlags, f.Name)
+		switch f.Name {
+		case "n", "name":
+			rename = this.update.Name != ssid.Name
+		case "a", "auth":
+			ssid.Auth = this.update.Auth
+		case "p", "password":
+			ssid.Password = this.update.Password
+			passwordSet = true
+		case "v", "vlan":
+			ssid.Vlan = this.update.Vlan
+		case "r", "restricted":
+			ssid.Restricted = this.update.Restricted
+		case "w", "whitelisted":
+			ssid.Whitelisted = this.update.Whitelisted
+		case "f", "fast-roaming":
+			ssid.FastRoaming = this.update.FastRoaming
+		case "radius-server":
+			ssidRadius(ssid).AuthServers = this.update.Radius.AuthServers
+		case "radius-port":
+			ssidRadius(ssid).AuthPort = this.update.Radius.AuthPort
+		case "radius-secret":
+			ssidRadius(ssid).AuthSecret = this.update.Radius.AuthSecret
+		case "acct-server":
+			ssidRadius(ssid).AcctServers = this.update.Radius.AcctServers
+		case "acct-port":
+			ssidRadius(ssid).AcctPort = this.update.Radius.AcctPort
+		case "acct-secret":
+			ssidRadius(ssid).AcctSecret = this.update.Radius.AcctSecret
+		case "nas-id":
+			ssidRadius(ssid).NasId = this.update.Radius.NasId
+		case "dynamic-vlan":
+			ssidRadius(ssid).DynamicVlan = this.update.Radius.DynamicVlan
+		}
+	})
+	// RADIUS settings are left behind when an enterprise SSID switches to another mode
+	if ssid.Radius.Empty() || !site.Enterprise(ssid.Auth) && !radiusSet {
+		ssid.Radius = nil
+	}
+	// as well as the password when a personal SSID does, a password given along with such mode is refused
+	if !site.Personal(ssid.Auth) && !passwordSet {
+		ssid.Password = ""
+	}
+	if ssid.Vlan < 0 || ssid.Vlan > 4094 {
+		return fmt.Errorf("VLAN id must be in range 0..4094, got %d", ssid.Vlan)
+	}
+	if rename {
+		ssid.Name = this.update.Name
+	}
+	return printReport(siteManager.UpdateSSID(ctx, this.name, ssid))
+}
+
+type ssidRemove struct {
+	GenericCommand
+	names []string
+}
+
+func (this *ssidRemove) Init() {
+	this.GenericCommand.Init()
+	this.usageMessage = "Usage: wnetctl ssid remove <ssidName ...>"
+	this.applyFlags()
+}
+
+func (this *ssidRemove) ParseArgs(argv []string) error {
+	if err := this.flags.Parse(argv); err != nil {
+		this.helpRequested = true
+		return nil
+	}
+	if this.flags.NArg() < 1 {
+		this.helpRequested = true
+		return nil
+	}
+	this.names = make([]string, this.flags.NArg())
+	copy(this.names, this.flags.Args())
+	return nil
+}
+
+func (this *ssidRemove) Execute(ctx context.Context) error {
+	if this.helpRequested {
+		fmt.Println(this.HelpMessage())
+		return nil
+	}
+	siteManager, err := this.currentSiteManager()
+	if err != nil {
+		return err
+	}
+	defer siteManager.Close()
+	for _, name := range this.names {
+		if err = printReport(siteManager.RemoveSSID(ctx, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// radiusFlags are options of RADIUS settings of enterprise SSIDs.
+var radiusFlags = []string{"radius-server", "radius-port", "radius-secret", "acct-server", "acct-port", "acct-secret", "nas-id", "dynamic-vlan"}
+
+// ssidRadius returns RADIUS settings of the SSID, creating them if it has none.
+func ssidRadius(ssid *site.SSID) *site.Radius {
+	if ssid.Radius == nil {
+		ssid.Radius = new(site.Radius)
+	}
+	return ssid.Radius
+}
+
+type ssidTestRadius struct {
+	SsidCommand
+	request *radius.Request
+	timeout time.Duration
+}
+
+func (this *ssidTestRadius) Init() {
+	this.GenericCommand.Init()
+	this.usageMessage = "Usage: wnetctl ssid test-radius <ssidName> <options>\n" +
+		"Send an Access-Request from this host to RADIUS authentication servers of the SSID to validate the shared secret,\n" +
+		"the host has to be a client of the servers. Any response proves the secret, silence means the host is not\n" +
+		"a client, the secret is wrong or the server is unreachable"
+	this.request = new(radius.Request)
+	this.flags.StringVar(&this.request.User, "u", "", "user name")
+	this.flags.StringVar(&this.request.User, "user", "", "user name")
+	this.flags.StringVar(&this.request.Password, "p", "", "user password")
+	this.flags.StringVar(&this.request.Password, "password", "", "user password")
+	this.flags.DurationVar(&this.timeout, "timeout", 5*time.Second, "time to wait for a response of each server")
+}
+
+func (this *ssidTestRadius) ParseArgs(argv []string) error {
+	if err := this.flags.Parse(argv); err != nil {
+		this.helpRequested = true
+		return nil
+	}
+	if this.flags.NArg() != 1 || this.request.User == "" {
+		this.helpRequested = true
+	} else {
+		this.name = this.flags.Arg(0)
+	}
+	return nil
+}
+
+func (this *ssidTestRadius) Execute(ctx context.Context) error {
+	if this.helpRequested {
+		fmt.Println(this.HelpMessage())
+		return nil
+	}
+	siteManager, err := config.GetCurrentSiteManager(getSiteManager)
+	if err != nil {
+		return err
+	}
+	ssid, err := findSsid(siteManager, this.name)
+	if err != nil {
+		return err
+	}
+	if ssid.Radius == nil || len(ssid.Radius.AuthServers) == 0 {
+		return errors.New("SSID \"" + ssid.Name + "\" has no RADIUS servers")
+	}
+	this.request.NasId = ssid.Radius.NasId
+	failed := 0
+	for _, address := range ssid.Radius.AuthAddresses() {
+		serverCtx, cancel := context.WithTimeout(ctx, this.timeout)
+		response, err := radius.Authenticate(serverCtx, address, ssid.Radius.AuthSecret, this.request)
+		cancel()
+		if err != nil {
+			failed++
+			fmt.Printf("%s: %s\n", address, err)
+		} else {
+			fmt.Printf("%s: %s\n", address, response)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d RADIUS server(s) of SSID \"%s\" did not validate the shared secret", failed, ssid.Name)
+	}
+	return nil
+}
@@ -0,0 +1,256 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"wnetctl/config"
+	"wnetctl/site"
+)
+
+func GetStationCommand(argv []string) Command {
+	var cmd Command
+	if len(argv) == 0 {
+		return stationHelp(true)
+	}
+	switch argv[0] {
+	case "add":
+		cmd = new(stationAdd)
+	case "list":
+		cmd = new(stationList)
+	case "remove":
+		cmd = new(stationRemove)
+	case "move":
+		cmd = new(stationMove)
+	default:
+		cmd = stationHelp(true)
+	}
+	cmd.Init()
+	if cmd.ParseArgs(argv[1:]) != nil {
+		return stationHelp(true)
+	}
+	return cmd
+}
+
+type stationHelp bool
+
+func (this stationHelp) Init() {
+}
+
+func (this stationHelp) HelpRequested() bool {
+	return true
+}
+
+func (this stationHelp) HelpMessage() string {
+	help := []string{"Usage: wnetctl station <command> [options]\nAvailable commands are:",
+		"add <ssidName> <mac> [-n name] [-c comment]",
+		"list <ssidName>",
+		"remove <ssidName> <mac ...>",
+		"move <ssidName> <mac> <targetSsidName>",
+		"help"}
+	msg := strings.Join(help, "\n  ")
+	help = []string{msg, "Use wnetctl station <command> -h for details about distinct command."}
+	return strings.Join(help, "\n")
+}
+
+func (this stationHelp) ParseArgs(argv []string) error {
+	return nil
+}
+
+func (this stationHelp) Execute(ctx context.Context) error {
+	fmt.Println(this.HelpMessage())
+	return nil
+}
+
+type stationCommand struct {
+	GenericCommand
+	ssid string
+	mac  string
+}
+
+// parseSsidAndMac parses options and expects SSID name followed by a station MAC address and exactly extra more arguments.
+func (this *stationCommand) parseSsidAndMac(argv []string, extra int) error {
+	if err := this.flags.Parse(argv); err != nil {
+		this.helpRequested = true
+		return nil
+	}
+	if this.flags.NArg() != 2+extra {
+		this.helpRequested = true
+		return nil
+	}
+	this.ssid = this.flags.Arg(0)
+	this.mac = this.flags.Arg(1)
+	return nil
+}
+
+// normalizeMac validates MAC address given in command line and converts it to the form stored in a site.
+func (this *stationCommand) normalizeMac() error {
+	mac, err := site.NormalizeMac(this.mac)
+	if err != nil {
+		return errors.New("Invalid station MAC address \"" + this.mac + "\"")
+	}
+	this.mac = mac
+	return nil
+}
+
+type stationAdd struct {
+	stationCommand
+	station *site.Station
+}
+
+func (this *stationAdd) Init() {
+	this.GenericCommand.Init()
+	this.usageMessage = "Usage: wnetctl station add <ssidName> <mac> <options>\nUpdates name and comment if station is already listed"
+	this.station = new(site.Station)
+	this.flags.StringVar(&this.station.Name, "n", "", "Station (client device) name")
+	this.flags.StringVar(&this.station.Name, "name", "", "Station (client device) name")
+	this.flags.StringVar(&this.station.Comment, "c", "", "Free form comment")
+	this.flags.StringVar(&this.station.Comment, "comment", "", "Free form comment")
+	this.applyFlags()
+}
+
+func (this *stationAdd) ParseArgs(argv []string) error {
+	return this.parseSsidAndMac(argv, 0)
+}
+
+func (this *stationAdd) Execute(ctx context.Context) error {
+	if this.helpRequested {
+		fmt.Println(this.HelpMessage())
+		return nil
+	}
+	if err := this.normalizeMac(); err != nil {
+		return err
+	}
+	siteManager, err := this.currentSiteManager()
+	if err != nil {
+		return err
+	}
+	defer siteManager.Close()
+	this.station.Mac = this.mac
+	return printReport(siteManager.AddStation(ctx, this.ssid, this.station))
+}
+
+type stationList struct {
+	stationCommand
+}
+
+func (this *stationList) Init() {
+	this.GenericCommand.Init()
+	this.usageMessage = "Usage: wnetctl station list <ssidName>"
+}
+
+func (this *stationList) ParseArgs(argv []string) error {
+	if err := this.flags.Parse(argv); err != nil {
+		this.helpRequested = true
+		return nil
+	}
+	if this.flags.NArg() != 1 {
+		this.helpRequested = true
+	} else {
+		this.ssid = this.flags.Arg(0)
+	}
+	return nil
+}
+
+func (this *stationList) Execute(ctx context.Context) error {
+	if this.helpRequested {
+		fmt.Println(this.HelpMessage())
+		return nil
+	}
+	siteManager, err := config.GetCurrentSiteManager(getSiteManager)
+	if err != nil {
+		return err
+	}
+	stations, err := siteManager.GetStations(this.ssid)
+	if err != nil {
+		return err
+	}
+	for _, station := range stations {
+		fmt.Printf("%s\t%s\t%s\n", station.Mac, station.Name, station.Comment)
+	}
+	return nil
+}
+
+type stationRemove struct {
+	stationCommand
+	macs []string
+}
+
+func (this *stationRemove) Init() {
+	this.GenericCommand.Init()
+	this.usageMessage = "Usage: wnetctl station remove <ssidName> <mac ...>"
+	this.applyFlags()
+}
+
+func (this *stationRemove) ParseArgs(argv []string) error {
+	if err := this.flags.Parse(argv); err != nil {
+		this.helpRequested = true
+		return nil
+	}
+	if this.flags.NArg() < 2 {
+		this.helpRequested = true
+		return nil
+	}
+	this.ssid = this.flags.Arg(0)
+	this.macs = make([]string, this.flags.NArg()-1)
+	copy(this.macs, this.flags.Args()[1:])
+	return nil
+}
+
+func (this *stationRemove) Execute(ctx context.Context) error {
+	if this.helpRequested {
+		fmt.Println(this.HelpMessage())
+		return nil
+	}
+	siteManager, err := this.currentSiteManager()
+	if err != nil {
+		return err
+	}
+	defer siteManager.Close()
+	for _, mac := range this.macs {
+		this.mac = mac
+		if err = this.normalizeMac(); err != nil {
+			return err
+		}
+		if err = printReport(siteManager.RemoveStation(ctx, this.ssid, this.mac)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type stationMove struct {
+	stationCommand
+	target string
+}
+
+func (this *stationMove) Init() {
+	this.GenericCommand.Init()
+	this.usageMessage = "Usage: wnetctl station move <ssidName> <mac> <targetSsidName>"
+	this.applyFlags()
+}
+
+func (this *stationMove) ParseArgs(argv []string) error {
+	if err := this.parseSsidAndMac(argv, 1); err != nil || this.helpRequested {
+		return err
+	}
+	this.target = this.flags.Arg(2)
+	return nil
+}
+
+func (this *stationMove) Execute(ctx context.Context) error {
+	if this.helpRequested {
+		fmt.Println(this.HelpMessage())
+		return nil
+	}
+	if err := this.normalizeMac(); err != nil {
+		return err
+	}
+	siteManager, err := this.currentSiteManager()
+	if err != nil {
+		return err
+	}
+	defer siteManager.Close()
+	return printReport(siteManager.MoveStation(ctx, this.ssid, this.mac, this.target))
+}
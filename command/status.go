@@ -0,0 +1,65 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"wnetctl/site"
+)
+
+type statusCommand struct {
+	GenericCommand
+}
+
+func GetStatusCommand(argv []string) Command {
+	cmd := new(statusCommand)
+	cmd.Init()
+	if cmd.ParseArgs(argv) != nil {
+		cmd.helpRequested = true
+	}
+	return cmd
+}
+
+func (this *statusCommand) Init() {
+	this.GenericCommand.Init()
+	this.usageMessage = "Usage: wnetctl status [-parallel n] [-timeout seconds]\n" +
+		"Check reachability, firmware, uptime, steering daemon and configuration drift of access points of the current site, " +
+		"failing if any access point is unreachable, its steering daemon is not running or its configuration differs from the site"
+	this.accessFlags()
+}
+
+func (this *statusCommand) ParseArgs(argv []string) error {
+	return this.flags.Parse(argv)
+}
+
+func (this *statusCommand) Execute(ctx context.Context) error {
+	siteManager, err := this.currentSiteManager()
+	if err != nil {
+		return err
+	}
+	defer siteManager.Close()
+	statuses, err := siteManager.Status(ctx)
+	site.WriteStatuses(os.Stdout, statuses)
+	if err != nil {
+		return err
+	}
+	unreachable, unknown, drifted, unsteered := 0, 0, 0, 0
+	for _, status := range statuses {
+		switch {
+		case !status.Reachable:
+			unreachable++
+		case status.Err != nil:
+			unknown++
+		case !status.InSync():
+			drifted++
+		}
+		if status.Reachable && !status.SteeringHealthy() {
+			unsteered++
+		}
+	}
+	if unreachable+unknown+drifted+unsteered > 0 {
+		return fmt.Errorf("%d access point(s) unreachable, %d failed to check, %d drifted from the site, %d without steering daemon running",
+			unreachable, unknown, drifted, unsteered)
+	}
+	return nil
+}
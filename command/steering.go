@@ -0,0 +1,130 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"wnetctl/config"
+	"wnetctl/site"
+)
+
+func GetSteeringCommand(argv []string) Command {
+	var cmd Command
+	if len(argv) == 0 {
+		return steeringHelp(true)
+	}
+	switch argv[0] {
+	case "show":
+		cmd = new(steeringShow)
+	case "set":
+		cmd = new(steeringSet)
+	default:
+		cmd = steeringHelp(true)
+	}
+	cmd.Init()
+	if cmd.ParseArgs(argv[1:]) != nil {
+		return steeringHelp(true)
+	}
+	return cmd
+}
+
+type steeringHelp bool
+
+func (this steeringHelp) Init() {
+}
+
+func (this steeringHelp) HelpRequested() bool {
+	return true
+}
+
+func (this steeringHelp) HelpMessage() string {
+	help := []string{"Usage: wnetctl steering <command> [options]\nAvailable commands are:",
+		"show",
+		"set <" + strings.Join(site.SteeringPolicies, "|") + "> [-min-signal dBm] [-roam-signal dBm] [-load-kick clients]",
+		"help"}
+	msg := strings.Join(help, "\n  ")
+	help = []string{msg, "Use wnetctl steering <command> -h for details about distinct command."}
+	return strings.Join(help, "\n")
+}
+
+func (this steeringHelp) ParseArgs(argv []string) error {
+	return nil
+}
+
+func (this steeringHelp) Execute(ctx context.Context) error {
+	fmt.Println(this.HelpMessage())
+	return nil
+}
+
+type steeringShow struct {
+	GenericCommand
+}
+
+func (this *steeringShow) Init() {
+	this.GenericCommand.Init()
+	this.usageMessage = "Usage: wnetctl steering show"
+}
+
+func (this *steeringShow) ParseArgs(argv []string) error {
+	if err := this.flags.Parse(argv); err != nil || this.flags.NArg() > 0 {
+		this.helpRequested = true
+	}
+	return nil
+}
+
+func (this *steeringShow) Execute(ctx context.Context) error {
+	if this.helpRequested {
+		fmt.Println(this.HelpMessage())
+		return nil
+	}
+	siteManager, err := config.GetCurrentSiteManager(getSiteManager)
+	if err != nil {
+		return err
+	}
+	fmt.Println(siteManager.GetSteering().String())
+	return nil
+}
+
+type steeringSet struct {
+	GenericCommand
+	steering *site.Steering
+}
+
+func (this *steeringSet) Init() {
+	this.GenericCommand.Init()
+	this.usageMessage = "Usage: wnetctl steering set <" + strings.Join(site.SteeringPolicies, "|") + "> <options>\n" +
+		"Install the steering daemon on access points of the current site and configure it, other daemons are stopped"
+	this.steering = new(site.Steering)
+	this.flags.IntVar(&this.steering.MinSignal, "min-signal", 0, "signal in dBm below which clients are refused and kicked, 0 keeps the daemon default")
+	this.flags.IntVar(&this.steering.RoamSignal, "roam-signal", 0, "signal in dBm below which clients are steered to a better access point, 0 keeps the daemon default")
+	this.flags.IntVar(&this.steering.LoadKickClients, "load-kick", 0, "number of clients above which they are moved to less loaded access points, 0 disables")
+	this.applyFlags()
+}
+
+func (this *steeringSet) ParseArgs(argv []string) error {
+	if len(argv) > 0 && !strings.HasPrefix(argv[0], "-") {
+		this.steering.Policy = argv[0]
+		argv = argv[1:]
+	}
+	if err := this.flags.Parse(argv); err != nil || this.flags.NArg() > 0 {
+		this.helpRequested = true
+	}
+	this.helpRequested = this.helpRequested || this.steering.Policy == ""
+	return nil
+}
+
+func (this *steeringSet) Execute(ctx context.Context) error {
+	if this.helpRequested {
+		fmt.Println(this.HelpMessage())
+		return nil
+	}
+	if err := this.steering.Validate(); err != nil {
+		return err
+	}
+	siteManager, err := this.currentSiteManager()
+	if err != nil {
+		return err
+	}
+	defer siteManager.Close()
+	return printReport(siteManager.SetSteering(ctx, this.steering))
+}
@@ -3,11 +3,14 @@ module wnetctl
 go 1.24
 
 require (
-	golang.org/x/crypto v0.39.0
+	github.com/google/uuid v1.6.0
+	github.com/pkg/sftp v1.13.10
+	golang.org/x/crypto v0.41.0
+	golang.org/x/term v0.34.0
 	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
-	github.com/google/uuid v1.6.0
-	golang.org/x/sys v0.33.0 // indirect
+	github.com/kr/fs v0.1.0 // indirect
+	golang.org/x/sys v0.35.0 // indirect
 )
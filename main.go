@@ -1,8 +1,11 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 	"wnetctl/command"
 )
 
@@ -12,13 +15,22 @@ func main() {
 	if cmd.HelpRequested() {
 		fmt.Println(cmd.HelpMessage())
 	} else {
-		if err := cmd.Execute(); err != nil {
+		// interrupt cancels remote commands in progress, so staged changes get reverted before exit
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if err := cmd.Execute(ctx); err != nil {
 			fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+			// non-zero exit status lets scripts and cron jobs detect the failure
+			stop()
+			os.Exit(1)
 		}
 	}
 }
 
 func handleCommandLine(argv []string) command.Command {
+	if len(argv) == 0 {
+		return command.Help(true)
+	}
 	switch argv[0] {
 	case "site":
 		return command.GetSiteCommand(argv[1:])
@@ -29,7 +41,15 @@ func handleCommandLine(argv []string) command.Command {
 	case "device":
 		return command.GetDeviceCommand(argv[1:])
 	case "station":
-		return command.Help(true)
+		return command.GetStationCommand(argv[1:])
+	case "steering":
+		return command.GetSteeringCommand(argv[1:])
+	case "plan":
+		return command.GetPlanCommand(argv[1:])
+	case "apply":
+		return command.GetApplyCommand(argv[1:])
+	case "status":
+		return command.GetStatusCommand(argv[1:])
 	case "help":
 		return command.Help(true)
 	}
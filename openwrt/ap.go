@@ -1,14 +1,19 @@
 package openwrt
 
 import (
+	"context"
 	"errors"
-	"golang.org/x/crypto/ssh"
-	"io"
+	"fmt"
+	"net"
+	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"text/template"
 	"wnetctl/site"
 	"wnetctl/sshclient"
+	"wnetctl/uci"
 )
 
 const TEMPLATES = "templates/openwrt/"
@@ -41,6 +46,7 @@ type AccessPoint struct {
 	Model string
 	Mac   string
 	Ip    string
+	Port  int
 	Wlan2 *WirelessAdapter
 	Wlan5 *WirelessAdapter
 	site  *Site
@@ -57,16 +63,82 @@ type AccessPointModel struct {
 	Model string
 	Mac   string
 	Ip    string
+	Port  int `yaml:",omitempty"`
 	WLan2 *WirelessAdapterModel
 	WLan5 *WirelessAdapterModel
 }
 
+// SSIDModel is the data rendered by add-ssid, remove-ssid and stations templates for a single SSID on an access point.
 type SSIDModel struct {
+	Vlan          int
+	Network       string
+	VlanDevice    string
+	VlanIfname    string
+	Bridge        string
+	BridgeName    string
+	MainBridge    string
+	NetworkShared bool
+	Country       string
+	Ifaces        []*WifiIfaceModel
+}
+
+// WifiIfaceModel describes wifi-iface section of an SSID on one radio of an access point.
+type WifiIfaceModel struct {
+	Section    string
+	Radio      string
+	Ssid       string
+	Encryption string
+	Key        string
+	// Ieee80211w is management frame protection, empty leaves the default of the encryption
+	Ieee80211w string
+	Isolate    bool
+	MacFilter  bool
+	MacList    []string
+	// 802.1X settings of enterprise SSIDs, with dynamic VLANs hostapd bridges VlanTaggedInterface.<vid>
+	// to VlanBridge<vid> bridge; these are VLAN device and bridge of SSIDs with the VLAN, hostapd reuses them
+	AuthServers         []string
+	AuthPort            int
+	AuthSecret          string
+	AcctServers         []string
+	AcctPort            int
+	AcctSecret          string
+	DynamicVlan         bool
+	VlanTaggedInterface string
+	VlanBridge          string
+	// NasId is NAS identifier of RADIUS requests and R0KH-ID of fast roaming
+	NasId string
+	// fast roaming (802.11r) settings, the access point uses Bssid as MAC address of the SSID on the radio
+	FastRoaming    bool
+	Bssid          string
+	MobilityDomain string
+	R0kh           []string
+	R1kh           []string
+}
+
+// radio is a wireless adapter of an access point along with its band and the SSID suffix of the band.
+type radio struct {
+	adapter *WirelessAdapter
+	band    string
+	suffix  string
 }
 
 const accessPointAdmin = "root"
 const defaultChannel2G = 6
 const defaultChannel5G = 40
+const mainBridge = "br-lan"
+
+// vlanBridgePrefix is the name of the bridge of an SSID VLAN without the VLAN id.
+const vlanBridgePrefix = "br-vlan"
+const defaultNetwork = "lan"
+const sectionPrefix = "wnet_"
+
+const addSSIDTemplate = "add-ssid"
+const removeSSIDTemplate = "remove-ssid"
+const roamingTemplate = "roaming"
+const stationsTemplate = "stations"
+
+// reloadCommand makes network and wireless subsystems pick up committed changes.
+const reloadCommand = "/etc/init.d/network reload"
 
 func NewWirelessAdapter() *WirelessAdapter {
 	return new(WirelessAdapter)
@@ -77,10 +149,17 @@ func CreateAccessPoint(request *site.AccessPointRequest, site *Site) (*AccessPoi
 	if !exists {
 		return nil, errors.New("Access point type " + request.Model + " does not exist")
 	}
-	ap := AccessPoint{site: site, name: request.Name, Model: device.Name, Mac: request.Mac, Ip: request.Ip}
-	ap.Wlan2.Device = device.Wlan2
-	ap.Wlan5.Device = device.Wlan5
-	ap.Wlan5.Channel = defaultChannel5G
+	ap := AccessPoint{site: site, name: request.Name, Model: device.Name, Mac: request.Mac, Ip: request.Ip, Port: request.Port}
+	if device.Wlan2 != nil {
+		ap.Wlan2 = NewWirelessAdapter()
+		ap.Wlan2.Device = device.Wlan2
+		ap.Wlan2.Channel = defaultChannel2G
+	}
+	if device.Wlan5 != nil {
+		ap.Wlan5 = NewWirelessAdapter()
+		ap.Wlan5.Device = device.Wlan5
+		ap.Wlan5.Channel = defaultChannel5G
+	}
 	// TODO : discover MAC addresses for both wired and wireless adapters
 	return &ap, nil
 }
@@ -90,14 +169,14 @@ func NewAccessPoint(model *AccessPointModel, site *Site) (*AccessPoint, error) {
 	if !exists {
 		return nil, errors.New("Access point type " + model.Model + " does not exist")
 	}
-	ap := AccessPoint{site: site, name: model.Name, Model: device.Name, Mac: model.Mac, Ip: model.Ip}
-	if model.WLan2 != nil {
+	ap := AccessPoint{site: site, name: model.Name, Model: device.Name, Mac: model.Mac, Ip: model.Ip, Port: model.Port}
+	if device.Wlan2 != nil {
 		ap.Wlan2 = new(WirelessAdapter)
-		modelToWirelessAdapter(ap.Wlan2, model.WLan2, device.Wlan2)
+		modelToWirelessAdapter(ap.Wlan2, model.WLan2, device.Wlan2, defaultChannel2G)
 	}
-	if model.WLan5 != nil {
+	if device.Wlan5 != nil {
 		ap.Wlan5 = new(WirelessAdapter)
-		modelToWirelessAdapter(ap.Wlan5, model.WLan5, device.Wlan5)
+		modelToWirelessAdapter(ap.Wlan5, model.WLan5, device.Wlan5, defaultChannel5G)
 	}
 	return &ap, nil
 }
@@ -106,100 +185,190 @@ func (this *AccessPoint) Name() string {
 	return this.name
 }
 
-func (this *AccessPoint) Configure() error {
-	sshClient := sshclient.NewSshClient(this.Ip, accessPointAdmin, "", "")
-	if err := installSshPublicKey(sshClient, this.site.sshPublicKey); err != nil {
+func (this *AccessPoint) Configure(ctx context.Context) error {
+	if err := this.bootstrap(ctx); err != nil {
 		return err
 	}
-	sshClient.SetKey(this.site.sshKey)
-	if err := sshClient.Connect(); err != nil {
+	report, err := this.site.apply(ctx, "Configuring access point "+this.name, []*AccessPoint{this}, func(ap *AccessPoint) (*sshclient.Script, error) {
+		return ap.configScript()
+	})
+	if err != nil {
 		return err
 	}
-	if err := sshClient.ExecuteInteractive(sshclient.NewPasswd(accessPointAdmin, "", this.site.password)); err != nil {
+	return this.site.refreshNeighbourReports(ctx, report)
+}
+
+// bootstrap prepares freshly installed access point to be managed: installs site SSH key, sets root password
+// and installs the steering daemon of the site.
+// The connection with empty password is closed once the key is installed, following configuration goes through
+// a pooled connection authenticated with site credentials, the site key or the site password it falls back to.
+func (this *AccessPoint) bootstrap(ctx context.Context) error {
+	sshClient, err := this.newSshClient("", "")
+	if err != nil {
+		return err
+	}
+	sshClient.SetHostKeyCallback(this.site.knownHosts.HostKeyCallback(sshclient.TrustOnFirstUse))
+	if err := sshClient.Connect(ctx); err != nil {
+		return err
+	}
+	err = sshclient.InstallSshKey(ctx, sshClient, this.site.sshPublicKey)
+	if err == nil {
+		err = sshClient.ExecuteInteractive(ctx, sshclient.NewPasswd(accessPointAdmin, "", this.site.password))
+	}
+	sshClient.Close()
+	if err != nil {
+		return err
+	}
+	if _, err = this.connect(ctx); err != nil {
 		return err
 	}
 	// TODO disable password auth for SSH, set TZ, enable NTP; render initial template
-	// TODO install usteer (optional?)
-	// TODO render SSID template for each SSID defined
-	/*
-		scriptBuilder := new(strings.Builder)
-		for _, ssid := range this.site.ssids {
-			ssidModel := buildSSIDModel(this.site, this.name)
-			if err := renderCommands(scriptBuilder, "add_ssid", ssidModel); err != nil {
-				return err
-			}
-		}
-		commands := strings.Split(scriptBuilder.String(), "\n")
-		for _, command := range commands {
-			if err := sshClient.Execute(command); err != nil {
-				return err
-			}
+	return this.setupSteering(ctx)
+}
+
+// configScript renders uci commands adding all SSIDs of the site to the access point and configuring its steering daemon.
+func (this *AccessPoint) configScript() (*sshclient.Script, error) {
+	script := sshclient.NewScript()
+	for _, ssid := range this.site.ssids {
+		commands, err := this.ssidScript(addSSIDTemplate, ssid)
+		if err != nil {
+			return nil, err
 		}
-	*/
-	return nil
+		script.Append(commands)
+	}
+	commands, err := this.steeringScript()
+	script.Append(commands)
+	return script, err
+}
+
+func (this *AccessPoint) AddNeighbour(ctx context.Context, neighbour site.AccessPoint) error {
+	return this.updateNeighbour(ctx, "Adding neighbour ", neighbour, true)
 }
 
-func installSshPublicKey(sshClient sshclient.SshClient, pubkeyPath string) error {
-	err := sshClient.Connect()
+func (this *AccessPoint) RemoveNeighbour(ctx context.Context, neighbour site.AccessPoint) error {
+	return this.updateNeighbour(ctx, "Removing neighbour ", neighbour, false)
+}
+
+func (this *AccessPoint) updateNeighbour(ctx context.Context, operation string, neighbour site.AccessPoint, add bool) error {
+	peer, ok := neighbour.(*AccessPoint)
+	if !ok || peer.site != this.site {
+		return errors.New("Access point " + neighbour.Name() + " is not a member of the site")
+	}
+	_, err := this.site.apply(ctx, operation+peer.name, []*AccessPoint{this}, func(ap *AccessPoint) (*sshclient.Script, error) {
+		return ap.neighbourScript(peer, add)
+	})
 	if err != nil {
 		return err
 	}
-	err = sshClient.Execute("/bin/test -d /root/.sshclient")
-	if err != nil {
-		_, exitError := err.(*ssh.ExitError)
-		if exitError {
-			if err = sshClient.Execute("/bin/mkdir /root/.sshclient"); err != nil {
-				return err
-			}
-			if err = sshClient.Execute("/bin/chmod 0700 /root/.sshclient"); err != nil {
-				return err
-			}
+	return this.site.updateNeighbourReports(ctx, []*AccessPoint{this}, this.site.roamingPeers(peer, add))
+}
+
+// neighbourScript renders uci commands replacing fast roaming key holder lists of the access point SSIDs with lists
+// of site access points the neighbour is added to or removed from. Lists are rewritten as a whole, so they are kept
+// in the order add-ssid template renders them.
+func (this *AccessPoint) neighbourScript(neighbour *AccessPoint, add bool) (*sshclient.Script, error) {
+	peers := this.site.roamingPeers(neighbour, add)
+	model := new(SSIDModel)
+	for _, ssid := range this.site.ssids {
+		if !ssid.FastRoaming {
+			continue
+		}
+		r0kh, r1kh := this.site.keyHolders(peers, ssid)
+		ssidModel, err := buildSSIDModel(this, ssid)
+		if err != nil {
+			return nil, err
+		}
+		for _, iface := range ssidModel.Ifaces {
+			iface.R0kh, iface.R1kh = r0kh, r1kh
+			model.Ifaces = append(model.Ifaces, iface)
 		}
 	}
-	if err = sshClient.ExecuteInteractive(sshclient.NewInstallSshKey(pubkeyPath)); err != nil {
+	return renderScript(roamingTemplate, model)
+}
+
+func (this *AccessPoint) AddSSID(ctx context.Context, ssid *site.SSID) error {
+	report, err := this.site.apply(ctx, "Adding SSID "+ssid.Name, []*AccessPoint{this}, func(ap *AccessPoint) (*sshclient.Script, error) {
+		return ap.ssidScript(addSSIDTemplate, siteSsidToSsid(ssid))
+	})
+	if err != nil {
 		return err
 	}
-	if err = sshClient.Execute("/bin/chmod 0600 /root/.sshclient/authorized_keys"); err != nil {
+	return this.site.refreshNeighbourReports(ctx, report)
+}
+
+func (this *AccessPoint) RemoveSSID(ctx context.Context, ssid *site.SSID) error {
+	report, err := this.site.apply(ctx, "Removing SSID "+ssid.Name, []*AccessPoint{this}, func(ap *AccessPoint) (*sshclient.Script, error) {
+		return ap.ssidScript(removeSSIDTemplate, siteSsidToSsid(ssid))
+	})
+	if err != nil {
 		return err
 	}
-	return sshClient.Close()
+	return this.site.refreshNeighbourReports(ctx, report)
 }
 
-func (this *AccessPoint) AddNeighbour(neighbour site.AccessPoint) error {
-	// var commands, rollbackCmds []string
-
-	// commands = append(commands, "uci add-list ... "+buildNeighborRoamingValue(neighbour))
-	// TODO create model object
-
-	return nil
-	// return sshClient.Execute(this.IPAddress, commands, rollbackCmds)
+// ssidScript renders uci commands adding or removing the SSID on the access point.
+func (this *AccessPoint) ssidScript(scriptTemplate string, ssid *SSID) (*sshclient.Script, error) {
+	model, err := buildSSIDModel(this, ssid)
+	if err != nil {
+		return nil, err
+	}
+	return renderScript(scriptTemplate, model)
 }
 
-func (this *AccessPoint) RemoveNeighbour(neighbour site.AccessPoint) error {
-	//TODO implement me
-	panic("implement me")
+// connect returns pooled SSH connection to the access point, opening it with site credentials if needed.
+// Host key must be known.
+func (this *AccessPoint) connect(ctx context.Context) (sshclient.SshClient, error) {
+	return this.site.clients.Get(ctx, this.name, this.dial)
 }
 
-func (this *AccessPoint) AddSSID(ssid *site.SSID) error {
-	// var commands, rollbackCmds []string
-	// TODO create model object
-	//TODO add commands creating vlan if any
+func (this *AccessPoint) dial(ctx context.Context) (sshclient.SshClient, error) {
+	return this.connectWith(ctx, sshclient.VerifyHostKey)
+}
 
-	// return this.sshClient.Execute(this.IPAddress, commands, rollbackCmds)
-	panic("implement me")
+func (this *AccessPoint) connectWith(ctx context.Context, policy sshclient.HostKeyPolicy) (sshclient.SshClient, error) {
+	sshClient, err := this.newSshClient(this.site.password, this.site.sshKey)
+	if err != nil {
+		return nil, err
+	}
+	sshClient.SetHostKeyCallback(this.site.knownHosts.HostKeyCallback(policy))
+	if err := sshClient.Connect(ctx); err != nil {
+		return nil, err
+	}
+	return sshClient, nil
 }
 
-func (this *AccessPoint) RemoveSSID(ssid *site.SSID) error {
-	//TODO implement me
-	panic("implement me")
+// newSshClient creates client of the access point SSH port, tunnelled through site jump hosts if any.
+// Passphrase of the site key is asked for here, as jump hosts may use the key even if the client does not.
+func (this *AccessPoint) newSshClient(password, sshKey string) (sshclient.SshClient, error) {
+	passphrase, err := this.site.keyPassphrase()
+	if err != nil {
+		return nil, err
+	}
+	sshClient := sshclient.NewSshClient(this.Ip, accessPointAdmin, password, sshKey)
+	sshClient.SetPassphrase(passphrase)
+	sshClient.SetPort(this.Port)
+	sshClient.SetJumpHosts(this.site.sshJumpHosts(passphrase)...)
+	return sshClient, nil
 }
 
-func (this *AccessPoint) AddStation(mac string) error {
-	return nil
+// address is the access point SSH address as host keys are recorded for.
+func (this *AccessPoint) address() string {
+	port := this.Port
+	if port <= 0 {
+		port = sshclient.DefaultPort
+	}
+	return net.JoinHostPort(this.Ip, strconv.Itoa(port))
 }
 
-func (this *AccessPoint) RemoveStation(mac string) error {
-	return nil
+// UpdateStations replaces the station list of the SSID on the access point.
+func (this *AccessPoint) UpdateStations(ctx context.Context, ssid *site.SSID) error {
+	report, err := this.site.apply(ctx, "Updating stations of SSID "+ssid.Name, []*AccessPoint{this}, func(ap *AccessPoint) (*sshclient.Script, error) {
+		return ap.ssidScript(stationsTemplate, siteSsidToSsid(ssid))
+	})
+	if err != nil {
+		return err
+	}
+	return this.site.refreshNeighbourReports(ctx, report)
 }
 
 func (this *AccessPoint) ToResponse() *site.AccessPointResponse {
@@ -208,6 +377,7 @@ func (this *AccessPoint) ToResponse() *site.AccessPointResponse {
 	model.Model = this.Model
 	model.Mac = this.Mac
 	model.Ip = this.Ip
+	model.Port = this.Port
 	return model
 }
 
@@ -217,21 +387,141 @@ func (this *AccessPoint) export() *AccessPointModel {
 	model.Model = this.Model
 	model.Mac = this.Mac
 	model.Ip = this.Ip
+	model.Port = this.Port
+	if this.Wlan2 != nil {
+		model.WLan2 = wirelessAdapterToModel(this.Wlan2)
+	}
+	if this.Wlan5 != nil {
+		model.WLan5 = wirelessAdapterToModel(this.Wlan5)
+	}
 	return model
 }
 
-func buildSSIDModel(site *Site, targetAp string) *SSIDModel {
+func buildSSIDModel(ap *AccessPoint, ssid *SSID) (*SSIDModel, error) {
+	mode, err := authModeOf(ssid)
+	if err != nil {
+		return nil, err
+	}
 	model := new(SSIDModel)
-	// TODO
-	return model
+	model.Vlan = ssid.Vlan
+	model.MainBridge = mainBridge
+	model.Country = ap.site.country
+	if ssid.Vlan > 0 {
+		vlan := fmt.Sprintf("vlan%d", ssid.Vlan)
+		model.Network = sectionPrefix + vlan
+		model.VlanDevice = sectionPrefix + vlan + "_dev"
+		model.VlanIfname = fmt.Sprintf("%s.%d", mainBridge, ssid.Vlan)
+		model.Bridge = sectionPrefix + vlan + "_br"
+		model.BridgeName = fmt.Sprintf("%s%d", vlanBridgePrefix, ssid.Vlan)
+		model.NetworkShared = slices.ContainsFunc(ap.site.ssids, func(s *SSID) bool {
+			return s.Vlan == ssid.Vlan && s.Name != ssid.Name
+		})
+	} else {
+		model.Network = defaultNetwork
+	}
+	var r0kh, r1kh []string
+	if ssid.FastRoaming {
+		r0kh, r1kh = ap.site.keyHolders(ap.site.sortedAccessPoints(), ssid)
+	}
+	for _, radio := range ap.radios() {
+		iface := new(WifiIfaceModel)
+		iface.Section = sectionPrefix + sectionName(ssid.Name) + "_" + radio.band
+		iface.Radio = radio.adapter.Device.Interface
+		iface.Ssid = ssid.Name + radio.suffix
+		iface.Encryption = mode.encryption
+		iface.Ieee80211w = mode.ieee80211w
+		if mode.psk {
+			iface.Key = ssid.Password
+		}
+		iface.Isolate = ssid.Restricted
+		iface.MacFilter = ssid.Whitelisted
+		for _, station := range ssid.Stations {
+			iface.MacList = append(iface.MacList, station.Mac)
+		}
+		if radius := ssid.Radius; radius != nil && mode.enterprise() {
+			iface.AuthServers, iface.AuthPort, iface.AuthSecret = radius.AuthServers, radius.AuthPort, radius.AuthSecret
+			iface.AcctServers, iface.AcctPort, iface.AcctSecret = radius.AcctServers, radius.AcctPort, radius.AcctSecret
+			iface.NasId = radius.NasId
+			if radius.DynamicVlan {
+				iface.DynamicVlan = true
+				iface.VlanTaggedInterface = mainBridge
+				iface.VlanBridge = vlanBridgePrefix
+			}
+		}
+		if ssid.FastRoaming {
+			iface.FastRoaming = true
+			iface.Bssid = roamingBssid(ap, ssid, radio.band)
+			iface.MobilityDomain = mobilityDomain(ssid)
+			iface.NasId = nasId(iface.Bssid)
+			iface.R0kh, iface.R1kh = r0kh, r1kh
+		}
+		model.Ifaces = append(model.Ifaces, iface)
+	}
+	return model, nil
+}
+
+// radios returns wireless adapters of the access point which have a device, 2.4GHz one first.
+func (this *AccessPoint) radios() []*radio {
+	var radios []*radio
+	for _, r := range []*radio{{this.Wlan2, "2g", this.site.suffix2}, {this.Wlan5, "5g", this.site.suffix5}} {
+		if r.adapter != nil && r.adapter.Device != nil {
+			radios = append(radios, r)
+		}
+	}
+	return radios
+}
+
+// sectionName converts an arbitrary name to a string usable as (a part of) uci section name.
+func sectionName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		if r >= 'A' && r <= 'Z' {
+			return r - 'A' + 'a'
+		}
+		return '_'
+	}, name)
 }
 
-func buildNeighborRoamingValue(point *AccessPoint) string {
-	// TODO complete parameter generation
-	return strings.ReplaceAll(point.Mac, ":", "_")
+// sourceMarker delimits template line numbers appended to template lines before rendering.
+const sourceMarker = "\x1e"
+
+// renderScript renders the template to a script which lines keep numbers of template lines they come from.
+func renderScript(scriptTemplate string, data interface{}) (*sshclient.Script, error) {
+	source, err := os.ReadFile(filepath.Clean(TEMPLATES + scriptTemplate))
+	if err != nil {
+		return nil, err
+	}
+	gotmpl, err := template.New(scriptTemplate).
+		Funcs(template.FuncMap{"quote": uci.Quote}).
+		Parse(annotateTemplate(string(source)))
+	if err != nil {
+		return nil, err
+	}
+	out := new(strings.Builder)
+	if err = gotmpl.Execute(out, data); err != nil {
+		return nil, err
+	}
+	script := sshclient.NewScript()
+	for _, line := range strings.Split(out.String(), "\n") {
+		command, markers, _ := strings.Cut(line, sourceMarker)
+		lineNo, _ := strconv.Atoi(strings.SplitN(markers, sourceMarker, 2)[0])
+		script.Add(command, scriptTemplate, lineNo)
+	}
+	return script, nil
 }
 
-func renderCommands(out io.Writer, scriptTemplate string, data interface{}) error {
-	gotmpl := template.Must(template.New(scriptTemplate).Parse(filepath.Clean(TEMPLATES + scriptTemplate)))
-	return gotmpl.Execute(out, data)
+// annotateTemplate appends line number wrapped in source markers to each template line which does not end inside
+// of an action, so every rendered line carries number of the template line it was produced from.
+func annotateTemplate(source string) string {
+	lines := strings.Split(source, "\n")
+	depth := 0
+	for i, line := range lines {
+		depth += strings.Count(line, "{{") - strings.Count(line, "}}")
+		if depth == 0 {
+			lines[i] = line + sourceMarker + strconv.Itoa(i+1) + sourceMarker
+		}
+	}
+	return strings.Join(lines, "\n")
 }
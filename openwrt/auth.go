@@ -0,0 +1,169 @@
+package openwrt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"wnetctl/site"
+	"wnetctl/sshclient"
+)
+
+// authMode is how an SSID authentication mode is configured on OpenWrt: wifi-iface encryption, management
+// frame protection (ieee80211w, 1 is optional and 2 required), whether the SSID password is the key and
+// the hostapd feature wpad has to be built with.
+type authMode struct {
+	encryption string
+	ieee80211w string
+	psk        bool
+	feature    string
+}
+
+var authModes = map[string]*authMode{
+	site.AuthOpen:          {encryption: "none"},
+	site.AuthOwe:           {encryption: "owe", ieee80211w: "2", feature: "owe"},
+	site.AuthWpa2Psk:       {encryption: "psk2+ccmp", psk: true},
+	site.AuthWpa3Sae:       {encryption: "sae", ieee80211w: "2", psk: true, feature: "sae"},
+	site.AuthWpa2Wpa3Mixed: {encryption: "sae-mixed", ieee80211w: "1", psk: true, feature: "sae"},
+	site.AuthWpa2Eap:       {encryption: "wpa2+ccmp", feature: "eap"},
+	site.AuthWpa3Eap:       {encryption: "wpa3", ieee80211w: "2", feature: "eap"},
+}
+
+// enterprise tells whether clients are authenticated by a RADIUS server.
+func (this *authMode) enterprise() bool {
+	return this.feature == "eap"
+}
+
+// legacyAuthModes map authentication modes of sites created before modes were validated, when they were
+// OpenWrt encryption values passed through as they are, to validated modes.
+// Empty mode is resolved by defaultAuth.
+var legacyAuthModes = map[string]string{
+	"none":      site.AuthOpen,
+	"psk2":      site.AuthWpa2Psk,
+	"psk2+ccmp": site.AuthWpa2Psk,
+	"psk2+aes":  site.AuthWpa2Psk,
+	"sae":       site.AuthWpa3Sae,
+	"sae-mixed": site.AuthWpa2Wpa3Mixed,
+	"wpa2":      site.AuthWpa2Eap,
+	"wpa2+ccmp": site.AuthWpa2Eap,
+	"wpa3":      site.AuthWpa3Eap,
+}
+
+// authModeOf returns settings of the SSID authentication mode.
+func authModeOf(ssid *SSID) (*authMode, error) {
+	mode, ok := authModes[ssid.Auth]
+	if !ok {
+		return nil, fmt.Errorf("Unknown authentication mode \"%s\" of SSID \"%s\", expected one of %s", ssid.Auth, ssid.Name,
+			strings.Join(site.AuthModes, ", "))
+	}
+	return mode, nil
+}
+
+// defaultAuth sets authentication mode of the SSID which has none: WPA2 personal when the SSID has a password,
+// as templates rendered such SSIDs before modes were introduced, open otherwise.
+func defaultAuth(ssid *SSID) {
+	if ssid.Auth != "" {
+		return
+	}
+	ssid.Auth = site.AuthOpen
+	if ssid.Password != "" {
+		ssid.Auth = site.AuthWpa2Psk
+	}
+}
+
+// upgradeAuth replaces legacy authentication mode of the SSID loaded from a site file with the validated one,
+// unknown modes are refused rather than rendered as something else.
+func upgradeAuth(ssid *SSID) error {
+	defaultAuth(ssid)
+	if auth, ok := legacyAuthModes[ssid.Auth]; ok {
+		ssid.Auth = auth
+	}
+	_, err := authModeOf(ssid)
+	return err
+}
+
+// validateAuth checks that authentication mode of the SSID is known and its password fits the mode.
+func validateAuth(ssid *SSID) error {
+	mode, err := authModeOf(ssid)
+	if err != nil {
+		return err
+	}
+	if mode.psk && (len(ssid.Password) < site.MinPasswordLength || len(ssid.Password) > site.MaxPasswordLength) {
+		return fmt.Errorf("Password of SSID \"%s\" must be %d..%d characters long for %s authentication", ssid.Name,
+			site.MinPasswordLength, site.MaxPasswordLength, ssid.Auth)
+	}
+	if !mode.psk && ssid.Password != "" {
+		return fmt.Errorf("SSID \"%s\" with %s authentication does not use password, a personal mode such as %s is required",
+			ssid.Name, ssid.Auth, site.AuthWpa2Psk)
+	}
+	if !mode.enterprise() {
+		if ssid.Radius != nil {
+			return errors.New("RADIUS servers of SSID \"" + ssid.Name + "\" are used by enterprise authentication modes only")
+		}
+		return nil
+	}
+	radius := ssid.Radius
+	if radius == nil || len(radius.AuthServers) == 0 || radius.AuthSecret == "" {
+		return fmt.Errorf("SSID \"%s\" with %s authentication requires RADIUS server and shared secret", ssid.Name, ssid.Auth)
+	}
+	if len(radius.AcctServers) > 0 && radius.AcctSecret == "" {
+		return errors.New("Accounting servers of SSID \"" + ssid.Name + "\" require shared secret")
+	}
+	for _, port := range []int{radius.AuthPort, radius.AcctPort} {
+		if port < 0 || port > 65535 {
+			return fmt.Errorf("RADIUS port must be in range 1..65535, got %d", port)
+		}
+	}
+	if radius.NasId != "" && ssid.FastRoaming {
+		return errors.New("NAS identifier of SSID \"" + ssid.Name + "\" can not be set along with fast roaming, which derives it from BSSID")
+	}
+	return nil
+}
+
+// checkAuthSupport makes sure wpad of the access points supports authentication modes of SSIDs before they are pushed.
+func (this *Site) checkAuthSupport(ctx context.Context, operation string, aps []*AccessPoint, ssids []*SSID) error {
+	results := this.forEach(ctx, aps, this.options.Timeout, func(ctx context.Context, ap *AccessPoint, result *site.AccessPointResult) error {
+		if err := ap.checkAuthSupport(ctx, ssids); err != nil {
+			return err
+		}
+		result.Status = site.StatusUnchanged
+		return nil
+	})
+	for _, result := range results {
+		if result.Err != nil {
+			return &site.ApplyError{Operation: operation, Results: results}
+		}
+	}
+	return ctx.Err()
+}
+
+// checkAuthSupport asks hostapd of the access point about features authentication modes of SSIDs need.
+// OpenWrt hostapd exits with zero status of hostapd -v<feature> when it is built with the feature, basic
+// and mini wpad variants lack some of them.
+func (this *AccessPoint) checkAuthSupport(ctx context.Context, ssids []*SSID) error {
+	sshClient, err := this.connect(ctx)
+	if err != nil {
+		return err
+	}
+	checked := make(map[string]bool)
+	for _, ssid := range ssids {
+		mode, err := authModeOf(ssid)
+		if err != nil {
+			return err
+		}
+		if mode.feature == "" || checked[mode.feature] {
+			continue
+		}
+		checked[mode.feature] = true
+		_, err = sshClient.Run(ctx, "/usr/sbin/hostapd -v"+mode.feature)
+		var execErr *sshclient.CommandsExecutionError
+		if errors.As(err, &execErr) {
+			return fmt.Errorf("Installed wpad variant does not support %s authentication of SSID \"%s\", "+
+				"a full variant such as wpad-mbedtls is required", ssid.Auth, ssid.Name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
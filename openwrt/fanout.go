@@ -0,0 +1,54 @@
+package openwrt
+
+import (
+	"context"
+	"sync"
+	"time"
+	"wnetctl/site"
+)
+
+// forEach runs the task for each of access points concurrently. At most Parallelism tasks of site apply options
+// run at once, each one limited by Timeout. Results are returned in order of access points; a task which failed
+// is reported with StatusFailed, the task sets any other status of its result itself.
+func (this *Site) forEach(ctx context.Context, aps []*AccessPoint, timeout time.Duration,
+	task func(ctx context.Context, ap *AccessPoint, result *site.AccessPointResult) error) []*site.AccessPointResult {
+	results := make([]*site.AccessPointResult, len(aps))
+	parallelism := this.options.Parallelism
+	if parallelism <= 0 || parallelism > len(aps) {
+		parallelism = len(aps)
+	}
+	slots := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+	for i, ap := range aps {
+		results[i] = &site.AccessPointResult{Name: ap.Name(), Status: site.StatusNotStarted}
+		wg.Add(1)
+		go func(result *site.AccessPointResult) {
+			defer wg.Done()
+			select {
+			case slots <- struct{}{}:
+				defer func() { <-slots }()
+			case <-ctx.Done():
+				result.Err = ctx.Err()
+				return
+			}
+			apCtx, cancel := withOptionalTimeout(ctx, timeout)
+			defer cancel()
+			start := time.Now()
+			if err := task(apCtx, ap, result); err != nil {
+				result.Status = site.StatusFailed
+				result.Err = err
+			}
+			result.Duration += time.Since(start)
+		}(results[i])
+	}
+	wg.Wait()
+	return results
+}
+
+// withOptionalTimeout derives context limited by timeout, zero timeout means no limit.
+func withOptionalTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
@@ -1,8 +1,10 @@
 package openwrt
 
 import (
+	"slices"
 	"strings"
 	"wnetctl/site"
+	"wnetctl/uci"
 )
 
 func siteRequestToModel(request *site.SiteRequest) *SiteModel {
@@ -10,14 +12,42 @@ func siteRequestToModel(request *site.SiteRequest) *SiteModel {
 	model.SshKey = request.SshKey
 	model.SshPublicKey = request.SshPublicKey
 	model.Password = request.Password
+	model.Country = request.Country
+	model.SsidSuffix2 = request.SsidSuffix2
+	model.SsidSuffix5 = request.SsidSuffix5
+	model.JumpHosts = make([]*JumpHost, len(request.JumpHosts))
+	for i, jump := range request.JumpHosts {
+		model.JumpHosts[i] = siteJumpHostToJumpHost(jump)
+	}
 	return model
 }
 
+func siteJumpHostToJumpHost(sjump *site.JumpHost) *JumpHost {
+	jump := new(JumpHost)
+	jump.Host = sjump.Host
+	jump.Port = sjump.Port
+	jump.User = sjump.User
+	jump.Key = sjump.Key
+	jump.HostKey = sjump.HostKey
+	return jump
+}
+
+func jumpHostToSiteJumpHost(jump *JumpHost) *site.JumpHost {
+	sjump := new(site.JumpHost)
+	sjump.Host = jump.Host
+	sjump.Port = jump.Port
+	sjump.User = jump.User
+	sjump.Key = jump.Key
+	sjump.HostKey = jump.HostKey
+	return sjump
+}
+
 func apRequestToModel(request *site.AccessPointRequest) *AccessPointModel {
 	model := new(AccessPointModel)
 	model.Model = request.Model
 	model.Name = request.Name
 	model.Ip = request.Ip
+	model.Port = request.Port
 	return model
 }
 
@@ -27,6 +57,16 @@ func ssidToSiteSsid(ssid *SSID) *site.SSID {
 	sssid.Auth = ssid.Auth
 	sssid.Vlan = ssid.Vlan
 	sssid.Password = ssid.Password
+	sssid.Restricted = ssid.Restricted
+	sssid.Whitelisted = ssid.Whitelisted
+	sssid.FastRoaming = ssid.FastRoaming
+	if ssid.Radius != nil {
+		sssid.Radius = radiusToSiteRadius(ssid.Radius)
+	}
+	sssid.Stations = make([]*site.Station, len(ssid.Stations))
+	for i, station := range ssid.Stations {
+		sssid.Stations[i] = stationToSiteStation(station)
+	}
 	return sssid
 }
 
@@ -36,6 +76,16 @@ func siteSsidToSsid(sssid *site.SSID) *SSID {
 	ssid.Auth = sssid.Auth
 	ssid.Vlan = sssid.Vlan
 	ssid.Password = sssid.Password
+	ssid.Restricted = sssid.Restricted
+	ssid.Whitelisted = sssid.Whitelisted
+	ssid.FastRoaming = sssid.FastRoaming
+	if !sssid.Radius.Empty() {
+		ssid.Radius = siteRadiusToRadius(sssid.Radius)
+	}
+	ssid.Stations = make([]*Station, len(sssid.Stations))
+	for i, station := range sssid.Stations {
+		ssid.Stations[i] = siteStationToStation(station)
+	}
 	return ssid
 }
 
@@ -57,13 +107,33 @@ func siteStationToStation(station *site.Station) *Station {
 
 func siteDeviceToDevice(stdev *site.AccessPointDevice) *AccessPointDevice {
 	dev := new(AccessPointDevice)
-	// TODO
+	dev.Name = stdev.Name
+	dev.Model = stdev.Model
+	dev.BridgedWiredDevice = stdev.BridgedWiredDevice
+	dev.Architecture = stdev.Architecture
+	dev.Cpu = stdev.Cpu
+	if stdev.WLan2 != nil && stdev.WLan2.Interface != "" {
+		dev.Wlan2 = &DeviceWirelessAdapter{Interface: stdev.WLan2.Interface, Device: stdev.WLan2.Device, Driver: stdev.WLan2.Driver}
+	}
+	if stdev.WLan5 != nil && stdev.WLan5.Interface != "" {
+		dev.Wlan5 = &DeviceWirelessAdapter{Interface: stdev.WLan5.Interface, Device: stdev.WLan5.Device, Driver: stdev.WLan5.Driver}
+	}
 	return dev
 }
 
 func deviceToSiteDevice(dev *AccessPointDevice) *site.AccessPointDevice {
 	stdev := new(site.AccessPointDevice)
-	// TODO
+	stdev.Name = dev.Name
+	stdev.Model = dev.Model
+	stdev.BridgedWiredDevice = dev.BridgedWiredDevice
+	stdev.Architecture = dev.Architecture
+	stdev.Cpu = dev.Cpu
+	if dev.Wlan2 != nil {
+		stdev.WLan2 = &site.DeviceWirelessAdapter{Interface: dev.Wlan2.Interface, Device: dev.Wlan2.Device, Driver: dev.Wlan2.Driver}
+	}
+	if dev.Wlan5 != nil {
+		stdev.WLan5 = &site.DeviceWirelessAdapter{Interface: dev.Wlan5.Interface, Device: dev.Wlan5.Device, Driver: dev.Wlan5.Driver}
+	}
 	return stdev
 }
 
@@ -75,12 +145,65 @@ func wirelessAdapterToModel(adapter *WirelessAdapter) *WirelessAdapterModel {
 	return model
 }
 
-func modelToWirelessAdapter(adapter *WirelessAdapter, model *WirelessAdapterModel, dev *DeviceWirelessAdapter) {
-	adapter.Mac = model.Mac
-	adapter.Channel = model.Channel
-	adapter.Power = model.Power
+func modelToWirelessAdapter(adapter *WirelessAdapter, model *WirelessAdapterModel, dev *DeviceWirelessAdapter, defaultChannel int) {
+	adapter.Channel = defaultChannel
+	if model != nil {
+		adapter.Mac = model.Mac
+		adapter.Channel = model.Channel
+		adapter.Power = model.Power
+	}
 	adapter.Device = new(DeviceWirelessAdapter)
 	adapter.Device.Device = dev.Device
 	adapter.Device.Interface = dev.Interface
 	adapter.Device.Driver = dev.Driver
 }
+
+var changeActions = map[string]string{uci.Added: site.ActionAdd, uci.Modified: site.ActionChange, uci.Removed: site.ActionRemove}
+
+func uciChangeToConfigChange(change *uci.Change) *site.ConfigChange {
+	return &site.ConfigChange{Action: changeActions[change.Kind], Path: change.Path, Old: change.Old, New: change.New}
+}
+
+func steeringToSiteSteering(steering *Steering) *site.Steering {
+	ssteering := new(site.Steering)
+	ssteering.Policy = steering.Policy
+	ssteering.MinSignal = steering.MinSignal
+	ssteering.RoamSignal = steering.RoamSignal
+	ssteering.LoadKickClients = steering.LoadKickClients
+	return ssteering
+}
+
+func siteSteeringToSteering(ssteering *site.Steering) *Steering {
+	steering := new(Steering)
+	steering.Policy = ssteering.Policy
+	steering.MinSignal = ssteering.MinSignal
+	steering.RoamSignal = ssteering.RoamSignal
+	steering.LoadKickClients = ssteering.LoadKickClients
+	return steering
+}
+
+func radiusToSiteRadius(radius *Radius) *site.Radius {
+	sradius := new(site.Radius)
+	sradius.AuthServers = slices.Clone(radius.AuthServers)
+	sradius.AuthPort = radius.AuthPort
+	sradius.AuthSecret = radius.AuthSecret
+	sradius.AcctServers = slices.Clone(radius.AcctServers)
+	sradius.AcctPort = radius.AcctPort
+	sradius.AcctSecret = radius.AcctSecret
+	sradius.NasId = radius.NasId
+	sradius.DynamicVlan = radius.DynamicVlan
+	return sradius
+}
+
+func siteRadiusToRadius(sradius *site.Radius) *Radius {
+	radius := new(Radius)
+	radius.AuthServers = slices.Clone(sradius.AuthServers)
+	radius.AuthPort = sradius.AuthPort
+	radius.AuthSecret = sradius.AuthSecret
+	radius.AcctServers = slices.Clone(sradius.AcctServers)
+	radius.AcctPort = sradius.AcctPort
+	radius.AcctSecret = sradius.AcctSecret
+	radius.NasId = sradius.NasId
+	radius.DynamicVlan = sradius.DynamicVlan
+	return radius
+}
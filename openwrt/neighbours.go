@@ -0,0 +1,129 @@
+package openwrt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"wnetctl/site"
+	"wnetctl/uci"
+)
+
+// hostapdBss is a BSS served by hostapd on an access point: its ubus object and 802.11k neighbour report entry,
+// which is BSSID, SSID and the report element in hex as hostapd rrm_nr_* methods take it.
+type hostapdBss struct {
+	object string
+	report []string
+}
+
+// bssReports are neighbour report entries of BSSes of an access point, err tells why they could not be read.
+type bssReports struct {
+	bsses []*hostapdBss
+	err   error
+}
+
+// updateNeighbourReports pushes 802.11k neighbour reports to hostapd of the access points, which are among peers,
+// so that clients are offered other access points of the site for roaming and BSS transition. Each BSS gets
+// reports of BSSes of other peers which serve the same SSID, peers which are not reachable are left out. Reports
+// are runtime state of hostapd, they are lost when wireless configuration is reloaded and have to be pushed again.
+func (this *Site) updateNeighbourReports(ctx context.Context, aps, peers []*AccessPoint) error {
+	reports := make(map[*AccessPoint]*bssReports, len(peers))
+	for _, peer := range peers {
+		reports[peer] = new(bssReports)
+	}
+	this.forEach(ctx, peers, this.options.Timeout, func(ctx context.Context, ap *AccessPoint, result *site.AccessPointResult) error {
+		reports[ap].bsses, reports[ap].err = ap.readNeighbourReports(ctx)
+		return nil
+	})
+	results := this.forEach(ctx, aps, this.options.Timeout, func(ctx context.Context, ap *AccessPoint, result *site.AccessPointResult) error {
+		if reports[ap].err != nil {
+			return reports[ap].err
+		}
+		for _, bss := range reports[ap].bsses {
+			neighbours := make([][]string, 0)
+			for _, peer := range peers {
+				if peer == ap {
+					continue
+				}
+				for _, other := range reports[peer].bsses {
+					if other.report[1] == bss.report[1] {
+						neighbours = append(neighbours, other.report)
+					}
+				}
+			}
+			if err := ap.setNeighbourReports(ctx, bss.object, neighbours); err != nil {
+				return err
+			}
+		}
+		result.Status = site.StatusCommitted
+		return nil
+	})
+	for _, result := range results {
+		if result.Err != nil {
+			return &site.ApplyError{Operation: "Updating neighbour reports", Results: results}
+		}
+	}
+	return nil
+}
+
+// refreshNeighbourReports pushes neighbour reports again to access points the applied change was committed to,
+// as their wireless configuration was reloaded. Other access points of the site are their peers.
+func (this *Site) refreshNeighbourReports(ctx context.Context, report *site.ApplyReport) error {
+	if report == nil {
+		return nil
+	}
+	reloaded := make([]*AccessPoint, 0, len(report.Results))
+	for _, result := range report.Results {
+		if ap := this.accessPoints[result.Name]; ap != nil && result.Status == site.StatusCommitted {
+			reloaded = append(reloaded, ap)
+		}
+	}
+	if len(reloaded) == 0 {
+		return nil
+	}
+	return this.updateNeighbourReports(ctx, reloaded, this.sortedAccessPoints())
+}
+
+// readNeighbourReports lists hostapd instances of the access point and reads their own neighbour report entries.
+func (this *AccessPoint) readNeighbourReports(ctx context.Context) ([]*hostapdBss, error) {
+	sshClient, err := this.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result, err := sshClient.Run(ctx, "ubus list 'hostapd.*'")
+	if err != nil {
+		return nil, err
+	}
+	bsses := make([]*hostapdBss, 0)
+	for _, object := range strings.Fields(result.Stdout) {
+		result, err = sshClient.Run(ctx, "ubus call "+uci.Quote(object)+" rrm_nr_get_own")
+		if err != nil {
+			return nil, err
+		}
+		var own struct {
+			Value []string `json:"value"`
+		}
+		if err = json.Unmarshal([]byte(result.Stdout), &own); err != nil {
+			return nil, fmt.Errorf("Invalid neighbour report of %s: %w", object, err)
+		}
+		if len(own.Value) != 3 {
+			return nil, fmt.Errorf("Invalid neighbour report of %s: %q", object, own.Value)
+		}
+		bsses = append(bsses, &hostapdBss{object: object, report: own.Value})
+	}
+	return bsses, nil
+}
+
+// setNeighbourReports replaces neighbour reports of the hostapd instance.
+func (this *AccessPoint) setNeighbourReports(ctx context.Context, object string, reports [][]string) error {
+	sshClient, err := this.connect(ctx)
+	if err != nil {
+		return err
+	}
+	list, err := json.Marshal(map[string][][]string{"list": reports})
+	if err != nil {
+		return err
+	}
+	_, err = sshClient.Run(ctx, "ubus call "+uci.Quote(object)+" rrm_nr_set "+uci.Quote(string(list)))
+	return err
+}
@@ -0,0 +1,186 @@
+package openwrt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"wnetctl/site"
+	"wnetctl/sshclient"
+	"wnetctl/uci"
+)
+
+// drift is the difference between configuration of an access point and the configuration the site defines for it:
+// changes to report and the script making them.
+type drift struct {
+	changes []*site.ConfigChange
+	script  *sshclient.Script
+}
+
+// Plan compares configuration of each access point with the configuration the site defines for it.
+// Nothing is changed on access points.
+func (this *Site) Plan(ctx context.Context) ([]*site.AccessPointPlan, error) {
+	plans, _ := this.drifts(ctx, this.sortedAccessPoints())
+	return plans, ctx.Err()
+}
+
+// Reconcile brings configuration of all access points to the configuration the site defines, changing only entries
+// which differ. Changes are applied to the access points which need them at once, as any other change of the site
+// is; nothing is applied unless configuration of every access point is read. Returned plans list changes needed,
+// the report lists outcome on access points changed and is nil when no access point needed a change.
+func (this *Site) Reconcile(ctx context.Context) ([]*site.AccessPointPlan, *site.ApplyReport, error) {
+	aps := this.sortedAccessPoints()
+	plans, drifts := this.drifts(ctx, aps)
+	if err := ctx.Err(); err != nil {
+		return plans, nil, err
+	}
+	failed := 0
+	changed := make([]*AccessPoint, 0, len(aps))
+	for i, ap := range aps {
+		if plans[i].Err != nil {
+			failed++
+		} else if !drifts[ap].script.Empty() {
+			changed = append(changed, ap)
+		}
+	}
+	if failed > 0 {
+		return plans, nil, fmt.Errorf("Configuration of %d access point(s) could not be read, nothing is applied", failed)
+	}
+	if err := this.checkAuthSupport(ctx, "Checking wpad features", aps, this.ssids); err != nil {
+		return plans, nil, err
+	}
+	if err := this.setupSteering(ctx, "Installing steering", aps); err != nil {
+		return plans, nil, err
+	}
+	if len(changed) == 0 {
+		return plans, nil, nil
+	}
+	report, err := this.apply(ctx, "Applying site configuration", changed, func(ap *AccessPoint) (*sshclient.Script, error) {
+		return drifts[ap].script, nil
+	})
+	if err != nil {
+		return plans, nil, err
+	}
+	return plans, report, this.refreshNeighbourReports(ctx, report)
+}
+
+// drifts reads configuration of access points concurrently and compares it with the configuration of the site.
+// Plans are returned in order of access points.
+func (this *Site) drifts(ctx context.Context, aps []*AccessPoint) ([]*site.AccessPointPlan, map[*AccessPoint]*drift) {
+	drifts := make(map[*AccessPoint]*drift, len(aps))
+	for _, ap := range aps {
+		drifts[ap] = new(drift)
+	}
+	results := this.forEach(ctx, aps, this.options.Timeout, func(ctx context.Context, ap *AccessPoint, result *site.AccessPointResult) error {
+		return ap.drift(ctx, drifts[ap])
+	})
+	plans := make([]*site.AccessPointPlan, len(aps))
+	for i, ap := range aps {
+		plans[i] = &site.AccessPointPlan{Name: ap.name, Changes: drifts[ap].changes, Err: results[i].Err}
+	}
+	return plans, drifts
+}
+
+// drift compares configuration of the access point with the configuration of the site.
+func (this *AccessPoint) drift(ctx context.Context, drift *drift) error {
+	live, err := this.readConfig(ctx)
+	if err != nil {
+		return err
+	}
+	desired, err := this.desiredConfig(live)
+	if err != nil {
+		return err
+	}
+	drift.changes = make([]*site.ConfigChange, 0)
+	drift.script = sshclient.NewScript()
+	for _, name := range this.site.packages() {
+		for _, change := range uci.Changes(live[name], desired[name]) {
+			drift.changes = append(drift.changes, uciChangeToConfigChange(change))
+		}
+		for _, command := range uci.Diff(live[name], desired[name]) {
+			drift.script.Add(uciCommandLine(command), "", 0)
+		}
+	}
+	return nil
+}
+
+// readConfig reads packages managed by the site from the access point, including changes which are not committed.
+func (this *AccessPoint) readConfig(ctx context.Context) (map[string]*uci.Package, error) {
+	sshClient, err := this.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	packages := this.site.packages()
+	config := make(map[string]*uci.Package, len(packages))
+	for _, name := range packages {
+		result, err := sshClient.Run(ctx, "/sbin/uci export "+name)
+		var execErr *sshclient.CommandsExecutionError
+		if this.site.steering.enabled() && name == this.site.steering.Policy && errors.As(err, &execErr) {
+			// steering daemon is not installed yet
+			config[name] = uci.NewPackage(name)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if config[name], err = uci.Parse(name, strings.NewReader(result.Stdout)); err != nil {
+			return nil, err
+		}
+	}
+	return config, nil
+}
+
+// desiredConfig returns configuration the site defines for the access point: live configuration with sections
+// managed by the site replaced by sections rendered from templates for SSIDs and steering of the site.
+func (this *AccessPoint) desiredConfig(live map[string]*uci.Package) (map[string]*uci.Package, error) {
+	desired := make(map[string]*uci.Package, len(live))
+	for name, pkg := range live {
+		pkg = pkg.Clone()
+		pkg.Sections = slices.DeleteFunc(pkg.Sections, func(section *uci.Section) bool {
+			return strings.HasPrefix(section.Name, sectionPrefix)
+		})
+		desired[name] = pkg
+	}
+	script, err := this.configScript()
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range script.Lines {
+		if err = applyScriptLine(desired, line); err != nil {
+			return nil, err
+		}
+	}
+	return desired, nil
+}
+
+// applyScriptLine executes uci command of a rendered template line on the configuration. Failure of a command
+// followed by "|| true" is ignored as shell does.
+func applyScriptLine(config map[string]*uci.Package, line *sshclient.ScriptLine) error {
+	command, tolerated := strings.CutSuffix(line.Command, "|| true")
+	args, found := strings.CutPrefix(strings.TrimSpace(command), "/sbin/uci ")
+	if !found {
+		return fmt.Errorf("%s:%d: \"%s\" is not a uci command", line.Source, line.SourceLine, line.Command)
+	}
+	uciCommand, err := uci.ParseCommand(strings.TrimPrefix(args, "-q "))
+	if err == nil {
+		if pkg := config[uciCommand.Package]; pkg != nil {
+			err = pkg.Apply(uciCommand)
+		} else {
+			err = errors.New("Unknown uci package " + uciCommand.Package)
+		}
+	}
+	if err != nil && !tolerated {
+		return fmt.Errorf("%s:%d: %w", line.Source, line.SourceLine, err)
+	}
+	return nil
+}
+
+// uciCommandLine formats the command as a shell command running uci utility.
+func uciCommandLine(command *uci.Command) string {
+	args := command.Args()
+	for i, arg := range args {
+		args[i] = uci.Quote(arg)
+	}
+	return "/sbin/uci " + strings.Join(args, " ")
+}
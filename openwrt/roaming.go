@@ -0,0 +1,73 @@
+package openwrt
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// roamingKeyLength is the length in bytes of the site key protecting exchange of PMK-R0/R1 keys between access points.
+const roamingKeyLength = 32
+
+// newRoamingKey generates a random fast roaming key of the site as a hex string.
+func newRoamingKey() (string, error) {
+	key := make([]byte, roamingKeyLength)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(key), nil
+}
+
+// mobilityDomain returns the 802.11r mobility domain of the SSID, 4 hex digits derived from the SSID name,
+// so the SSID is in the same domain on every access point of the site.
+func mobilityDomain(ssid *SSID) string {
+	sum := sha256.Sum256([]byte(ssid.Name))
+	return hex.EncodeToString(sum[:2])
+}
+
+// roamingBssid returns the locally administered MAC address the access point uses for the SSID on the band.
+// It is derived from names only, so key holder entries of other access points stay valid when access point
+// hardware is replaced.
+func roamingBssid(ap *AccessPoint, ssid *SSID, band string) string {
+	sum := sha256.Sum256([]byte(ap.name + "\x00" + ssid.Name + "\x00" + band))
+	// unicast, locally administered
+	sum[0] = sum[0]&0xfc | 0x02
+	mac := make([]string, 6)
+	for i := range mac {
+		mac[i] = fmt.Sprintf("%02x", sum[i])
+	}
+	return strings.Join(mac, ":")
+}
+
+// nasId returns NAS identifier (R0KH-ID) of the BSSID. The BSSID itself serves as R1KH-ID.
+func nasId(bssid string) string {
+	return strings.ReplaceAll(bssid, ":", "")
+}
+
+// keyHolders returns r0kh and r1kh list entries of the SSID on each radio of access points, the access point
+// itself included, so clients may roam between its radios as well.
+func (this *Site) keyHolders(aps []*AccessPoint, ssid *SSID) (r0kh, r1kh []string) {
+	for _, ap := range aps {
+		for _, radio := range ap.radios() {
+			bssid := roamingBssid(ap, ssid, radio.band)
+			r0kh = append(r0kh, bssid+","+nasId(bssid)+","+this.roamingKey)
+			r1kh = append(r1kh, bssid+","+bssid+","+this.roamingKey)
+		}
+	}
+	return r0kh, r1kh
+}
+
+// roamingPeers returns access points of the site ordered by name, with the neighbour added or removed.
+func (this *Site) roamingPeers(neighbour *AccessPoint, add bool) []*AccessPoint {
+	aps := slices.DeleteFunc(this.sortedAccessPoints(), func(ap *AccessPoint) bool { return ap.name == neighbour.name })
+	if add {
+		aps = append(aps, neighbour)
+		slices.SortFunc(aps, func(a, b *AccessPoint) int {
+			return strings.Compare(a.name, b.name)
+		})
+	}
+	return aps
+}
@@ -0,0 +1,115 @@
+package openwrt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+	"wnetctl/sshclient"
+	"wnetctl/uci"
+)
+
+const rollbackArchive = "/tmp/wnetctl-rollback.tar.gz"
+const rollbackScript = "/tmp/wnetctl-rollback.sh"
+const rollbackPidFile = "/tmp/wnetctl-rollback.pid"
+
+// reconnectInterval is a pause between attempts to reach access point after configuration reload.
+const reconnectInterval = 2 * time.Second
+
+// confirmMargin is left to confirm a change before the access point starts restoring previous configuration.
+const confirmMargin = 3 * time.Second
+
+// snapshotCommand saves current /etc/config on the access point before commit.
+const snapshotCommand = "tar -czf " + rollbackArchive + " -C / etc/config"
+
+// discardCommand removes the snapshot once the change is committed on the whole site.
+const discardCommand = "rm -f " + rollbackArchive
+
+// restoreCommands bring back /etc/config saved by snapshotCommand and reload services of the site.
+func restoreCommands(site *Site) []string {
+	commands := []string{"tar -xzf " + rollbackArchive + " -C /", "/sbin/reload_config"}
+	commands = append(commands, site.reloadCommands()...)
+	return append(commands, discardCommand)
+}
+
+// snapshotCommands save current /etc/config on the access point and schedule its restore,
+// the restore runs in background detached from SSH session and survives connection loss.
+func snapshotCommands(timeout time.Duration) []string {
+	restore := fmt.Sprintf("sleep %d && tar -xzf %s -C / && /sbin/reload_config && %s",
+		int(timeout.Seconds()), rollbackArchive, reloadCommand)
+	return []string{
+		"rm -f " + rollbackArchive + " " + rollbackScript + " " + rollbackPidFile,
+		snapshotCommand,
+		"echo " + uci.Quote(restore) + " > " + rollbackScript,
+		"start-stop-daemon -S -b -m -p " + rollbackPidFile + " -x /bin/sh -- " + rollbackScript,
+	}
+}
+
+// confirmCommands cancel scheduled restore, the snapshot is kept until the change is committed on the whole site.
+var confirmCommands = []string{
+	"start-stop-daemon -K -p " + rollbackPidFile,
+	"rm -f " + rollbackScript + " " + rollbackPidFile,
+}
+
+// commitWithConfirmation commits staged changes protected by a dead-man switch: the access point restores
+// configuration snapshot taken before commit unless it is reachable over SSH after reload within timeout.
+func (this *transaction) commitWithConfirmation(ctx context.Context, timeout time.Duration) error {
+	if timeout <= confirmMargin+reconnectInterval {
+		return fmt.Errorf("Confirmation timeout %s is too short", timeout)
+	}
+	for _, command := range snapshotCommands(timeout) {
+		if err := this.sshClient.Execute(ctx, command); err != nil {
+			return err
+		}
+	}
+	this.snapshot = true
+	deadline := time.Now().Add(timeout - confirmMargin)
+	for _, pkg := range this.ap.site.packages() {
+		if err := this.sshClient.Execute(ctx, "/sbin/uci commit "+pkg); err != nil {
+			return err
+		}
+	}
+	this.staged = false
+	// reload may drop the management path together with the session, so its result is not conclusive
+	for _, command := range this.ap.site.reloadCommands() {
+		this.sshClient.Execute(ctx, command)
+	}
+	// reachability is proven by a new connection only
+	this.ap.site.clients.Forget(this.ap.name)
+	this.sshClient = nil
+	sshClient, err := this.ap.reconnect(ctx, deadline)
+	if err != nil {
+		if ctx.Err() != nil {
+			return errors.New("Confirmation of access point " + this.ap.name + " configuration is cancelled")
+		}
+		return fmt.Errorf("Access point %s is unreachable after reload (%s), previous configuration is restored in %s",
+			this.ap.name, err.Error(), time.Until(deadline.Add(confirmMargin)).Round(time.Second))
+	}
+	this.sshClient = sshClient
+	for _, command := range confirmCommands {
+		if err := sshClient.Execute(ctx, command); err != nil {
+			return errors.New("Failed to confirm configuration of access point " + this.ap.name + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+// reconnect tries to establish a new SSH connection to the access point until the deadline or ctx is done.
+func (this *AccessPoint) reconnect(ctx context.Context, deadline time.Time) (sshclient.SshClient, error) {
+	ctx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+	for {
+		sshClient, err := this.connect(ctx)
+		if err == nil {
+			return sshClient, nil
+		}
+		if time.Now().Add(reconnectInterval).After(deadline) {
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(reconnectInterval):
+		}
+	}
+}
@@ -1,12 +1,19 @@
 package openwrt
 
 import (
+	"context"
 	"errors"
+	"golang.org/x/crypto/ssh"
 	"gopkg.in/yaml.v3"
 	"io"
+	"net"
+	"path/filepath"
 	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"wnetctl/site"
+	"wnetctl/sshclient"
 	"wnetctl/util"
 )
 
@@ -25,9 +32,23 @@ type SSID struct {
 	Vlan        int
 	Restricted  bool
 	Whitelisted bool
+	FastRoaming bool    `yaml:"fastRoaming,omitempty"`
+	Radius      *Radius `yaml:",omitempty"`
 	Stations    []*Station
 }
 
+// Radius is 802.1X configuration of an enterprise SSID, see site.Radius.
+type Radius struct {
+	AuthServers []string `yaml:"authServers"`
+	AuthPort    int      `yaml:"authPort,omitempty"`
+	AuthSecret  string   `yaml:"authSecret"`
+	AcctServers []string `yaml:"acctServers,omitempty"`
+	AcctPort    int      `yaml:"acctPort,omitempty"`
+	AcctSecret  string   `yaml:"acctSecret,omitempty"`
+	NasId       string   `yaml:"nasId,omitempty"`
+	DynamicVlan bool     `yaml:"dynamicVlan,omitempty"`
+}
+
 type Site struct {
 	path         string
 	name         string
@@ -38,9 +59,29 @@ type Site struct {
 	country      string
 	suffix2      string
 	suffix5      string
+	roamingKey   string
+	steering     *Steering
+	jumpHosts    []*JumpHost
 	accessPoints map[string]*AccessPoint
 	ssids        []*SSID
 	devices      map[string]*AccessPointDevice
+	options      site.ApplyOptions
+	knownHosts   *sshclient.KnownHosts
+	clients      *sshclient.Pool
+
+	// passphrase of the site key is asked for once, when the key is used first
+	passphraseOnce sync.Once
+	passphrase     string
+	passphraseErr  error
+}
+
+// JumpHost is an SSH server on the way to site access points, see site.JumpHost.
+type JumpHost struct {
+	Host    string
+	Port    int `yaml:",omitempty"`
+	User    string
+	Key     string `yaml:",omitempty"`
+	HostKey string `yaml:"hostKey"`
 }
 
 type SiteModel struct {
@@ -48,6 +89,12 @@ type SiteModel struct {
 	SshKey       string
 	SshPublicKey string
 	Password     string
+	Country      string
+	SsidSuffix2  string      `yaml:"ssidSuffix2"`
+	SsidSuffix5  string      `yaml:"ssidSuffix5"`
+	RoamingKey   string      `yaml:"roamingKey"`
+	Steering     *Steering   `yaml:"steering,omitempty"`
+	JumpHosts    []*JumpHost `yaml:"jumpHosts,omitempty"`
 	AccessPoints []*AccessPointModel
 	Ssids        []*SSID
 	Devices      []*AccessPointDevice
@@ -74,6 +121,8 @@ func CreateSiteManager(name, path string, request *site.SiteRequest) (site.SiteM
 	ste.path = path
 	if err := ste.init(siteRequestToModel(request)); err != nil {
 		return nil, err
+	} else if _, err = ste.generateKeys(); err != nil {
+		return nil, err
 	} else {
 		if err = ste.save(); err != nil {
 			return nil, err
@@ -91,49 +140,53 @@ func (this *Site) GetSite() *site.SiteResponse {
 	response.Country = this.country
 	response.SsidSuffix2 = this.suffix2
 	response.SsidSuffix5 = this.suffix5
-	response.Devices = make([]*site.AccessPointDevice, len(this.devices))
-	i := 0
-	for _, device := range this.devices {
-		response.Devices[i] = new(site.AccessPointDevice)
-		response.Devices[i].Name = device.Name
-		response.Devices[i].Model = device.Model
-		response.Devices[i].Cpu = device.Cpu
-		response.Devices[i].Architecture = device.Architecture
-		response.Devices[i].BridgedWiredDevice = device.BridgedWiredDevice
-		response.Devices[i].WLan2.Device = device.Wlan2.Device
+	response.Steering = this.GetSteering()
+	response.JumpHosts = make([]*site.JumpHost, len(this.jumpHosts))
+	for i, jump := range this.jumpHosts {
+		response.JumpHosts[i] = jumpHostToSiteJumpHost(jump)
 	}
+	response.Devices = this.GetDeviceTypes()
 
 	return response
 }
 
-func (this *Site) AddAccessPoint(request *site.AccessPointRequest) (site.AccessPoint, error) {
+func (this *Site) AddAccessPoint(ctx context.Context, request *site.AccessPointRequest) (site.AccessPoint, *site.ApplyReport, error) {
 	dev, ok := this.devices[request.Model]
 	if !ok || dev == nil {
-		return nil, errors.New("Unknown device type \"" + request.Model + "\"")
+		return nil, nil, errors.New("Unknown device type \"" + request.Model + "\"")
+	}
+	if _, exists := this.accessPoints[request.Name]; exists {
+		return nil, nil, errors.New("Access point \"" + request.Name + "\" already exists")
 	}
 
 	accessPoint, err := CreateAccessPoint(request, this)
 	if err == nil {
-		err = accessPoint.Configure()
+		err = accessPoint.bootstrap(ctx)
+	}
+	if err == nil {
+		err = accessPoint.checkAuthSupport(ctx, this.ssids)
 	}
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	processed := make([]string, 0, len(this.accessPoints))
-	for _, ap := range this.accessPoints {
-		err := ap.AddNeighbour(accessPoint)
-		if err != nil {
-			for _, apName := range processed {
-				this.accessPoints[apName].RemoveNeighbour(accessPoint)
-			}
-			return nil, err
+	aps := append([]*AccessPoint{accessPoint}, this.sortedAccessPoints()...)
+	// the access point is a roaming peer of itself, so it is a member of the site while its configuration is rendered
+	this.accessPoints[accessPoint.Name()] = accessPoint
+	report, err := this.apply(ctx, "Adding access point "+accessPoint.Name(), aps, func(ap *AccessPoint) (*sshclient.Script, error) {
+		if ap == accessPoint {
+			return ap.configScript()
 		}
+		return ap.neighbourScript(accessPoint, true)
+	})
+	if err != nil {
+		delete(this.accessPoints, accessPoint.Name())
+		return nil, nil, err
 	}
-	this.accessPoints[accessPoint.Name()] = accessPoint
 	if err := this.save(); err != nil {
-		return nil, err
+		return nil, report, err
 	}
-	return accessPoint, nil
+	peers := this.sortedAccessPoints()
+	return accessPoint, report, this.updateNeighbourReports(ctx, peers, peers)
 }
 
 func (this *Site) GetAccessPoints() []*site.AccessPointResponse {
@@ -146,41 +199,125 @@ func (this *Site) GetAccessPoints() []*site.AccessPointResponse {
 	return aps
 }
 
-func (this *Site) RemoveAccessPoint(name string) error {
+func (this *Site) RemoveAccessPoint(ctx context.Context, name string) (*site.ApplyReport, error) {
+	accessPoint, ok := this.accessPoints[name]
+	if !ok {
+		return nil, errors.New("Unknown access point \"" + name + "\"")
+	}
+	delete(this.accessPoints, name)
+	report, err := this.apply(ctx, "Removing access point "+name, this.sortedAccessPoints(), func(ap *AccessPoint) (*sshclient.Script, error) {
+		return ap.neighbourScript(accessPoint, false)
+	})
+	if err != nil {
+		this.accessPoints[name] = accessPoint
+		return nil, err
+	}
+	this.clients.Forget(name)
+	if err = this.knownHosts.Forget(accessPoint.address()); err != nil {
+		return report, err
+	}
+	if err = this.save(); err != nil {
+		return report, err
+	}
+	peers := this.sortedAccessPoints()
+	return report, this.updateNeighbourReports(ctx, peers, peers)
+}
+
+func (this *Site) TrustAccessPoint(ctx context.Context, name string) (string, error) {
+	accessPoint, ok := this.accessPoints[name]
+	if !ok {
+		return "", errors.New("Unknown access point \"" + name + "\"")
+	}
+	var fingerprint string
+	sshClient, err := accessPoint.newSshClient(this.password, this.sshKey)
+	if err != nil {
+		return "", err
+	}
+	trust := this.knownHosts.HostKeyCallback(sshclient.TrustHostKey)
+	sshClient.SetHostKeyCallback(func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		fingerprint = ssh.FingerprintSHA256(key)
+		return trust(hostname, remote, key)
+	})
+	// connection verified with previous host key must not be reused
+	this.clients.Forget(name)
+	// host key is recorded during handshake, so authentication failure does not matter here
+	if err := sshClient.Connect(ctx); err == nil {
+		sshClient.Close()
+	} else if fingerprint == "" {
+		return "", err
+	}
+	return fingerprint, nil
+}
+
+func (this *Site) ForgetAccessPointKey(name string) error {
 	accessPoint, ok := this.accessPoints[name]
 	if !ok {
 		return errors.New("Unknown access point \"" + name + "\"")
 	}
-	//var ix int
-	//for ix = 0; ix < len(this.accessPoints) && this.accessPoints[ix].Name != name; ix++ {
-	//}
-	//if ix == len(this.accessPoints) {
-	//	return errors.New("access point not found")
-	//}
-	for nm, ap := range this.accessPoints {
-		if nm != name {
-			ap.RemoveNeighbour(accessPoint)
-		}
+	this.clients.Forget(name)
+	return this.knownHosts.Forget(accessPoint.address())
+}
+
+// AddJumpHost appends the jump host to the chain access points are reached through.
+func (this *Site) AddJumpHost(jump *site.JumpHost) error {
+	if jump.Host == "" || jump.User == "" {
+		return errors.New("Jump host address and user must be set")
 	}
-	delete(this.accessPoints, name)
+	hostKey, err := sshclient.ParseHostKey(jump.HostKey)
+	if err != nil {
+		return err
+	}
+	if slices.ContainsFunc(this.jumpHosts, func(j *JumpHost) bool { return j.Host == jump.Host && j.Port == jump.Port }) {
+		return errors.New("Jump host " + jump.Host + " already exists")
+	}
+	newJump := siteJumpHostToJumpHost(jump)
+	newJump.HostKey = hostKey
+	this.jumpHosts = append(this.jumpHosts, newJump)
 	return this.save()
 }
 
-func (this *Site) AddSSID(ssid *site.SSID) error {
-	processed := make([]string, 0, len(this.accessPoints))
-	for name, ap := range this.accessPoints {
-		if err := ap.AddSSID(ssid); err != nil {
-			for _, nm := range processed {
-				this.accessPoints[nm].RemoveSSID(ssid)
-			}
-			return err
+// RemoveJumpHost removes jump host of the address, which is host or host:port, from the chain.
+func (this *Site) RemoveJumpHost(host string) error {
+	ix := slices.IndexFunc(this.jumpHosts, func(j *JumpHost) bool {
+		port := j.Port
+		if port <= 0 {
+			port = sshclient.DefaultPort
 		}
-		processed = append(processed, name)
+		return j.Host == host || net.JoinHostPort(j.Host, strconv.Itoa(port)) == host
+	})
+	if ix < 0 {
+		return errors.New("Unknown jump host \"" + host + "\"")
 	}
-	this.ssids = append(this.ssids, siteSsidToSsid(ssid))
+	this.jumpHosts = slices.Delete(this.jumpHosts, ix, ix+1)
 	return this.save()
 }
 
+func (this *Site) AddSSID(ctx context.Context, ssid *site.SSID) (*site.ApplyReport, error) {
+	if slices.ContainsFunc(this.ssids, func(s *SSID) bool { return s.Name == ssid.Name }) {
+		return nil, errors.New("SSID \"" + ssid.Name + "\" already exists")
+	}
+	newSsid := siteSsidToSsid(ssid)
+	defaultAuth(newSsid)
+	if err := validateSSID(newSsid); err != nil {
+		return nil, err
+	}
+	aps := this.sortedAccessPoints()
+	if err := this.checkAuthSupport(ctx, "Adding SSID "+ssid.Name, aps, []*SSID{newSsid}); err != nil {
+		return nil, err
+	}
+	report, err := this.apply(ctx, "Adding SSID "+ssid.Name, aps, func(ap *AccessPoint) (*sshclient.Script, error) {
+		return ap.ssidScript(addSSIDTemplate, newSsid)
+	})
+	if err != nil {
+		return nil, err
+	}
+	this.ssids = append(this.ssids, newSsid)
+	if err = this.save(); err != nil {
+		return report, err
+	}
+	return report, this.refreshNeighbourReports(ctx, report)
+}
+
 func (this *Site) GetSSIDs() []*site.SSID {
 	ssids := make([]*site.SSID, len(this.ssids))
 	for i, ssid := range this.ssids {
@@ -189,50 +326,108 @@ func (this *Site) GetSSIDs() []*site.SSID {
 	return ssids
 }
 
-func (this *Site) UpdateSSID(ssid *site.SSID) error {
-	//TODO implement me
-	panic("implement me")
+// UpdateSSID replaces SSID called name with ssid, which is renamed when its name differs. Access points get
+// the old SSID removed and the new one added in a single apply.
+func (this *Site) UpdateSSID(ctx context.Context, name string, ssid *site.SSID) (*site.ApplyReport, error) {
+	ix := slices.IndexFunc(this.ssids, func(s *SSID) bool {
+		return name == s.Name
+	})
+	if ix < 0 {
+		return nil, errors.New("SSID \"" + name + "\" not found")
+	}
+	operation := "Updating SSID " + name
+	if ssid.Name != name {
+		if slices.ContainsFunc(this.ssids, func(s *SSID) bool { return s.Name == ssid.Name }) {
+			return nil, errors.New("SSID \"" + ssid.Name + "\" already exists")
+		}
+		operation = "Renaming SSID " + name + " to " + ssid.Name
+	}
+	current := this.ssids[ix]
+	updated := siteSsidToSsid(ssid)
+	if err := validateSSID(updated); err != nil {
+		return nil, err
+	}
+	aps := this.sortedAccessPoints()
+	if err := this.checkAuthSupport(ctx, operation, aps, []*SSID{updated}); err != nil {
+		return nil, err
+	}
+	report, err := this.apply(ctx, operation, aps, func(ap *AccessPoint) (*sshclient.Script, error) {
+		script, err := ap.ssidScript(removeSSIDTemplate, current)
+		if err != nil {
+			return nil, err
+		}
+		addScript, err := ap.ssidScript(addSSIDTemplate, updated)
+		script.Append(addScript)
+		return script, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	this.ssids[ix] = updated
+	if err = this.save(); err != nil {
+		return report, err
+	}
+	return report, this.refreshNeighbourReports(ctx, report)
 }
 
-func (this *Site) RemoveSSID(name string) error {
-	var ix int
-	for ix = 0; ix < len(this.ssids) && this.ssids[ix].Name != name; ix++ {
+func (this *Site) RemoveSSID(ctx context.Context, name string) (*site.ApplyReport, error) {
+	ix := slices.IndexFunc(this.ssids, func(s *SSID) bool {
+		return name == s.Name
+	})
+	if ix < 0 {
+		return nil, errors.New("SSID \"" + name + "\" not found")
 	}
-	if ix == len(this.ssids) {
-		return errors.New("ssid not found")
+	report, err := this.apply(ctx, "Removing SSID "+name, this.sortedAccessPoints(), func(ap *AccessPoint) (*sshclient.Script, error) {
+		return ap.ssidScript(removeSSIDTemplate, this.ssids[ix])
+	})
+	if err != nil {
+		return nil, err
 	}
-	for _, ap := range this.accessPoints {
-		if err := ap.RemoveSSID(ssidToSiteSsid(this.ssids[ix])); err != nil {
-			// TODO restore SSID on succeeded access points
-			return err
-		}
+	this.ssids = slices.Delete(this.ssids, ix, ix+1)
+	if err = this.save(); err != nil {
+		return report, err
+	}
+	return report, this.refreshNeighbourReports(ctx, report)
+}
+
+// validateSSID checks that settings of the SSID may be applied together.
+func validateSSID(ssid *SSID) error {
+	if err := validateAuth(ssid); err != nil {
+		return err
 	}
-	for i := ix + 1; i < len(this.ssids); i++ {
-		this.ssids[i-1] = this.ssids[i]
+	if ssid.FastRoaming && (ssid.Auth == site.AuthOpen || ssid.Auth == site.AuthOwe) {
+		return errors.New("Fast roaming of SSID \"" + ssid.Name + "\" requires WPA authentication")
 	}
-	this.ssids = this.ssids[:len(this.ssids)-1]
-	return this.save()
+	return nil
 }
 
-func (this *Site) AddStation(ssidName string, station *site.Station) error {
+// AddStation adds the station to the SSID or updates name and comment of the listed one. Access points get
+// the station list of whitelisted SSID replaced.
+func (this *Site) AddStation(ctx context.Context, ssidName string, station *site.Station) (*site.ApplyReport, error) {
 	ix := slices.IndexFunc(this.ssids, func(ssid *SSID) bool {
 		return ssidName == ssid.Name
 	})
 	if ix < 0 {
-		return errors.New("SSID \"" + ssidName + "\" not found")
+		return nil, errors.New("SSID \"" + ssidName + "\" not found")
 	}
 	ssid := this.ssids[ix]
-	mac := strings.ToLower(station.Mac)
+	mac, err := site.NormalizeMac(station.Mac)
+	if err != nil {
+		return nil, err
+	}
 	stix := slices.IndexFunc(ssid.Stations, func(st *Station) bool {
 		return st.Mac == mac
 	})
 	if stix != -1 {
 		ssid.Stations[stix].Name = station.Name
 		ssid.Stations[stix].Comment = station.Comment
-	} else {
-		this.ssids[ix].Stations = append(ssid.Stations, siteStationToStation(station))
+		return nil, this.save()
 	}
-	return this.save()
+	st := siteStationToStation(station)
+	st.Mac = mac
+	updated := *ssid
+	updated.Stations = append(slices.Clone(ssid.Stations), st)
+	return this.updateStations(ctx, "Adding station "+mac+" to SSID "+ssidName, &updated)
 }
 
 func (this *Site) GetStations(ssidName string) ([]*site.Station, error) {
@@ -250,24 +445,105 @@ func (this *Site) GetStations(ssidName string) ([]*site.Station, error) {
 	return stations, nil
 }
 
-func (this *Site) RemoveStation(ssidName, macAddress string) error {
+func (this *Site) RemoveStation(ctx context.Context, ssidName, macAddress string) (*site.ApplyReport, error) {
 	ix := slices.IndexFunc(this.ssids, func(ssid *SSID) bool {
 		return ssidName == ssid.Name
 	})
 	if ix < 0 {
-		return errors.New("SSID \"" + ssidName + "\" not found")
+		return nil, errors.New("SSID \"" + ssidName + "\" not found")
 	}
 	ssid := this.ssids[ix]
-	mac := strings.ToLower(macAddress)
+	mac, err := site.NormalizeMac(macAddress)
+	if err != nil {
+		return nil, err
+	}
 	stix := slices.IndexFunc(ssid.Stations, func(st *Station) bool {
 		return st.Mac == mac
 	})
 	if stix == -1 {
-		return errors.New("Station \"" + mac + "\" not found in " + ssidName + " stations list")
+		return nil, errors.New("Station \"" + mac + "\" not found in " + ssidName + " stations list")
+	}
+	updated := *ssid
+	updated.Stations = slices.Delete(slices.Clone(ssid.Stations), stix, stix+1)
+	return this.updateStations(ctx, "Removing station "+mac+" from SSID "+ssidName, &updated)
+}
+
+// MoveStation moves the station from one SSID to another in a single change, name and comment of the station
+// replace those of the target SSID station with the same MAC address.
+func (this *Site) MoveStation(ctx context.Context, ssidName, macAddress, targetName string) (*site.ApplyReport, error) {
+	if ssidName == targetName {
+		return nil, errors.New("Station is already in " + targetName + " stations list")
+	}
+	ix := slices.IndexFunc(this.ssids, func(ssid *SSID) bool {
+		return ssidName == ssid.Name
+	})
+	tix := slices.IndexFunc(this.ssids, func(ssid *SSID) bool {
+		return targetName == ssid.Name
+	})
+	if ix < 0 || tix < 0 {
+		return nil, errors.New("SSID \"" + ssidName + "\" or \"" + targetName + "\" not found")
+	}
+	mac, err := site.NormalizeMac(macAddress)
+	if err != nil {
+		return nil, err
+	}
+	byMac := func(st *Station) bool {
+		return st.Mac == mac
+	}
+	source, target := *this.ssids[ix], *this.ssids[tix]
+	stix := slices.IndexFunc(source.Stations, byMac)
+	if stix == -1 {
+		return nil, errors.New("Station \"" + mac + "\" not found in " + ssidName + " stations list")
+	}
+	station := source.Stations[stix]
+	source.Stations = slices.Delete(slices.Clone(source.Stations), stix, stix+1)
+	target.Stations = slices.Clone(target.Stations)
+	if listed := slices.IndexFunc(target.Stations, byMac); listed != -1 {
+		target.Stations[listed] = station
 	} else {
-		// TODO: remove station at index stix from stations list
+		target.Stations = append(target.Stations, station)
 	}
-	return this.save()
+	return this.updateStations(ctx, "Moving station "+mac+" from SSID "+ssidName+" to "+targetName, &source, &target)
+}
+
+// updateStations replaces SSIDs of the same names with updated ones, which differ in stations only. Station lists
+// matter to access points only when SSID is whitelisted, changes of other SSIDs are just saved and no report
+// is returned for them.
+func (this *Site) updateStations(ctx context.Context, operation string, updated ...*SSID) (*site.ApplyReport, error) {
+	var whitelisted []*SSID
+	for _, ssid := range updated {
+		if ssid.Whitelisted {
+			whitelisted = append(whitelisted, ssid)
+		}
+	}
+	var report *site.ApplyReport
+	if len(whitelisted) > 0 {
+		var err error
+		report, err = this.apply(ctx, operation, this.sortedAccessPoints(), func(ap *AccessPoint) (*sshclient.Script, error) {
+			script := sshclient.NewScript()
+			for _, ssid := range whitelisted {
+				commands, err := ap.ssidScript(stationsTemplate, ssid)
+				if err != nil {
+					return nil, err
+				}
+				script.Append(commands)
+			}
+			return script, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, ssid := range updated {
+		ix := slices.IndexFunc(this.ssids, func(s *SSID) bool {
+			return ssid.Name == s.Name
+		})
+		this.ssids[ix] = ssid
+	}
+	if err := this.save(); err != nil {
+		return report, err
+	}
+	return report, this.refreshNeighbourReports(ctx, report)
 }
 
 func (this *Site) AddDeviceType(device *site.AccessPointDevice) error {
@@ -275,7 +551,7 @@ func (this *Site) AddDeviceType(device *site.AccessPointDevice) error {
 		return errors.New("device " + device.Name + " already exists")
 	}
 	this.devices[device.Name] = siteDeviceToDevice(device)
-	return nil
+	return this.save()
 }
 
 func (this *Site) RemoveDeviceType(deviceType string) error {
@@ -285,7 +561,7 @@ func (this *Site) RemoveDeviceType(deviceType string) error {
 		}
 	}
 	delete(this.devices, deviceType)
-	return nil
+	return this.save()
 }
 
 func (this *Site) GetDeviceTypes() []*site.AccessPointDevice {
@@ -313,16 +589,67 @@ func (this *Site) load() error {
 	if err := util.ReadObject(this.path, model); err != nil {
 		return err
 	}
-	return this.init(model)
+	if err := this.init(model); err != nil {
+		return err
+	}
+	// keys must not change between runs, or every run would see roaming and steering configuration drifted
+	if generated, err := this.generateKeys(); err != nil || !generated {
+		return err
+	}
+	return this.save()
+}
+
+// generateKeys generates keys missing in the site: fast roaming key of sites created before fast roaming was
+// supported and DAWN keys of a policy written to the site file by hand. Tells whether any key was generated.
+func (this *Site) generateKeys() (bool, error) {
+	generated := false
+	if this.roamingKey == "" {
+		key, err := newRoamingKey()
+		if err != nil {
+			return false, err
+		}
+		this.roamingKey = key
+		generated = true
+	}
+	if this.steering != nil {
+		dawnGenerated, err := this.steering.generateKeys()
+		if err != nil {
+			return false, err
+		}
+		generated = generated || dawnGenerated
+	}
+	return generated, nil
 }
 
 func (this *Site) init(model *SiteModel) error {
 	this.plugin = pluginName
+	this.knownHosts = sshclient.NewKnownHosts(knownHostsPath(this.path))
+	this.clients = sshclient.NewPool()
 	this.sshKey = model.SshKey
 	this.sshPublicKey = model.SshPublicKey
 	this.password = model.Password
+	this.country = model.Country
+	this.suffix2 = model.SsidSuffix2
+	this.suffix5 = model.SsidSuffix5
+	this.roamingKey = model.RoamingKey
+	this.steering = model.Steering
+	this.jumpHosts = make([]*JumpHost, 0, len(model.JumpHosts))
+	for _, jump := range model.JumpHosts {
+		if jump != nil {
+			this.jumpHosts = append(this.jumpHosts, jump)
+		}
+	}
 
-	this.ssids = make([]*SSID, len(model.Ssids))
+	this.ssids = make([]*SSID, 0, len(model.Ssids))
+	for _, ssid := range model.Ssids {
+		if ssid == nil {
+			continue
+		}
+		if err := upgradeAuth(ssid); err != nil {
+			return err
+		}
+		this.ssids = append(this.ssids, ssid)
+	}
 	this.devices = make(map[string]*AccessPointDevice)
 	for _, device := range model.Devices {
 		if device != nil {
@@ -345,6 +672,12 @@ func (this *Site) export() *SiteModel {
 	model.SshKey = this.sshKey
 	model.SshPublicKey = this.sshPublicKey
 	model.Password = this.password
+	model.Country = this.country
+	model.SsidSuffix2 = this.suffix2
+	model.SsidSuffix5 = this.suffix5
+	model.RoamingKey = this.roamingKey
+	model.Steering = this.steering
+	model.JumpHosts = this.jumpHosts
 	model.Devices = make([]*AccessPointDevice, len(this.devices))
 	j := 0
 	for _, device := range this.devices {
@@ -365,6 +698,54 @@ func (this *Site) export() *SiteModel {
 	return model
 }
 
+// Close closes SSH connections to access points opened by the site operations.
+func (this *Site) Close() error {
+	return this.clients.Close()
+}
+
+func (this *Site) SetApplyOptions(options *site.ApplyOptions) {
+	this.options = *options
+}
+
+// sortedAccessPoints returns site access points ordered by name, so changes are applied in a predictable order.
+func (this *Site) sortedAccessPoints() []*AccessPoint {
+	aps := make([]*AccessPoint, 0, len(this.accessPoints))
+	for _, ap := range this.accessPoints {
+		aps = append(aps, ap)
+	}
+	slices.SortFunc(aps, func(a, b *AccessPoint) int {
+		return strings.Compare(a.name, b.name)
+	})
+	return aps
+}
+
+// keyPassphrase returns passphrase of the site key, see sshclient.KeyPassphrase.
+func (this *Site) keyPassphrase() (string, error) {
+	this.passphraseOnce.Do(func() {
+		this.passphrase, this.passphraseErr = sshclient.KeyPassphrase(this.sshKey)
+	})
+	return this.passphrase, this.passphraseErr
+}
+
+// sshJumpHosts returns jump hosts to connect to access points through, those without own key use the site key
+// with passphrase.
+func (this *Site) sshJumpHosts(passphrase string) []*sshclient.JumpHost {
+	jumpHosts := make([]*sshclient.JumpHost, len(this.jumpHosts))
+	for i, jump := range this.jumpHosts {
+		jumpHosts[i] = &sshclient.JumpHost{Host: jump.Host, Port: jump.Port, User: jump.User, Key: jump.Key, HostKey: jump.HostKey}
+		if jump.Key == "" {
+			jumpHosts[i].Key = this.sshKey
+			jumpHosts[i].Passphrase = passphrase
+		}
+	}
+	return jumpHosts
+}
+
+// knownHostsPath returns path of SSH known hosts file kept next to the site file.
+func knownHostsPath(sitePath string) string {
+	return strings.TrimSuffix(sitePath, filepath.Ext(sitePath)) + ".known_hosts"
+}
+
 func (this *Site) save() error {
 	return util.WriteObject(this.path, this.export())
 }
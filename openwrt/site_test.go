@@ -0,0 +1,1307 @@
+package openwrt
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"wnetctl/site"
+	"wnetctl/sshclient"
+	"wnetctl/sshclient/sshtest"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const testPassword = "site-secret"
+
+// newTestSite creates a site with a fresh SSH key and a dual band device type "test" in a temporary directory.
+func newTestSite(t *testing.T, options *site.ApplyOptions) *Site {
+	t.Helper()
+	// templates are looked up relative to the repository root
+	t.Chdir("..")
+	t.Setenv("SSH_AUTH_SOCK", "")
+	dir := t.TempDir()
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(privateKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshPublicKey, err := ssh.NewPublicKey(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(dir, "id_ed25519")
+	writeFile(t, keyPath, pem.EncodeToMemory(block))
+	writeFile(t, keyPath+".pub", ssh.MarshalAuthorizedKey(sshPublicKey))
+	manager, err := CreateSiteManager("test", filepath.Join(dir, "site.yml"), &site.SiteRequest{
+		SshKey: keyPath, SshPublicKey: keyPath + ".pub", Password: testPassword, Country: "US"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = manager.AddDeviceType(&site.AccessPointDevice{Name: "test", Model: "Test AP",
+		WLan2: &site.DeviceWirelessAdapter{Interface: "radio0", Device: "phy0"},
+		WLan5: &site.DeviceWirelessAdapter{Interface: "radio1", Device: "phy1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if options == nil {
+		options = &site.ApplyOptions{Parallelism: 4, Timeout: 10 * time.Second}
+	}
+	manager.SetApplyOptions(options)
+	t.Cleanup(func() { manager.Close() })
+	return manager.(*Site)
+}
+
+func newTestServer(t *testing.T) *sshtest.Server {
+	t.Helper()
+	server, err := sshtest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	return server
+}
+
+func addTestAccessPoint(t *testing.T, ste *Site, name string, server *sshtest.Server) {
+	t.Helper()
+	request := &site.AccessPointRequest{Name: name, Model: "test", Ip: server.Host(), Port: server.Port()}
+	if _, _, err := ste.AddAccessPoint(context.Background(), request); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func expectValue(t *testing.T, server *sshtest.Server, path, expected string) {
+	t.Helper()
+	if value, ok := server.Uci().Get(path); !ok || value != expected {
+		t.Errorf("%s = %q (found %v), expected %q", path, value, ok, expected)
+	}
+}
+
+func expectMissing(t *testing.T, server *sshtest.Server, path string) {
+	t.Helper()
+	if value, ok := server.Uci().GetStaged(path); ok {
+		t.Errorf("%s = %q, expected it to be missing", path, value)
+	}
+}
+
+func TestAddAccessPointConfiguresIt(t *testing.T) {
+	ste := newTestSite(t, nil)
+	if _, err := ste.AddSSID(context.Background(), &site.SSID{Name: "Home", Auth: "wpa2-psk", Password: "home-key"}); err != nil {
+		t.Fatal(err)
+	}
+	server := newTestServer(t)
+	addTestAccessPoint(t, ste, "ap1", server)
+
+	if password := server.Password("root"); password != testPassword {
+		t.Errorf("root password is %q, expected site password", password)
+	}
+	publicKey, _ := os.ReadFile(ste.sshPublicKey)
+	if keys, _ := server.ReadFile(sshclient.AuthorizedKeysFile); !strings.Contains(string(keys), strings.TrimSpace(string(publicKey))) {
+		t.Errorf("site key is not authorized: %q", keys)
+	}
+	if mode, _ := server.FileMode(sshclient.AuthorizedKeysFile); mode != 0600 {
+		t.Errorf("authorized keys mode is %o", mode)
+	}
+	// the bootstrap connection with empty password is not reused, the access point is configured over the site key
+	if logins := server.Logins(); !slices.Equal(logins, []string{"root password", "root publickey"}) {
+		t.Errorf("logins are %q", logins)
+	}
+	expectValue(t, server, "wireless.wnet_home_2g", "wifi-iface")
+	expectValue(t, server, "wireless.wnet_home_2g.ssid", "Home")
+	expectValue(t, server, "wireless.wnet_home_2g.device", "radio0")
+	expectValue(t, server, "wireless.wnet_home_2g.encryption", "psk2+ccmp")
+	expectValue(t, server, "wireless.wnet_home_2g.key", "home-key")
+	expectValue(t, server, "wireless.wnet_home_5g.device", "radio1")
+	expectValue(t, server, "wireless.radio0.country", "US")
+	if changes := server.Uci().Changes("wireless"); len(changes) != 0 {
+		t.Errorf("uncommitted changes left: %v", changes)
+	}
+	if reloads := server.Reloads(); reloads != 1 {
+		t.Errorf("network reloaded %d times, expected once", reloads)
+	}
+}
+
+func TestPooledConnectionIsReusedAcrossOperations(t *testing.T) {
+	ste := newTestSite(t, nil)
+	server := newTestServer(t)
+	addTestAccessPoint(t, ste, "ap1", server)
+	if _, err := ste.AddSSID(context.Background(), &site.SSID{Name: "Home", Auth: "open"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ste.RemoveSSID(context.Background(), "Home"); err != nil {
+		t.Fatal(err)
+	}
+	// the bootstrap connection is closed, the key authenticated one serves all following operations
+	if logins := server.Logins(); !slices.Equal(logins, []string{"root password", "root publickey"}) {
+		t.Errorf("logins are %q", logins)
+	}
+
+	// reboot of the access point drops the pooled connection, the next operation reconnects
+	server.SetDown(true)
+	server.SetDown(false)
+	if _, err := ste.AddSSID(context.Background(), &site.SSID{Name: "Home", Auth: "open"}); err != nil {
+		t.Fatal(err)
+	}
+	if logins := server.Logins(); !slices.Equal(logins, []string{"root password", "root publickey", "root publickey"}) {
+		t.Errorf("logins after reconnect are %q", logins)
+	}
+	expectValue(t, server, "wireless.wnet_home_2g.ssid", "Home")
+}
+
+func TestAddSSIDAppliesToAllAccessPoints(t *testing.T) {
+	ste := newTestSite(t, nil)
+	servers := []*sshtest.Server{newTestServer(t), newTestServer(t)}
+	addTestAccessPoint(t, ste, "ap1", servers[0])
+	addTestAccessPoint(t, ste, "ap2", servers[1])
+
+	ssid := &site.SSID{Name: "Guest Net", Auth: "open", Vlan: 10, Restricted: true}
+	if _, err := ste.AddSSID(context.Background(), ssid); err != nil {
+		t.Fatal(err)
+	}
+	for _, server := range servers {
+		expectValue(t, server, "network.wnet_vlan10", "interface")
+		expectValue(t, server, "network.wnet_vlan10_dev.vid", "10")
+		expectValue(t, server, "network.wnet_vlan10_br.ports", "br-lan.10")
+		expectValue(t, server, "wireless.wnet_guest_net_2g.ssid", "Guest Net")
+		expectValue(t, server, "wireless.wnet_guest_net_2g.network", "wnet_vlan10")
+		expectValue(t, server, "wireless.wnet_guest_net_5g.isolate", "1")
+		expectValue(t, server, "wireless.wnet_guest_net_5g.encryption", "none")
+	}
+
+	if _, err := ste.RemoveSSID(context.Background(), "Guest Net"); err != nil {
+		t.Fatal(err)
+	}
+	for _, server := range servers {
+		expectMissing(t, server, "wireless.wnet_guest_net_2g")
+		expectMissing(t, server, "network.wnet_vlan10")
+		expectValue(t, server, "wireless.default_radio0.ssid", "OpenWrt")
+	}
+}
+
+func TestRenameSSIDAppliesOnce(t *testing.T) {
+	ste := newTestSite(t, nil)
+	server := newTestServer(t)
+	addTestAccessPoint(t, ste, "ap1", server)
+	if _, err := ste.AddSSID(context.Background(), &site.SSID{Name: "Home", Auth: "wpa2-psk", Password: "home-key"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ste.AddSSID(context.Background(), &site.SSID{Name: "Office", Auth: "open"}); err != nil {
+		t.Fatal(err)
+	}
+	taken := &site.SSID{Name: "Office", Auth: "wpa2-psk", Password: "home-key"}
+	if _, err := ste.UpdateSSID(context.Background(), "Home", taken); err == nil {
+		t.Errorf("SSID is renamed to existing one")
+	}
+	reloads := server.Reloads()
+
+	renamed := &site.SSID{Name: "Family", Auth: "wpa2-psk", Password: "home-key"}
+	report, err := ste.UpdateSSID(context.Background(), "Home", renamed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Operation != "Renaming SSID Home to Family" {
+		t.Errorf("operation is %q", report.Operation)
+	}
+	if count := server.Reloads() - reloads; count != 1 {
+		t.Errorf("network reloaded %d times by rename, expected once", count)
+	}
+	expectMissing(t, server, "wireless.wnet_home_2g")
+	expectValue(t, server, "wireless.wnet_family_2g.ssid", "Family")
+	expectValue(t, server, "wireless.wnet_family_5g.key", "home-key")
+	expectValue(t, server, "wireless.wnet_office_2g.ssid", "Office")
+	var names []string
+	for _, ssid := range ste.GetSSIDs() {
+		names = append(names, ssid.Name)
+	}
+	if !slices.Equal(names, []string{"Family", "Office"}) {
+		t.Errorf("SSIDs are %q", names)
+	}
+}
+
+func TestStationsFollowWhitelistedSSID(t *testing.T) {
+	ste := newTestSite(t, nil)
+	servers := []*sshtest.Server{newTestServer(t), newTestServer(t)}
+	addTestAccessPoint(t, ste, "ap1", servers[0])
+	addTestAccessPoint(t, ste, "ap2", servers[1])
+	ssids := []*site.SSID{{Name: "Kids", Auth: "wpa2-psk", Password: "kids-key", Whitelisted: true}, {Name: "Home", Auth: "open"}}
+	for _, ssid := range ssids {
+		if _, err := ste.AddSSID(context.Background(), ssid); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, mac := range []string{"aa:bb:cc:00:00:01", "AA-BB-CC-00-00-02"} {
+		report, err := ste.AddStation(context.Background(), "Kids", &site.Station{Name: "tablet", Mac: mac})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report == nil || len(report.Results) != len(servers) {
+			t.Errorf("station is not applied to access points: %v", report)
+		}
+	}
+	for _, server := range servers {
+		expectValue(t, server, "wireless.wnet_kids_2g.macfilter", "allow")
+		expectValue(t, server, "wireless.wnet_kids_5g.maclist", "aa:bb:cc:00:00:01 aa:bb:cc:00:00:02")
+	}
+
+	if _, err := ste.RemoveStation(context.Background(), "Kids", "aa:bb:cc:00:00:01"); err != nil {
+		t.Fatal(err)
+	}
+	for _, server := range servers {
+		expectValue(t, server, "wireless.wnet_kids_2g.maclist", "aa:bb:cc:00:00:02")
+	}
+
+	// station moves between SSIDs in one change, so it never stays in both lists
+	if _, err := ste.AddSSID(context.Background(), &site.SSID{Name: "Teens", Auth: "open", Whitelisted: true}); err != nil {
+		t.Fatal(err)
+	}
+	reloads := servers[1].Reloads()
+	report, err := ste.MoveStation(context.Background(), "Kids", "aa:bb:cc:00:00:02", "Teens")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report == nil || servers[1].Reloads() != reloads+1 {
+		t.Errorf("move is not applied once: %v, %d reloads", report, servers[1].Reloads()-reloads)
+	}
+	for _, server := range servers {
+		expectMissing(t, server, "wireless.wnet_kids_2g.maclist")
+		expectValue(t, server, "wireless.wnet_teens_5g.maclist", "aa:bb:cc:00:00:02")
+	}
+	if _, err = ste.MoveStation(context.Background(), "Kids", "aa:bb:cc:00:00:02", "Teens"); err == nil {
+		t.Errorf("station which is not listed is moved")
+	}
+
+	// stations of SSID open to everyone are only listed in the site
+	reloads = servers[0].Reloads()
+	report, err = ste.AddStation(context.Background(), "Home", &site.Station{Mac: "aa:bb:cc:00:00:03"})
+	if err != nil || report != nil {
+		t.Errorf("station of SSID which is not whitelisted is applied: %v, %v", report, err)
+	}
+	if servers[0].Reloads() != reloads {
+		t.Errorf("network is reloaded")
+	}
+	expectMissing(t, servers[0], "wireless.wnet_home_2g.maclist")
+	if stations, _ := ste.GetStations("Home"); len(stations) != 1 {
+		t.Errorf("stations of Home are %v", stations)
+	}
+}
+
+func TestFailedStagingRevertsAllAccessPoints(t *testing.T) {
+	ste := newTestSite(t, nil)
+	servers := []*sshtest.Server{newTestServer(t), newTestServer(t)}
+	addTestAccessPoint(t, ste, "ap1", servers[0])
+	addTestAccessPoint(t, ste, "ap2", servers[1])
+	servers[1].Handle(`uci set wireless\.wnet_office_5g\.ssid=`, sshtest.Response{Stderr: "uci: I/O error\n", ExitCode: 1})
+	reloads := []int{servers[0].Reloads(), servers[1].Reloads()}
+
+	_, err := ste.AddSSID(context.Background(), &site.SSID{Name: "Office", Auth: "wpa2-psk", Password: "office-key"})
+	var applyErr *site.ApplyError
+	if !errors.As(err, &applyErr) {
+		t.Fatalf("expected ApplyError, got %v", err)
+	}
+	if status := applyErr.Results[0].Status; status != site.StatusReverted {
+		t.Errorf("ap1 status is %s, expected %s", status, site.StatusReverted)
+	}
+	if status := applyErr.Results[1].Status; status != site.StatusFailed {
+		t.Errorf("ap2 status is %s, expected %s", status, site.StatusFailed)
+	}
+	var scriptErr *sshclient.ScriptError
+	if !errors.As(err, &scriptErr) || scriptErr.Source != addSSIDTemplate || !strings.Contains(scriptErr.Stderr, "I/O error") {
+		t.Fatalf("expected script error of %s template, got %v", addSSIDTemplate, scriptErr)
+	}
+	// the failed command is rendered from the ssid line of the template
+	template, err := os.ReadFile(TEMPLATES + addSSIDTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(string(template), "\n")
+	if n := scriptErr.SourceLine; n < 1 || n > len(lines) || !strings.Contains(lines[n-1], ".ssid=") {
+		t.Errorf("failed line of %s template is %d", addSSIDTemplate, n)
+	}
+	for i, server := range servers {
+		expectMissing(t, server, "wireless.wnet_office_2g")
+		if changes := server.Uci().Changes("wireless"); len(changes) != 0 {
+			t.Errorf("staged changes are not reverted: %v", changes)
+		}
+		if server.Reloads() != reloads[i] {
+			t.Errorf("network is reloaded despite failure")
+		}
+	}
+	if len(ste.GetSSIDs()) != 0 {
+		t.Errorf("SSID is added to the site despite failure")
+	}
+}
+
+func TestApplyRunsAtMostParallelismAccessPoints(t *testing.T) {
+	ste := newTestSite(t, &site.ApplyOptions{Parallelism: 2, Timeout: 10 * time.Second})
+	var mutex sync.Mutex
+	running, maxRunning := 0, 0
+	for i := range 4 {
+		server := newTestServer(t)
+		addTestAccessPoint(t, ste, "ap"+strconv.Itoa(i+1), server)
+		server.HandleFunc(`^/sbin/uci changes `, func(server *sshtest.Server, exec *sshtest.Exec) int {
+			mutex.Lock()
+			running++
+			maxRunning = max(maxRunning, running)
+			mutex.Unlock()
+			time.Sleep(50 * time.Millisecond)
+			mutex.Lock()
+			running--
+			mutex.Unlock()
+			return 0
+		})
+	}
+
+	report, err := ste.AddSSID(context.Background(), &site.SSID{Name: "Home", Auth: "open"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Results) != 4 {
+		t.Errorf("results are %v", report.Results)
+	}
+	if maxRunning != 2 {
+		t.Errorf("%d access points were applied at once, expected 2", maxRunning)
+	}
+}
+
+func TestSlowAccessPointTimesOut(t *testing.T) {
+	ste := newTestSite(t, &site.ApplyOptions{Timeout: 300 * time.Millisecond})
+	servers := []*sshtest.Server{newTestServer(t), newTestServer(t)}
+	addTestAccessPoint(t, ste, "ap1", servers[0])
+	addTestAccessPoint(t, ste, "ap2", servers[1])
+	// ap2 hangs until the test ends
+	hung := make(chan struct{})
+	t.Cleanup(func() {
+		close(hung)
+	})
+	servers[1].HandleFunc(`^/sbin/uci changes `, func(server *sshtest.Server, exec *sshtest.Exec) int {
+		<-hung
+		return 0
+	})
+
+	started := time.Now()
+	_, err := ste.AddSSID(context.Background(), &site.SSID{Name: "Home", Auth: "open"})
+	var applyErr *site.ApplyError
+	if !errors.As(err, &applyErr) {
+		t.Fatalf("expected ApplyError, got %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("apply took %s", elapsed)
+	}
+	if result := applyErr.Results[1]; result.Status != site.StatusFailed || !errors.Is(result.Err, context.DeadlineExceeded) {
+		t.Errorf("ap2 result is %s", result)
+	}
+	if result := applyErr.Results[0]; result.Status != site.StatusReverted {
+		t.Errorf("ap1 result is %s", result)
+	}
+	expectMissing(t, servers[0], "wireless.wnet_home_2g")
+}
+
+func TestConfirmedCommitCancelsRestore(t *testing.T) {
+	ste := newTestSite(t, &site.ApplyOptions{ConfirmTimeout: 6 * time.Second, Timeout: 10 * time.Second})
+	server := newTestServer(t)
+	addTestAccessPoint(t, ste, "ap1", server)
+
+	if _, err := ste.AddSSID(context.Background(), &site.SSID{Name: "Home", Auth: "open"}); err != nil {
+		t.Fatal(err)
+	}
+	if jobs := server.Jobs(); len(jobs) != 0 {
+		t.Errorf("restore is still scheduled: %v", jobs)
+	}
+	if _, ok := server.ReadFile(rollbackArchive); ok {
+		t.Errorf("configuration snapshot is not removed")
+	}
+	server.RunJobs()
+	expectValue(t, server, "wireless.wnet_home_2g.ssid", "Home")
+}
+
+func TestCommitWaitsForConfirmationWithinTimeout(t *testing.T) {
+	ste := newTestSite(t, &site.ApplyOptions{ConfirmTimeout: 6 * time.Second, Timeout: 10 * time.Second})
+	if timeout := ste.commitTimeout(); timeout != 16*time.Second {
+		t.Errorf("commit timeout is %s", timeout)
+	}
+	// no timeout means no limit, not the confirmation timeout alone
+	ste.SetApplyOptions(&site.ApplyOptions{ConfirmTimeout: 6 * time.Second})
+	if timeout := ste.commitTimeout(); timeout != 0 {
+		t.Errorf("commit without timeout is limited to %s", timeout)
+	}
+}
+
+func TestUnreachableAccessPointRestoresConfiguration(t *testing.T) {
+	ste := newTestSite(t, &site.ApplyOptions{ConfirmTimeout: 6 * time.Second, Timeout: 10 * time.Second})
+	server := newTestServer(t)
+	addTestAccessPoint(t, ste, "ap1", server)
+	// reload takes the management network down
+	server.HandleFunc(`^/etc/init.d/network reload$`, func(server *sshtest.Server, exec *sshtest.Exec) int {
+		go server.SetDown(true)
+		return 0
+	})
+
+	_, err := ste.AddSSID(context.Background(), &site.SSID{Name: "Home", Auth: "open"})
+	var applyErr *site.ApplyError
+	if !errors.As(err, &applyErr) || applyErr.Results[0].Status != site.StatusFailed {
+		t.Fatalf("expected commit failure, got %v", err)
+	}
+	expectValue(t, server, "wireless.wnet_home_2g.ssid", "Home")
+	if jobs := server.Jobs(); len(jobs) != 1 {
+		t.Fatalf("expected restore to be scheduled, got %v", jobs)
+	}
+	server.RunJobs()
+	if _, ok := server.Uci().Get("wireless.wnet_home_2g"); ok {
+		t.Errorf("configuration is not restored")
+	}
+	expectValue(t, server, "wireless.default_radio0.ssid", "OpenWrt")
+}
+
+func TestProtectedKeyPassphraseIsNotSaved(t *testing.T) {
+	ste := newTestSite(t, nil)
+	data, err := os.ReadFile(ste.sshKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.ParseRawPrivateKey(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKeyWithPassphrase(key, "", []byte("key-secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, ste.sshKey, pem.EncodeToMemory(block))
+	t.Setenv(sshclient.PassphraseEnv, "key-secret")
+	server := newTestServer(t)
+	addTestAccessPoint(t, ste, "ap1", server)
+
+	if logins := server.Logins(); !slices.Contains(logins, "root publickey") {
+		t.Errorf("protected site key is not used, logins are %q", logins)
+	}
+	if saved, _ := os.ReadFile(ste.path); strings.Contains(string(saved), "key-secret") {
+		t.Errorf("passphrase is saved in site file:\n%s", saved)
+	}
+}
+
+func TestChangedHostKeyIsRefusedUntilTrusted(t *testing.T) {
+	ste := newTestSite(t, nil)
+	server := newTestServer(t)
+	addTestAccessPoint(t, ste, "ap1", server)
+	hostKey, err := sshtest.NewHostKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.SetHostKey(hostKey)
+	// drop pooled connection made with the old key
+	ste.Close()
+
+	_, err = ste.AddSSID(context.Background(), &site.SSID{Name: "Home", Auth: "open"})
+	var changedErr *sshclient.HostKeyChangedError
+	if !errors.As(err, &changedErr) {
+		t.Fatalf("expected host key change to be detected, got %v", err)
+	}
+	expectMissing(t, server, "wireless.wnet_home_2g")
+
+	fingerprint, err := ste.TrustAccessPoint(context.Background(), "ap1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := ssh.FingerprintSHA256(hostKey.PublicKey()); fingerprint != expected {
+		t.Errorf("trusted fingerprint %s, expected %s", fingerprint, expected)
+	}
+	if _, err = ste.AddSSID(context.Background(), &site.SSID{Name: "Home", Auth: "open"}); err != nil {
+		t.Fatal(err)
+	}
+	expectValue(t, server, "wireless.wnet_home_2g.ssid", "Home")
+}
+
+func TestAccessPointsAreReachedThroughJumpHost(t *testing.T) {
+	ste := newTestSite(t, nil)
+	jump := newTestServer(t)
+	publicKey, _ := os.ReadFile(ste.sshPublicKey)
+	siteKey, _, _, _, err := ssh.ParseAuthorizedKey(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = jump.AuthorizeKey(siteKey); err != nil {
+		t.Fatal(err)
+	}
+	// as ssh-keyscan prints it, with the host name before the key
+	hostKey := jump.Host() + " " + string(ssh.MarshalAuthorizedKey(jump.HostKey()))
+	if err = ste.AddJumpHost(&site.JumpHost{Host: jump.Host(), Port: jump.Port(), User: "root", HostKey: hostKey}); err != nil {
+		t.Fatal(err)
+	}
+	if saved := ste.GetSite().JumpHosts[0].HostKey; !strings.HasPrefix(saved, jump.HostKey().Type()+" ") {
+		t.Errorf("host key is saved as %q", saved)
+	}
+	server := newTestServer(t)
+	addTestAccessPoint(t, ste, "ap1", server)
+	if _, err = ste.AddSSID(context.Background(), &site.SSID{Name: "Home", Auth: "open"}); err != nil {
+		t.Fatal(err)
+	}
+	expectValue(t, server, "wireless.wnet_home_2g.ssid", "Home")
+	forwards := jump.Forwards()
+	if len(forwards) == 0 || slices.ContainsFunc(forwards, func(address string) bool { return address != server.Addr() }) {
+		t.Errorf("jump host forwarded %v, expected connections to %s only", forwards, server.Addr())
+	}
+
+	// host key of the jump host is pinned
+	otherKey, err := sshtest.NewHostKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	jump.SetHostKey(otherKey)
+	ste.clients.Forget("ap1")
+	if plans, _ := ste.Plan(context.Background()); plans[0].Err == nil {
+		t.Error("access point is reached through jump host with unknown host key")
+	}
+
+	if err = ste.RemoveJumpHost(jump.Addr()); err != nil {
+		t.Fatal(err)
+	}
+	if plans, _ := ste.Plan(context.Background()); plans[0].Err != nil {
+		t.Errorf("access point is not reached directly: %s", plans[0].Err)
+	}
+	if len(jump.Forwards()) != len(forwards) {
+		t.Error("removed jump host is still used")
+	}
+}
+
+func TestPlanShowsDrift(t *testing.T) {
+	ste := newTestSite(t, nil)
+	if _, err := ste.AddSSID(context.Background(), &site.SSID{Name: "Home", Auth: "open"}); err != nil {
+		t.Fatal(err)
+	}
+	servers := []*sshtest.Server{newTestServer(t), newTestServer(t)}
+	addTestAccessPoint(t, ste, "ap1", servers[0])
+	addTestAccessPoint(t, ste, "ap2", servers[1])
+
+	plans, err := ste.Plan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, plan := range plans {
+		if plan.Err != nil || len(plan.Changes) != 0 {
+			t.Errorf("%s is expected to match the site: %s", plan.Name, plan.Summary())
+		}
+	}
+
+	config := strings.Replace(servers[0].Uci().Export("wireless"), "'Home'", "'Guest'", 1) +
+		"config wifi-iface 'wnet_old_2g'\n\toption ssid 'Old'\n"
+	if err = servers[0].Uci().Load("wireless", config); err != nil {
+		t.Fatal(err)
+	}
+	servers[1].SetDown(true)
+	plans, err = ste.Plan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var changes []string
+	for _, change := range plans[0].Changes {
+		changes = append(changes, change.String())
+	}
+	expected := []string{
+		"- wireless.wnet_old_2g = wifi-iface",
+		"- wireless.wnet_old_2g.ssid = 'Old'",
+		"~ wireless.wnet_home_2g.ssid: 'Guest' -> 'Home'",
+	}
+	if !slices.Equal(changes, expected) {
+		t.Errorf("ap1 changes are\n%s", strings.Join(changes, "\n"))
+	}
+	if plans[1].Err == nil {
+		t.Errorf("unreachable ap2 is planned")
+	}
+	if servers[0].Reloads() != 1 || len(servers[0].Uci().Changes("wireless")) != 0 {
+		t.Errorf("plan changed the access point")
+	}
+}
+
+func TestReconcileIsIdempotent(t *testing.T) {
+	ste := newTestSite(t, nil)
+	servers := []*sshtest.Server{newTestServer(t), newTestServer(t)}
+	addTestAccessPoint(t, ste, "ap1", servers[0])
+	addTestAccessPoint(t, ste, "ap2", servers[1])
+	// the site file is edited to define SSIDs the access points do not have yet
+	ste.ssids = append(ste.ssids, siteSsidToSsid(&site.SSID{Name: "Home", Auth: "wpa2-psk", Password: "home-key"}),
+		siteSsidToSsid(&site.SSID{Name: "IoT", Auth: "open", Vlan: 20}))
+	config := servers[1].Uci().Export("wireless") + "config wifi-iface 'wnet_old_2g'\n\toption ssid 'Old'\n"
+	if err := servers[1].Uci().Load("wireless", config); err != nil {
+		t.Fatal(err)
+	}
+
+	plans, report, err := ste.Reconcile(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, plan := range plans {
+		if len(plan.Changes) == 0 {
+			t.Errorf("%s is expected to change", plan.Name)
+		}
+	}
+	if report == nil || len(report.Results) != 2 || report.Results[0].Status != site.StatusCommitted ||
+		report.Results[1].Status != site.StatusCommitted {
+		t.Errorf("unexpected report %v", report)
+	}
+	for _, server := range servers {
+		expectValue(t, server, "wireless.wnet_home_5g.key", "home-key")
+		expectValue(t, server, "wireless.wnet_iot_2g.network", "wnet_vlan20")
+		expectValue(t, server, "network.wnet_vlan20_br.ports", "br-lan.20")
+		if reloads := server.Reloads(); reloads != 1 {
+			t.Errorf("network reloaded %d times", reloads)
+		}
+	}
+	expectMissing(t, servers[1], "wireless.wnet_old_2g")
+
+	plans, report, err = ste.Reconcile(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report != nil {
+		t.Errorf("nothing is expected to be applied: %v", report)
+	}
+	for i, plan := range plans {
+		if len(plan.Changes) != 0 {
+			t.Errorf("%s is changed again: %s", plan.Name, plan.Summary())
+		}
+		if reloads := servers[i].Reloads(); reloads != 1 {
+			t.Errorf("%s is reloaded without changes", plan.Name)
+		}
+	}
+}
+
+// reloadTestSite loads the site file of ste again, as the next run of a command does.
+func reloadTestSite(t *testing.T, ste *Site) *Site {
+	t.Helper()
+	manager, err := NewSiteManager("test", ste.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { manager.Close() })
+	manager.SetApplyOptions(&ste.options)
+	return manager.(*Site)
+}
+
+func TestRoamingKeyOfOldSiteIsKept(t *testing.T) {
+	ste := newTestSite(t, nil)
+	server := newTestServer(t)
+	addTestAccessPoint(t, ste, "ap1", server)
+	// the site file is written by a version without fast roaming key and edited to enable fast roaming
+	ste.roamingKey = ""
+	ste.ssids = append(ste.ssids, siteSsidToSsid(&site.SSID{Name: "Voice", Auth: "wpa2-psk", Password: "voice-key", FastRoaming: true}))
+	if err := ste.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	reloads := server.Reloads()
+	if _, report, err := reloadTestSite(t, ste).Reconcile(context.Background()); err != nil || report == nil {
+		t.Fatalf("fast roaming SSID is not applied: %v, %v", report, err)
+	}
+	plans, report, err := reloadTestSite(t, ste).Reconcile(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report != nil || len(plans[0].Changes) != 0 {
+		t.Errorf("fast roaming key changed between runs: %s", plans[0].Summary())
+	}
+	if count := server.Reloads() - reloads; count != 1 {
+		t.Errorf("network reloaded %d times, expected by the first apply only", count)
+	}
+}
+
+func TestDawnKeysOfEditedSiteAreGenerated(t *testing.T) {
+	ste := newTestSite(t, nil)
+	server := newTestServer(t)
+	addTestAccessPoint(t, ste, "ap1", server)
+	if _, err := ste.SetSteering(context.Background(), &site.Steering{Policy: site.SteeringDawn}); err != nil {
+		t.Fatal(err)
+	}
+	// the policy is written to the site file by hand, without keys
+	ste.steering = &Steering{Policy: site.SteeringDawn, MinSignal: -80}
+	if err := ste.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := reloadTestSite(t, ste).Reconcile(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if key, _ := server.Uci().Get("dawn.@network[0].shared_key"); len(key) != 2*dawnKeyLength {
+		t.Errorf("dawn shared key is %q", key)
+	}
+	plans, report, err := reloadTestSite(t, ste).Reconcile(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report != nil || len(plans[0].Changes) != 0 {
+		t.Errorf("dawn keys changed between runs: %s", plans[0].Summary())
+	}
+}
+
+func TestReconcileRequiresAllAccessPoints(t *testing.T) {
+	ste := newTestSite(t, nil)
+	servers := []*sshtest.Server{newTestServer(t), newTestServer(t)}
+	addTestAccessPoint(t, ste, "ap1", servers[0])
+	addTestAccessPoint(t, ste, "ap2", servers[1])
+	ste.ssids = append(ste.ssids, siteSsidToSsid(&site.SSID{Name: "Home", Auth: "open"}))
+	servers[1].SetDown(true)
+
+	if _, _, err := ste.Reconcile(context.Background()); err == nil {
+		t.Fatal("reconciled site with unreachable access point")
+	}
+	expectMissing(t, servers[0], "wireless.wnet_home_2g")
+}
+
+func TestStatusReportsDrift(t *testing.T) {
+	ste := newTestSite(t, nil)
+	servers := []*sshtest.Server{newTestServer(t), newTestServer(t), newTestServer(t)}
+	for i, server := range servers {
+		addTestAccessPoint(t, ste, "ap"+strconv.Itoa(i+1), server)
+	}
+	if _, err := ste.AddSSID(context.Background(), &site.SSID{Name: "Home", Auth: "open"}); err != nil {
+		t.Fatal(err)
+	}
+	// changed through LuCI
+	config := strings.Replace(servers[1].Uci().Export("wireless"), "'Home'", "'Home 2'", 1)
+	if err := servers[1].Uci().Load("wireless", config); err != nil {
+		t.Fatal(err)
+	}
+	servers[2].SetDown(true)
+
+	statuses, err := ste.Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := statuses[0]; !status.Reachable || !status.InSync() || status.Firmware != "OpenWrt 23.05.3 r23809-234f1a2efa" ||
+		status.Uptime.Truncate(time.Second) != 93784*time.Second {
+		t.Errorf("ap1 status is %+v", status)
+	}
+	if status := statuses[1]; !status.Reachable || status.InSync() || len(status.Changes) != 1 ||
+		status.Changes[0].Action != site.ActionChange {
+		t.Errorf("ap2 status is %+v", status)
+	}
+	if status := statuses[2]; status.Reachable || status.Err == nil {
+		t.Errorf("ap3 status is %+v", status)
+	}
+}
+
+func TestStatusOfAccessPointFailingCommandsIsReachable(t *testing.T) {
+	ste := newTestSite(t, nil)
+	server := newTestServer(t)
+	addTestAccessPoint(t, ste, "ap1", server)
+	server.Handle(`^cat /etc/openwrt_release$`, sshtest.Response{Stderr: "cat: can't open '/etc/openwrt_release'\n", ExitCode: 1})
+
+	statuses, err := ste.Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := statuses[0]; !status.Reachable || status.Err == nil || status.InSync() {
+		t.Errorf("ap1 status is %+v", status)
+	}
+}
+
+// expectKeyHolders checks that fast roaming lists of the SSID section on the server list both radios
+// of every access point served by servers.
+func expectKeyHolders(t *testing.T, ste *Site, server *sshtest.Server, section string, servers ...*sshtest.Server) {
+	t.Helper()
+	var r0kh, r1kh []string
+	for _, peer := range servers {
+		for _, band := range []string{"2g", "5g"} {
+			bssid, _ := peer.Uci().Get("wireless." + section + "_" + band + ".macaddr")
+			r0kh = append(r0kh, bssid+","+strings.ReplaceAll(bssid, ":", "")+","+ste.roamingKey)
+			r1kh = append(r1kh, bssid+","+bssid+","+ste.roamingKey)
+		}
+	}
+	for _, band := range []string{"2g", "5g"} {
+		expectValue(t, server, "wireless."+section+"_"+band+".r0kh", strings.Join(r0kh, " "))
+		expectValue(t, server, "wireless."+section+"_"+band+".r1kh", strings.Join(r1kh, " "))
+	}
+}
+
+func TestFastRoamingFollowsAccessPoints(t *testing.T) {
+	ste := newTestSite(t, nil)
+	_, err := ste.AddSSID(context.Background(), &site.SSID{Name: "Voice", Auth: "wpa2-psk", Password: "voice-key", FastRoaming: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	servers := []*sshtest.Server{newTestServer(t), newTestServer(t), newTestServer(t)}
+	addTestAccessPoint(t, ste, "ap1", servers[0])
+	addTestAccessPoint(t, ste, "ap2", servers[1])
+
+	if len(ste.roamingKey) != 2*roamingKeyLength {
+		t.Errorf("roaming key is %q", ste.roamingKey)
+	}
+	domain, _ := servers[0].Uci().Get("wireless.wnet_voice_2g.mobility_domain")
+	var bssids []string
+	for _, server := range servers[:2] {
+		expectValue(t, server, "wireless.wnet_voice_2g.ieee80211r", "1")
+		expectValue(t, server, "wireless.wnet_voice_5g.mobility_domain", domain)
+		for _, band := range []string{"2g", "5g"} {
+			bssid, _ := server.Uci().Get("wireless.wnet_voice_" + band + ".macaddr")
+			expectValue(t, server, "wireless.wnet_voice_"+band+".nasid", strings.ReplaceAll(bssid, ":", ""))
+			bssids = append(bssids, bssid)
+		}
+		expectKeyHolders(t, ste, server, "wnet_voice", servers[0], servers[1])
+	}
+	if len(domain) != 4 || len(slices.Compact(slices.Sorted(slices.Values(bssids)))) != 4 {
+		t.Errorf("mobility domain %q, BSSIDs %v", domain, bssids)
+	}
+
+	addTestAccessPoint(t, ste, "ap0", servers[2])
+	for _, server := range servers {
+		expectKeyHolders(t, ste, server, "wnet_voice", servers[2], servers[0], servers[1])
+	}
+	if _, err = ste.RemoveAccessPoint(context.Background(), "ap1"); err != nil {
+		t.Fatal(err)
+	}
+	for _, server := range servers[1:] {
+		expectKeyHolders(t, ste, server, "wnet_voice", servers[2], servers[1])
+	}
+	plans, err := ste.Plan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, plan := range plans {
+		if plan.Err != nil || len(plan.Changes) != 0 {
+			t.Errorf("%s is expected to match the site: %s", plan.Name, plan.Summary())
+		}
+	}
+
+	// the key is saved with the site
+	loaded, err := NewSiteManager("test", ste.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer loaded.Close()
+	if key := loaded.(*Site).roamingKey; key != ste.roamingKey {
+		t.Errorf("roaming key changed to %q", key)
+	}
+}
+
+func TestFastRoamingRequiresWPA(t *testing.T) {
+	ste := newTestSite(t, nil)
+	_, err := ste.AddSSID(context.Background(), &site.SSID{Name: "Lobby", Auth: "open", FastRoaming: true})
+	if err == nil || len(ste.GetSSIDs()) != 0 {
+		t.Errorf("open SSID with fast roaming is added: %v", err)
+	}
+}
+
+// reportedBssids returns BSSIDs of neighbour reports set to the hostapd instance of the server.
+func reportedBssids(server *sshtest.Server, object string) []string {
+	var bssids []string
+	for _, report := range server.NeighbourReports(object) {
+		bssids = append(bssids, report[0])
+	}
+	return bssids
+}
+
+func TestNeighbourReportsFollowAccessPoints(t *testing.T) {
+	ste := newTestSite(t, nil)
+	_, err := ste.AddSSID(context.Background(), &site.SSID{Name: "Voice", Auth: "wpa2-psk", Password: "voice-key", FastRoaming: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	servers := []*sshtest.Server{newTestServer(t), newTestServer(t), newTestServer(t)}
+	for i, server := range servers {
+		addTestAccessPoint(t, ste, "ap"+strconv.Itoa(i+1), server)
+	}
+	bssid := func(server *sshtest.Server, band string) string {
+		value, _ := server.Uci().Get("wireless.wnet_voice_" + band + ".macaddr")
+		return value
+	}
+
+	// the site SSID is served by phy0-ap1 and phy1-ap1 and has the same name on both bands, so reports cover both
+	for i, server := range servers {
+		expectValue(t, server, "wireless.wnet_voice_2g.ieee80211k", "1")
+		expectValue(t, server, "wireless.wnet_voice_5g.bss_transition", "1")
+		var expected []string
+		for j, peer := range servers {
+			if j != i {
+				expected = append(expected, bssid(peer, "2g"), bssid(peer, "5g"))
+			}
+		}
+		if reported := reportedBssids(server, "hostapd.phy1-ap1"); !slices.Equal(reported, expected) {
+			t.Errorf("ap%d reports %v, expected %v", i+1, reported, expected)
+		}
+		if reported := server.NeighbourReports("hostapd.phy0-ap0"); len(reported) != 4 || reported[0][1] != "OpenWrt" {
+			t.Errorf("ap%d reports %v for default SSID", i+1, reported)
+		}
+	}
+
+	if _, err = ste.RemoveAccessPoint(context.Background(), "ap2"); err != nil {
+		t.Fatal(err)
+	}
+	if reported := reportedBssids(servers[0], "hostapd.phy0-ap1"); !slices.Equal(reported, []string{bssid(servers[2], "2g"), bssid(servers[2], "5g")}) {
+		t.Errorf("ap1 reports %v after ap2 is removed", reported)
+	}
+	if reported := reportedBssids(servers[2], "hostapd.phy1-ap1"); !slices.Equal(reported, []string{bssid(servers[0], "2g"), bssid(servers[0], "5g")}) {
+		t.Errorf("ap3 reports %v after ap2 is removed", reported)
+	}
+
+	// adding an SSID reloads wireless configuration, which drops reports of SSIDs the access points already serve
+	if _, err = ste.AddSSID(context.Background(), &site.SSID{Name: "Lobby", Auth: "open"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, pair := range [][2]int{{0, 2}, {2, 0}} {
+		server, peer := servers[pair[0]], servers[pair[1]]
+		expected := []string{bssid(peer, "2g"), bssid(peer, "5g")}
+		if reported := reportedBssids(server, "hostapd.phy0-ap1"); !slices.Equal(reported, expected) {
+			t.Errorf("ap%d reports %v after SSID is added, expected %v", pair[0]+1, reported, expected)
+		}
+		if reported := server.NeighbourReports("hostapd.phy1-ap2"); len(reported) != 2 || reported[0][1] != "Lobby" {
+			t.Errorf("ap%d reports %v for added SSID", pair[0]+1, reported)
+		}
+	}
+}
+
+func TestSteeringFollowsPolicy(t *testing.T) {
+	ste := newTestSite(t, nil)
+	servers := []*sshtest.Server{newTestServer(t), newTestServer(t)}
+	addTestAccessPoint(t, ste, "ap1", servers[0])
+	addTestAccessPoint(t, ste, "ap2", servers[1])
+
+	_, err := ste.SetSteering(context.Background(), &site.Steering{Policy: site.SteeringUsteer, MinSignal: -80, RoamSignal: -70})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, server := range servers {
+		if enabled, running := server.ServiceState("usteer"); !enabled || !running {
+			t.Errorf("usteer of ap%d is enabled %v, running %v", i+1, enabled, running)
+		}
+		expectValue(t, server, "usteer.wnet_steering.min_snr", "-80")
+		expectValue(t, server, "usteer.wnet_steering.roam_trigger_snr", "-70")
+	}
+
+	dawn := &site.Steering{Policy: site.SteeringDawn, MinSignal: -85, RoamSignal: -65, LoadKickClients: 10}
+	if _, err = ste.SetSteering(context.Background(), dawn); err != nil {
+		t.Fatal(err)
+	}
+	key, _ := servers[0].Uci().Get("dawn.@network[0].shared_key")
+	if len(key) != 2*dawnKeyLength {
+		t.Errorf("dawn shared key is %q", key)
+	}
+	for i, server := range servers {
+		if enabled, running := server.ServiceState("usteer"); enabled || running {
+			t.Errorf("usteer of ap%d is enabled %v, running %v", i+1, enabled, running)
+		}
+		if enabled, running := server.ServiceState("dawn"); !enabled || !running {
+			t.Errorf("dawn of ap%d is enabled %v, running %v", i+1, enabled, running)
+		}
+		expectValue(t, server, "dawn.@network[0].shared_key", key)
+		expectValue(t, server, "dawn.global.max_station_diff", "10")
+		expectValue(t, server, "dawn.global.kicking", "1")
+		expectValue(t, server, "dawn.802_11a.low_rssi_val", "-85")
+		expectValue(t, server, "dawn.802_11g.rssi_val", "-65")
+		// score weights keep DAWN defaults
+		expectValue(t, server, "dawn.802_11a.rssi", "10")
+		expectValue(t, server, "dawn.802_11g.low_rssi", "-15")
+	}
+
+	plans, err := ste.Plan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, plan := range plans {
+		if plan.Err != nil || len(plan.Changes) != 0 {
+			t.Errorf("%s is expected to match the site: %s", plan.Name, plan.Summary())
+		}
+	}
+
+	servers[1].StopService("dawn")
+	statuses, err := ste.Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := statuses[0]; status.Steering != site.SteeringDawn || !status.SteeringHealthy() {
+		t.Errorf("ap1 status is %+v", status)
+	}
+	if status := statuses[1]; status.SteeringRunning || status.SteeringHealthy() {
+		t.Errorf("ap2 status is %+v", status)
+	}
+}
+
+func TestAuthModesMapToEncryption(t *testing.T) {
+	ste := newTestSite(t, nil)
+	servers := []*sshtest.Server{newTestServer(t), newTestServer(t)}
+	addTestAccessPoint(t, ste, "ap1", servers[0])
+	addTestAccessPoint(t, ste, "ap2", servers[1])
+	ssids := []*site.SSID{
+		{Name: "Home", Auth: site.AuthWpa3Sae, Password: "home-key"},
+		{Name: "Legacy", Auth: site.AuthWpa2Wpa3Mixed, Password: "legacy-key"},
+		{Name: "Cafe", Auth: site.AuthOwe},
+		// SSID without mode is protected by its password if it has one
+		{Name: "Office", Password: "office-key"},
+		{Name: "Lobby"},
+	}
+	for _, ssid := range ssids {
+		if _, err := ste.AddSSID(context.Background(), ssid); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, server := range servers {
+		expectValue(t, server, "wireless.wnet_home_5g.encryption", "sae")
+		expectValue(t, server, "wireless.wnet_home_5g.ieee80211w", "2")
+		expectValue(t, server, "wireless.wnet_legacy_2g.encryption", "sae-mixed")
+		expectValue(t, server, "wireless.wnet_legacy_2g.ieee80211w", "1")
+		expectValue(t, server, "wireless.wnet_cafe_2g.encryption", "owe")
+		expectMissing(t, server, "wireless.wnet_cafe_2g.key")
+		expectValue(t, server, "wireless.wnet_office_2g.encryption", "psk2+ccmp")
+		expectValue(t, server, "wireless.wnet_office_5g.key", "office-key")
+		expectValue(t, server, "wireless.wnet_lobby_2g.encryption", "none")
+	}
+	if auth := ste.GetSSIDs()[3].Auth; auth != site.AuthWpa2Psk {
+		t.Errorf("SSID with password is added with auth %q", auth)
+	}
+
+	invalid := []*site.SSID{
+		{Name: "Bad", Auth: "wep"},
+		{Name: "Bad", Auth: site.AuthWpa2Psk, Password: "short"},
+		{Name: "Bad", Auth: site.AuthOwe, FastRoaming: true},
+		// password is never dropped silently
+		{Name: "Bad", Auth: site.AuthOpen, Password: "bad-key1"},
+		{Name: "Bad", Auth: site.AuthOwe, Password: "bad-key1"},
+		{Name: "Bad", Auth: site.AuthWpa2Eap, Password: "bad-key1", Radius: &site.Radius{AuthServers: []string{"10.0.0.5"}, AuthSecret: "radius-secret"}},
+	}
+	for _, ssid := range invalid {
+		if _, err := ste.AddSSID(context.Background(), ssid); err == nil {
+			t.Errorf("SSID with auth %s and password %q is added", ssid.Auth, ssid.Password)
+		}
+	}
+
+	// basic wpad lacks 802.1X, mini one lacks SAE as well
+	servers[1].SetWpad("wpad-mini")
+	update := &site.SSID{Name: "Cafe", Auth: site.AuthWpa3Sae, Password: "cafe-key"}
+	var applyErr *site.ApplyError
+	if _, err := ste.UpdateSSID(context.Background(), update.Name, update); !errors.As(err, &applyErr) || applyErr.Results[1].Err == nil {
+		t.Errorf("SSID with SAE is pushed to wpad-mini: %v", err)
+	}
+	expectValue(t, servers[0], "wireless.wnet_cafe_2g.encryption", "owe")
+	staff := &site.SSID{Name: "Staff", Auth: site.AuthWpa2Eap, Radius: &site.Radius{AuthServers: []string{"10.0.0.5"}, AuthSecret: "radius-secret"}}
+	if _, err := ste.AddSSID(context.Background(), staff); err == nil {
+		t.Errorf("SSID with 802.1X is pushed to wpad-basic")
+	}
+	expectMissing(t, servers[0], "wireless.wnet_staff_2g")
+}
+
+func TestEnterpriseSSIDConfiguresRadius(t *testing.T) {
+	ste := newTestSite(t, nil)
+	servers := []*sshtest.Server{newTestServer(t), newTestServer(t)}
+	for i, server := range servers {
+		server.SetWpad("wpad-mbedtls")
+		addTestAccessPoint(t, ste, "ap"+strconv.Itoa(i+1), server)
+	}
+	radius := &site.Radius{AuthServers: []string{"10.0.0.5", "10.0.0.6"}, AuthSecret: "radius-secret",
+		AcctServers: []string{"10.0.0.5"}, AcctPort: 1646, AcctSecret: "acct-secret", NasId: "office", DynamicVlan: true}
+	if _, err := ste.AddSSID(context.Background(), &site.SSID{Name: "Staff", Auth: site.AuthWpa3Eap, Radius: radius}); err != nil {
+		t.Fatal(err)
+	}
+	// RADIUS server may assign VLAN of this SSID, hostapd puts such clients to its bridge
+	if _, err := ste.AddSSID(context.Background(), &site.SSID{Name: "Guest", Auth: site.AuthOpen, Vlan: 20}); err != nil {
+		t.Fatal(err)
+	}
+	for _, server := range servers {
+		expectValue(t, server, "network.wnet_vlan20_br.name", "br-vlan20")
+		expectValue(t, server, "network.wnet_vlan20_dev.name", "br-lan.20")
+		expectValue(t, server, "wireless.wnet_staff_2g.encryption", "wpa3")
+		expectValue(t, server, "wireless.wnet_staff_2g.auth_server", "10.0.0.5 10.0.0.6")
+		expectValue(t, server, "wireless.wnet_staff_2g.auth_secret", "radius-secret")
+		expectMissing(t, server, "wireless.wnet_staff_2g.auth_port")
+		expectValue(t, server, "wireless.wnet_staff_5g.acct_port", "1646")
+		expectValue(t, server, "wireless.wnet_staff_5g.nasid", "office")
+		expectValue(t, server, "wireless.wnet_staff_5g.dynamic_vlan", "1")
+		expectValue(t, server, "wireless.wnet_staff_5g.vlan_tagged_interface", "br-lan")
+		expectValue(t, server, "wireless.wnet_staff_5g.vlan_bridge", "br-vlan")
+		// hostapd names VLAN devices br-lan.<vid>, as add-ssid does for SSIDs with the VLAN
+		expectValue(t, server, "wireless.wnet_staff_5g.vlan_naming", "1")
+		expectMissing(t, server, "wireless.wnet_staff_5g.key")
+	}
+	plans, err := ste.Plan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, plan := range plans {
+		if plan.Err != nil || len(plan.Changes) != 0 {
+			t.Errorf("%s is expected to match the site: %s", plan.Name, plan.Summary())
+		}
+	}
+	if ssid := ste.GetSSIDs()[0]; ssid.Radius == nil || !slices.Equal(ssid.Radius.AuthServers, radius.AuthServers) {
+		t.Errorf("SSID RADIUS settings are %v", ssid.Radius)
+	}
+
+	invalid := []*site.SSID{
+		{Name: "Bad", Auth: site.AuthWpa2Eap},
+		{Name: "Bad", Auth: site.AuthWpa2Eap, Radius: &site.Radius{AuthServers: []string{"10.0.0.5"}, AuthSecret: "secret",
+			AcctServers: []string{"10.0.0.5"}}},
+		{Name: "Bad", Auth: site.AuthWpa2Eap, FastRoaming: true, Radius: &site.Radius{AuthServers: []string{"10.0.0.5"},
+			AuthSecret: "secret", NasId: "office"}},
+		{Name: "Bad", Auth: site.AuthWpa2Psk, Password: "bad-password", Radius: radius},
+	}
+	for _, ssid := range invalid {
+		if _, err := ste.AddSSID(context.Background(), ssid); err == nil {
+			t.Errorf("SSID with auth %s and RADIUS %v is added", ssid.Auth, ssid.Radius)
+		}
+	}
+}
+
+func TestLegacyAuthModesAreUpgradedOnLoad(t *testing.T) {
+	ste := newTestSite(t, nil)
+	server := newTestServer(t)
+	addTestAccessPoint(t, ste, "ap1", server)
+	if _, err := ste.AddSSID(context.Background(), &site.SSID{Name: "Home", Auth: site.AuthWpa2Psk, Password: "home-key"}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(ste.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// sites created before auth modes were validated keep OpenWrt encryption values
+	writeFile(t, ste.path, []byte(strings.Replace(string(data), "auth: wpa2-psk", "auth: psk2", 1)))
+	manager, err := NewSiteManager("test", ste.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer manager.Close()
+	legacy := manager.(*Site)
+	legacy.SetApplyOptions(&ste.options)
+	if auth := legacy.GetSSIDs()[0].Auth; auth != site.AuthWpa2Psk {
+		t.Errorf("legacy auth is loaded as %q", auth)
+	}
+	// configuration drifted on the access point is restored with the key, not as an open network
+	config := strings.Replace(server.Uci().Export("wireless"), "'home-key'", "'other-key'", 1)
+	if err = server.Uci().Load("wireless", config); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = legacy.Reconcile(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	expectValue(t, server, "wireless.wnet_home_2g.encryption", "psk2+ccmp")
+	expectValue(t, server, "wireless.wnet_home_2g.key", "home-key")
+
+	// sites older than auth modes have none, SSIDs with password were rendered as WPA2 personal
+	writeFile(t, ste.path, []byte(strings.Replace(string(data), "auth: wpa2-psk", `auth: ""`, 1)))
+	manager, err = NewSiteManager("test", ste.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer manager.Close()
+	if auth := manager.GetSSIDs()[0].Auth; auth != site.AuthWpa2Psk {
+		t.Errorf("SSID with password and no auth is loaded as %q", auth)
+	}
+	open := strings.Replace(strings.Replace(string(data), "auth: wpa2-psk", `auth: ""`, 1), "password: home-key", `password: ""`, 1)
+	writeFile(t, ste.path, []byte(open))
+	manager, err = NewSiteManager("test", ste.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer manager.Close()
+	if auth := manager.GetSSIDs()[0].Auth; auth != site.AuthOpen {
+		t.Errorf("SSID without password and auth is loaded as %q", auth)
+	}
+
+	writeFile(t, ste.path, []byte(strings.Replace(string(data), "auth: wpa2-psk", "auth: wep", 1)))
+	if _, err = NewSiteManager("test", ste.path); err == nil {
+		t.Errorf("site with unknown auth mode is loaded")
+	}
+}
+
+func TestFailedCommitRevertsStagedChanges(t *testing.T) {
+	ste := newTestSite(t, nil)
+	server := newTestServer(t)
+	addTestAccessPoint(t, ste, "ap1", server)
+	server.Handle(`uci commit wireless`, sshtest.Response{Stderr: "uci: I/O error\n", ExitCode: 1})
+
+	if _, err := ste.AddSSID(context.Background(), &site.SSID{Name: "Home", Auth: "open"}); err == nil {
+		t.Fatal("SSID is added despite failed commit")
+	}
+	expectMissing(t, server, "wireless.wnet_home_2g")
+	tx, err := ste.accessPoints["ap1"].begin(context.Background())
+	if err != nil {
+		t.Fatalf("next transaction does not begin: %v", err)
+	}
+	tx.close()
+}
+
+func TestRestoreReconnectsAfterReload(t *testing.T) {
+	// access points commit one by one, so ap1 is committed when ap2 fails
+	ste := newTestSite(t, &site.ApplyOptions{Parallelism: 1, Timeout: 5 * time.Second})
+	servers := []*sshtest.Server{newTestServer(t), newTestServer(t)}
+	addTestAccessPoint(t, ste, "ap1", servers[0])
+	addTestAccessPoint(t, ste, "ap2", servers[1])
+	// first reload of ap1 cuts the management path, its pooled session does not respond anymore
+	var stall sync.Once
+	servers[0].HandleFunc(`^/etc/init.d/network reload$`, func(server *sshtest.Server, exec *sshtest.Exec) int {
+		stall.Do(func() {
+			go server.Stall()
+		})
+		return 0
+	})
+	servers[1].Handle(`uci commit wireless`, sshtest.Response{Stderr: "uci: I/O error\n", ExitCode: 1})
+
+	_, err := ste.AddSSID(context.Background(), &site.SSID{Name: "Home", Auth: "open"})
+	var applyErr *site.ApplyError
+	if !errors.As(err, &applyErr) {
+		t.Fatalf("expected ApplyError, got %v", err)
+	}
+	if result := applyErr.Results[0]; result.Status != site.StatusReverted || result.Err != nil {
+		t.Errorf("ap1 result is %s", result)
+	}
+	expectMissing(t, servers[0], "wireless.wnet_home_2g")
+	expectValue(t, servers[0], "wireless.default_radio0.ssid", "OpenWrt")
+}
+
+func TestFailedCommitRestoresCommittedAccessPoints(t *testing.T) {
+	ste := newTestSite(t, nil)
+	servers := []*sshtest.Server{newTestServer(t), newTestServer(t), newTestServer(t)}
+	for i, server := range servers {
+		addTestAccessPoint(t, ste, "ap"+strconv.Itoa(i+1), server)
+	}
+	for _, server := range servers {
+		if _, ok := server.ReadFile(rollbackArchive); ok {
+			t.Errorf("snapshot is left after the change is committed")
+		}
+	}
+	servers[1].Handle(`uci commit wireless`, sshtest.Response{Stderr: "uci: I/O error\n", ExitCode: 1})
+	servers[2].Handle(`tar -xzf`, sshtest.Response{Stderr: "tar: short read\n", ExitCode: 1})
+	reloads := servers[0].Reloads()
+
+	_, err := ste.AddSSID(context.Background(), &site.SSID{Name: "Home", Auth: "open"})
+	var applyErr *site.ApplyError
+	if !errors.As(err, &applyErr) {
+		t.Fatalf("expected ApplyError, got %v", err)
+	}
+	t.Logf("%q", servers[0].Commands())
+	if result := applyErr.Results[0]; result.Status != site.StatusReverted || result.Err != nil {
+		t.Errorf("ap1 result is %s", result)
+	}
+	expectMissing(t, servers[0], "wireless.wnet_home_2g")
+	if servers[0].Reloads() != reloads+2 {
+		t.Errorf("ap1 is reloaded %d times, expected commit and restore reloads", servers[0].Reloads()-reloads)
+	}
+	if result := applyErr.Results[1]; result.Status != site.StatusFailed {
+		t.Errorf("ap2 result is %s", result)
+	}
+	// restore failed, so the change stays on ap3 and the error says so
+	if result := applyErr.Results[2]; result.Status != site.StatusCommitted || result.Err == nil {
+		t.Errorf("ap3 result is %s", result)
+	}
+	expectValue(t, servers[2], "wireless.wnet_home_2g.ssid", "Home")
+	if !strings.Contains(err.Error(), "changes remain on access points which are committed") {
+		t.Errorf("error does not tell the change remains: %v", err)
+	}
+	if len(ste.GetSSIDs()) != 0 {
+		t.Errorf("SSID is added to the site despite failure")
+	}
+}
@@ -0,0 +1,79 @@
+package openwrt
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+	"wnetctl/site"
+)
+
+const releaseFile = "/etc/openwrt_release"
+const uptimeFile = "/proc/uptime"
+
+// Status checks each access point: whether it is reachable, its firmware and uptime, whether the steering daemon
+// of the site runs and whether its configuration matches the site. Nothing is changed on access points.
+func (this *Site) Status(ctx context.Context) ([]*site.AccessPointStatus, error) {
+	aps := this.sortedAccessPoints()
+	statuses := make(map[*AccessPoint]*site.AccessPointStatus, len(aps))
+	for _, ap := range aps {
+		statuses[ap] = &site.AccessPointStatus{Name: ap.name}
+	}
+	results := this.forEach(ctx, aps, this.options.Timeout, func(ctx context.Context, ap *AccessPoint, result *site.AccessPointResult) error {
+		return ap.status(ctx, statuses[ap])
+	})
+	sorted := make([]*site.AccessPointStatus, len(aps))
+	for i, ap := range aps {
+		sorted[i] = statuses[ap]
+		sorted[i].Err = results[i].Err
+	}
+	return sorted, ctx.Err()
+}
+
+func (this *AccessPoint) status(ctx context.Context, status *site.AccessPointStatus) error {
+	// a pooled connection may be stale, a new one is dialed so its error tells that the access point is unreachable
+	this.site.clients.Forget(this.name)
+	sshClient, err := this.connect(ctx)
+	if err != nil {
+		return err
+	}
+	status.Reachable = true
+	results, err := sshClient.RunCommands(ctx, []string{"cat " + releaseFile, "cat " + uptimeFile})
+	if err != nil {
+		return err
+	}
+	status.Firmware = releaseDescription(results[0].Stdout)
+	status.Uptime = parseUptime(results[1].Stdout)
+	if this.site.steering.enabled() {
+		status.Steering = this.site.steering.Policy
+		if status.SteeringRunning, err = this.steeringHealth(ctx, sshClient); err != nil {
+			return err
+		}
+	}
+	drift := new(drift)
+	if err = this.drift(ctx, drift); err != nil {
+		return err
+	}
+	status.Changes = drift.changes
+	return nil
+}
+
+// releaseDescription returns DISTRIB_DESCRIPTION of openwrt_release file, e.g. "OpenWrt 23.05.3 r23809-234f1a2efa".
+func releaseDescription(release string) string {
+	for _, line := range strings.Split(release, "\n") {
+		if value, found := strings.CutPrefix(strings.TrimSpace(line), "DISTRIB_DESCRIPTION="); found {
+			return strings.Trim(value, `'"`)
+		}
+	}
+	return ""
+}
+
+// parseUptime returns uptime of /proc/uptime, which holds seconds since boot followed by idle seconds.
+func parseUptime(uptime string) time.Duration {
+	fields := strings.Fields(uptime)
+	if len(fields) == 0 {
+		return 0
+	}
+	seconds, _ := strconv.ParseFloat(fields[0], 64)
+	return time.Duration(seconds * float64(time.Second))
+}
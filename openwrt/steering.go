@@ -0,0 +1,199 @@
+package openwrt
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"slices"
+	"strings"
+	"wnetctl/site"
+	"wnetctl/sshclient"
+)
+
+// Steering is the client steering policy of the site, see site.Steering. Key and Iv encrypt messages DAWN
+// daemons of access points exchange, they are generated for the site.
+type Steering struct {
+	Policy          string
+	MinSignal       int    `yaml:"minSignal,omitempty"`
+	RoamSignal      int    `yaml:"roamSignal,omitempty"`
+	LoadKickClients int    `yaml:"loadKickClients,omitempty"`
+	Key             string `yaml:",omitempty"`
+	Iv              string `yaml:",omitempty"`
+}
+
+// SteeringModel is the data rendered by steering daemon templates, named after the daemon and its uci package.
+type SteeringModel struct {
+	Section         string
+	Network         string
+	MinSignal       int
+	RoamSignal      int
+	LoadKickClients int
+	Key             string
+	Iv              string
+}
+
+const steeringSection = sectionPrefix + "steering"
+
+// steeringDaemons are packages of steering daemons, each one has an init script and a uci package of the same name.
+var steeringDaemons = []string{site.SteeringUsteer, site.SteeringDawn}
+
+// dawnKeyLength is the length in bytes of DAWN AES key and initialization vector, which are written in hex.
+const dawnKeyLength = 8
+
+// generateKeys generates DAWN key and initialization vector unless they are set, other policies need none.
+// Tells whether they were generated.
+func (this *Steering) generateKeys() (bool, error) {
+	if this.Policy != site.SteeringDawn || this.Key != "" && this.Iv != "" {
+		return false, nil
+	}
+	var err error
+	if this.Key, err = newDawnKey(); err != nil {
+		return false, err
+	}
+	if this.Iv, err = newDawnKey(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (this *Steering) enabled() bool {
+	return this != nil && this.Policy != "" && this.Policy != site.SteeringNone
+}
+
+func (this *Site) GetSteering() *site.Steering {
+	if this.steering == nil {
+		return &site.Steering{Policy: site.SteeringNone}
+	}
+	return steeringToSiteSteering(this.steering)
+}
+
+// SetSteering changes steering policy of the site: installs and enables the daemon of the policy on each access
+// point, stops other ones and configures the daemon. Daemons are restored as they were if the change fails.
+func (this *Site) SetSteering(ctx context.Context, steering *site.Steering) (*site.ApplyReport, error) {
+	if err := steering.Validate(); err != nil {
+		return nil, err
+	}
+	current := this.steering
+	updated := siteSteeringToSteering(steering)
+	if current != nil && current.Policy == updated.Policy {
+		updated.Key, updated.Iv = current.Key, current.Iv
+	}
+	if _, err := updated.generateKeys(); err != nil {
+		return nil, err
+	}
+	aps := this.sortedAccessPoints()
+	this.steering = updated
+	var report *site.ApplyReport
+	err := this.setupSteering(ctx, "Installing "+steering.Policy, aps)
+	if err == nil {
+		report, err = this.apply(ctx, "Setting steering policy "+steering.Policy, aps, func(ap *AccessPoint) (*sshclient.Script, error) {
+			return ap.steeringScript()
+		})
+	}
+	if err != nil {
+		this.steering = current
+		this.setupSteering(context.WithoutCancel(ctx), "Restoring steering", aps)
+		return nil, err
+	}
+	if err = this.save(); err != nil {
+		return report, err
+	}
+	return report, this.refreshNeighbourReports(ctx, report)
+}
+
+// setupSteering installs and enables the steering daemon of the site on access points, other daemons are stopped.
+func (this *Site) setupSteering(ctx context.Context, operation string, aps []*AccessPoint) error {
+	results := this.forEach(ctx, aps, this.options.Timeout, func(ctx context.Context, ap *AccessPoint, result *site.AccessPointResult) error {
+		if err := ap.setupSteering(ctx); err != nil {
+			return err
+		}
+		result.Status = site.StatusCommitted
+		return nil
+	})
+	for _, result := range results {
+		if result.Err != nil {
+			return &site.ApplyError{Operation: operation, Results: results}
+		}
+	}
+	return ctx.Err()
+}
+
+func (this *AccessPoint) setupSteering(ctx context.Context) error {
+	sshClient, err := this.connect(ctx)
+	if err != nil {
+		return err
+	}
+	policy := site.SteeringNone
+	if this.site.steering.enabled() {
+		policy = this.site.steering.Policy
+	}
+	for _, daemon := range steeringDaemons {
+		result, err := sshClient.Run(ctx, "opkg list-installed "+daemon)
+		if err != nil {
+			return err
+		}
+		installed := strings.TrimSpace(result.Stdout) != ""
+		var commands []string
+		switch {
+		case daemon == policy && !installed:
+			commands = []string{"opkg update", "opkg install " + daemon, "/etc/init.d/" + daemon + " enable"}
+		case daemon == policy:
+			commands = []string{"/etc/init.d/" + daemon + " enable"}
+		case installed:
+			commands = []string{"/etc/init.d/" + daemon + " stop", "/etc/init.d/" + daemon + " disable"}
+		}
+		for _, command := range commands {
+			if err = sshClient.Execute(ctx, command); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// steeringScript renders uci commands configuring steering daemon of the site on the access point.
+func (this *AccessPoint) steeringScript() (*sshclient.Script, error) {
+	steering := this.site.steering
+	if !steering.enabled() {
+		return sshclient.NewScript(), nil
+	}
+	model := &SteeringModel{Section: steeringSection, Network: defaultNetwork, MinSignal: steering.MinSignal,
+		RoamSignal: steering.RoamSignal, LoadKickClients: steering.LoadKickClients, Key: steering.Key, Iv: steering.Iv}
+	return renderScript(steering.Policy, model)
+}
+
+// steeringHealth tells whether the steering daemon of the site runs on the access point.
+func (this *AccessPoint) steeringHealth(ctx context.Context, sshClient sshclient.SshClient) (bool, error) {
+	_, err := sshClient.Run(ctx, "/etc/init.d/"+this.site.steering.Policy+" running")
+	var execErr *sshclient.CommandsExecutionError
+	if errors.As(err, &execErr) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// packages returns uci packages the site configures on access points, which are staged, committed and reverted
+// together: network, wireless and the package of the steering daemon.
+func (this *Site) packages() []string {
+	if this.steering.enabled() {
+		return append(slices.Clone(stagedPackages), this.steering.Policy)
+	}
+	return stagedPackages
+}
+
+// reloadCommands make services pick up committed changes.
+func (this *Site) reloadCommands() []string {
+	if this.steering.enabled() {
+		return []string{reloadCommand, "/etc/init.d/" + this.steering.Policy + " reload"}
+	}
+	return []string{reloadCommand}
+}
+
+func newDawnKey() (string, error) {
+	key := make([]byte, dawnKeyLength)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(key), nil
+}
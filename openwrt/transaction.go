@@ -0,0 +1,264 @@
+package openwrt
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+	"wnetctl/site"
+	"wnetctl/sshclient"
+)
+
+// stagedPackages lists uci packages changed by the plugin on any site, see Site.packages.
+var stagedPackages = []string{"network", "wireless"}
+
+// transaction stages uci changes on an access point, then either commits them with a single reload or reverts them.
+// Configuration is saved before commit, so the commit can be undone when the change fails on another access point.
+type transaction struct {
+	ap        *AccessPoint
+	sshClient sshclient.SshClient
+	staged    bool
+	snapshot  bool
+}
+
+// begin opens transaction on the access point. Access point must have no uncommitted changes of staged packages.
+func (this *AccessPoint) begin(ctx context.Context) (*transaction, error) {
+	sshClient, err := this.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tx := &transaction{ap: this, sshClient: sshClient}
+	check := "[ -z \"$(/sbin/uci changes " + strings.Join(this.site.packages(), " ") + ")\" ]"
+	if err = sshClient.Execute(ctx, check); err != nil {
+		sshClient.Close()
+		var execErr *sshclient.CommandsExecutionError
+		if !errors.As(err, &execErr) {
+			return nil, err
+		}
+		return nil, errors.New("Access point " + this.name + " has uncommitted uci changes, commit or revert them first")
+	}
+	return tx, nil
+}
+
+// stage executes uci commands of the script without committing them.
+func (this *transaction) stage(ctx context.Context, script *sshclient.Script) error {
+	if script.Empty() {
+		return nil
+	}
+	this.staged = true
+	_, err := this.sshClient.ExecuteScript(ctx, script)
+	return err
+}
+
+func (this *transaction) commit(ctx context.Context) error {
+	if !this.staged {
+		return nil
+	}
+	if timeout := this.ap.site.options.ConfirmTimeout; timeout > 0 {
+		return this.commitWithConfirmation(ctx, timeout)
+	}
+	if err := this.sshClient.Execute(ctx, snapshotCommand); err != nil {
+		return err
+	}
+	this.snapshot = true
+	for _, pkg := range this.ap.site.packages() {
+		if err := this.sshClient.Execute(ctx, "/sbin/uci commit "+pkg); err != nil {
+			return err
+		}
+	}
+	this.staged = false
+	for _, command := range this.ap.site.reloadCommands() {
+		if err := this.sshClient.Execute(ctx, command); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// revert discards staged changes, retrying once if the connection was lost while reverting.
+func (this *transaction) revert(ctx context.Context) error {
+	err := this.revertPackages(ctx)
+	var execErr *sshclient.CommandsExecutionError
+	if err == nil || errors.As(err, &execErr) || ctx.Err() != nil {
+		return err
+	}
+	if this.sshClient, err = this.ap.connect(ctx); err != nil {
+		return err
+	}
+	return this.revertPackages(ctx)
+}
+
+func (this *transaction) revertPackages(ctx context.Context) error {
+	for _, pkg := range this.ap.site.packages() {
+		if err := this.sshClient.Execute(ctx, "/sbin/uci revert "+pkg); err != nil {
+			return err
+		}
+	}
+	this.staged = false
+	return nil
+}
+
+// restore brings back configuration saved before commit and reloads services.
+// The session is usually gone with the reload of the commit, so a new connection is always made.
+func (this *transaction) restore(ctx context.Context) error {
+	this.ap.site.clients.Forget(this.ap.name)
+	sshClient, err := this.ap.connect(ctx)
+	if err != nil {
+		return err
+	}
+	this.sshClient = sshClient
+	for _, command := range restoreCommands(this.ap.site) {
+		if err := this.sshClient.Execute(ctx, command); err != nil {
+			return err
+		}
+	}
+	this.snapshot = false
+	return nil
+}
+
+// discard removes configuration saved before commit once the change is committed on the whole site.
+func (this *transaction) discard(ctx context.Context) error {
+	if !this.snapshot {
+		return nil
+	}
+	this.snapshot = false
+	return this.sshClient.Execute(ctx, discardCommand)
+}
+
+func (this *transaction) close() error {
+	if this.sshClient == nil {
+		return nil
+	}
+	return this.sshClient.Close()
+}
+
+// apply stages a script rendered for each of access points and commits changes only when staging succeeded on all of them,
+// the report lists the outcome on each access point. Otherwise staged changes are reverted everywhere and *site.ApplyError describes what happened on each access point.
+// When commit fails on some access point, configuration saved before commit is restored on the others.
+// Access points are processed concurrently as site apply options allow; reverting is done even if ctx is cancelled.
+func (this *Site) apply(ctx context.Context, operation string, aps []*AccessPoint,
+	render func(ap *AccessPoint) (*sshclient.Script, error)) (*site.ApplyReport, error) {
+	var mutex sync.Mutex
+	transactions := make(map[*AccessPoint]*transaction)
+	defer func() {
+		for _, tx := range transactions {
+			tx.close()
+		}
+	}()
+	results := this.forEach(ctx, aps, this.options.Timeout, func(ctx context.Context, ap *AccessPoint, result *site.AccessPointResult) error {
+		script, err := render(ap)
+		if err != nil {
+			return err
+		}
+		tx, err := ap.begin(ctx)
+		if err != nil {
+			return err
+		}
+		mutex.Lock()
+		transactions[ap] = tx
+		mutex.Unlock()
+		return tx.stage(ctx, script)
+	})
+	failed := ctx.Err() != nil
+	for _, result := range results {
+		failed = failed || result.Status == site.StatusFailed
+	}
+	if failed {
+		this.revertAll(context.WithoutCancel(ctx), aps, transactions, results)
+		return nil, &site.ApplyError{Operation: operation, Results: results}
+	}
+	results = this.forEach(ctx, aps, this.commitTimeout(), func(ctx context.Context, ap *AccessPoint, result *site.AccessPointResult) error {
+		tx := transactions[ap]
+		if !tx.staged {
+			result.Status = site.StatusUnchanged
+			return nil
+		}
+		if err := tx.commit(ctx); err != nil {
+			// changes left staged would make the next transaction on the access point refuse to begin
+			if !tx.staged {
+				return err
+			}
+			if revertErr := tx.revert(context.WithoutCancel(ctx)); revertErr != nil {
+				return errors.Join(err, errors.New("revert failed: "+revertErr.Error()))
+			}
+			return err
+		}
+		result.Status = site.StatusCommitted
+		return nil
+	})
+	failed = false
+	for _, result := range results {
+		failed = failed || result.Status != site.StatusCommitted && result.Status != site.StatusUnchanged
+	}
+	if failed {
+		this.restoreAll(context.WithoutCancel(ctx), aps, transactions, results)
+		return nil, &site.ApplyError{Operation: operation, Results: results}
+	}
+	this.forEach(ctx, aps, this.options.Timeout, func(ctx context.Context, ap *AccessPoint, result *site.AccessPointResult) error {
+		return transactions[ap].discard(ctx)
+	})
+	return &site.ApplyReport{Operation: operation, Results: results}, nil
+}
+
+// restoreAll restores configuration saved before commit on access points the change was committed to,
+// so that the site is left as it was. An access point restored is reported as reverted, one which could
+// not be restored stays committed. Reachable access points the commit failed on are restored as well, as
+// some packages may have been committed there; unreachable ones restore themselves when confirmation is on.
+func (this *Site) restoreAll(ctx context.Context, aps []*AccessPoint, transactions map[*AccessPoint]*transaction, results []*site.AccessPointResult) {
+	committed := make([]*AccessPoint, 0, len(aps))
+	committedResults := make(map[*AccessPoint]*site.AccessPointResult)
+	for i, ap := range aps {
+		tx := transactions[ap]
+		if tx.snapshot && (results[i].Status == site.StatusCommitted || tx.sshClient != nil) {
+			committed = append(committed, ap)
+			committedResults[ap] = results[i]
+		}
+	}
+	restored := this.forEach(ctx, committed, this.options.Timeout, func(ctx context.Context, ap *AccessPoint, result *site.AccessPointResult) error {
+		return transactions[ap].restore(ctx)
+	})
+	for i, ap := range committed {
+		result := committedResults[ap]
+		result.Duration += restored[i].Duration
+		if restored[i].Err != nil {
+			result.Err = errors.Join(result.Err, errors.New("restore failed: "+restored[i].Err.Error()))
+		} else if result.Status == site.StatusCommitted {
+			result.Status = site.StatusReverted
+		}
+	}
+}
+
+// revertAll reverts staged changes of all transactions, updating results of access points accordingly.
+func (this *Site) revertAll(ctx context.Context, aps []*AccessPoint, transactions map[*AccessPoint]*transaction, results []*site.AccessPointResult) {
+	txAps := make([]*AccessPoint, 0, len(transactions))
+	txResults := make(map[*AccessPoint]*site.AccessPointResult)
+	for i, ap := range aps {
+		if transactions[ap] != nil {
+			txAps = append(txAps, ap)
+			txResults[ap] = results[i]
+		}
+	}
+	reverted := this.forEach(ctx, txAps, this.options.Timeout, func(ctx context.Context, ap *AccessPoint, result *site.AccessPointResult) error {
+		return transactions[ap].revert(ctx)
+	})
+	for i, ap := range txAps {
+		result := txResults[ap]
+		result.Duration += reverted[i].Duration
+		if reverted[i].Err != nil {
+			result.Status = site.StatusFailed
+			result.Err = errors.Join(result.Err, errors.New("revert failed: "+reverted[i].Err.Error()))
+		} else if result.Status != site.StatusFailed {
+			result.Status = site.StatusReverted
+		}
+	}
+}
+
+// commitTimeout limits commit on a single access point, which waits for confirmation on top of the timeout.
+// Zero timeout means no limit.
+func (this *Site) commitTimeout() time.Duration {
+	if this.options.Timeout <= 0 {
+		return 0
+	}
+	return this.options.Timeout + this.options.ConfirmTimeout
+}
@@ -0,0 +1,225 @@
+// Package radius is a minimal RADIUS client (RFC 2865) sending PAP Access-Requests, which is enough to check
+// that a RADIUS server knows the host as a client and shares the secret with it.
+package radius
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Packet codes.
+const (
+	AccessRequest   = 1
+	AccessAccept    = 2
+	AccessReject    = 3
+	AccessChallenge = 11
+)
+
+// Attribute types.
+const (
+	AttrUserName             = 1
+	AttrUserPassword         = 2
+	AttrReplyMessage         = 18
+	AttrNasIdentifier        = 32
+	AttrMessageAuthenticator = 80
+)
+
+const (
+	headerLength        = 20
+	authenticatorLength = 16
+	maxPacketLength     = 4096
+	// retransmitInterval is the time a request waits for a response before it is sent again
+	retransmitInterval = time.Second
+	defaultTimeout     = 5 * time.Second
+)
+
+// ErrBadAuthenticator means the response is not signed with the shared secret of the request.
+var ErrBadAuthenticator = errors.New("Response authenticator does not match, the shared secret is wrong")
+
+// Request is a PAP authentication request.
+type Request struct {
+	User     string
+	Password string
+	NasId    string
+}
+
+// Response is the answer of a RADIUS server to an Access-Request.
+type Response struct {
+	Code    byte
+	Message string
+	Rtt     time.Duration
+}
+
+// Accepted tells whether the server accepted the credentials.
+func (this *Response) Accepted() bool {
+	return this.Code == AccessAccept
+}
+
+func (this *Response) String() string {
+	var result string
+	switch this.Code {
+	case AccessAccept:
+		result = "Access-Accept"
+	case AccessReject:
+		result = "Access-Reject"
+	case AccessChallenge:
+		result = "Access-Challenge"
+	default:
+		result = fmt.Sprintf("code %d", this.Code)
+	}
+	if this.Message != "" {
+		result += " (" + this.Message + ")"
+	}
+	return fmt.Sprintf("%s in %s", result, this.Rtt.Round(time.Millisecond))
+}
+
+// Authenticate sends the request to the server at host:port address and waits for a response, the request is
+// retransmitted every second until ctx is done or 5 seconds pass when ctx has no deadline. Any signed response
+// proves the secret; RADIUS servers silently drop requests of unknown clients and with a wrong secret.
+func Authenticate(ctx context.Context, address, secret string, request *Request) (*Response, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultTimeout)
+		defer cancel()
+	}
+	conn, err := new(net.Dialer).DialContext(ctx, "udp", address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	packet, err := encodeRequest(secret, request)
+	if err != nil {
+		return nil, err
+	}
+	deadline, _ := ctx.Deadline()
+	start := time.Now()
+	buffer := make([]byte, maxPacketLength)
+	for ctx.Err() == nil {
+		if _, err = conn.Write(packet); err != nil {
+			return nil, err
+		}
+		readDeadline := time.Now().Add(retransmitInterval)
+		if deadline.Before(readDeadline) {
+			readDeadline = deadline
+		}
+		conn.SetReadDeadline(readDeadline)
+		for {
+			n, err := conn.Read(buffer)
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			// responses to earlier transmissions have the same identifier and are as good
+			response, err := decodeResponse(buffer[:n], packet, secret)
+			if errors.Is(err, errForeignPacket) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			response.Rtt = time.Since(start)
+			return response, nil
+		}
+	}
+	return nil, fmt.Errorf("No response from %s, the server is unreachable or does not know this host as a client "+
+		"with the shared secret", address)
+}
+
+// errForeignPacket is a packet which is not a response to the request, it is ignored.
+var errForeignPacket = errors.New("Not a response to the request")
+
+func encodeRequest(secret string, request *Request) ([]byte, error) {
+	if len(request.User) > 253 || len(request.NasId) > 253 {
+		return nil, errors.New("User name and NAS identifier must not be longer than 253 characters")
+	}
+	if len(request.Password) > 128 {
+		return nil, errors.New("Password must not be longer than 128 characters")
+	}
+	header := make([]byte, headerLength)
+	header[0] = AccessRequest
+	if _, err := rand.Read(header[1:headerLength]); err != nil {
+		return nil, err
+	}
+	authenticator := header[4:headerLength]
+	packet := bytes.NewBuffer(header)
+	writeAttribute(packet, AttrUserName, []byte(request.User))
+	writeAttribute(packet, AttrUserPassword, hidePassword(secret, authenticator, request.Password))
+	if request.NasId != "" {
+		writeAttribute(packet, AttrNasIdentifier, []byte(request.NasId))
+	}
+	// Message-Authenticator protects the request against forgery (RFC 3579), servers may require it
+	offset := packet.Len() + 2
+	writeAttribute(packet, AttrMessageAuthenticator, make([]byte, authenticatorLength))
+	data := packet.Bytes()
+	binary.BigEndian.PutUint16(data[2:4], uint16(len(data)))
+	mac := hmac.New(md5.New, []byte(secret))
+	mac.Write(data)
+	copy(data[offset:], mac.Sum(nil))
+	return data, nil
+}
+
+func writeAttribute(packet *bytes.Buffer, kind byte, value []byte) {
+	packet.WriteByte(kind)
+	packet.WriteByte(byte(len(value) + 2))
+	packet.Write(value)
+}
+
+// hidePassword encrypts User-Password attribute as RFC 2865 section 5.2 defines.
+func hidePassword(secret string, authenticator []byte, password string) []byte {
+	length := (len(password) + authenticatorLength - 1) / authenticatorLength * authenticatorLength
+	hidden := make([]byte, max(length, authenticatorLength))
+	copy(hidden, password)
+	previous := authenticator
+	for i := 0; i < len(hidden); i += authenticatorLength {
+		hash := md5.New()
+		hash.Write([]byte(secret))
+		hash.Write(previous)
+		for j, b := range hash.Sum(nil) {
+			hidden[i+j] ^= b
+		}
+		previous = hidden[i : i+authenticatorLength]
+	}
+	return hidden
+}
+
+// decodeResponse checks that the packet is a response to the request signed with the secret and decodes it.
+func decodeResponse(packet, request []byte, secret string) (*Response, error) {
+	if len(packet) < headerLength || packet[1] != request[1] {
+		return nil, errForeignPacket
+	}
+	length := int(binary.BigEndian.Uint16(packet[2:4]))
+	if length < headerLength || length > len(packet) {
+		return nil, errors.New("Malformed RADIUS response")
+	}
+	packet = packet[:length]
+	hash := md5.New()
+	hash.Write(packet[:4])
+	hash.Write(request[4:headerLength])
+	hash.Write(packet[headerLength:])
+	hash.Write([]byte(secret))
+	if !hmac.Equal(hash.Sum(nil), packet[4:headerLength]) {
+		return nil, ErrBadAuthenticator
+	}
+	response := &Response{Code: packet[0]}
+	for attrs := packet[headerLength:]; len(attrs) > 0; {
+		if len(attrs) < 2 || attrs[1] < 2 || int(attrs[1]) > len(attrs) {
+			return nil, errors.New("Malformed RADIUS response attributes")
+		}
+		if attrs[0] == AttrReplyMessage {
+			response.Message += string(attrs[2:attrs[1]])
+		}
+		attrs = attrs[attrs[1]:]
+	}
+	return response, nil
+}
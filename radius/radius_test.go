@@ -0,0 +1,142 @@
+package radius
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// standIn is a local RADIUS server which knows the client with the secret and authenticates users by PAP.
+// It drops requests with a wrong Message-Authenticator, as FreeRADIUS does, unless lax is set, then it
+// answers them signed with its own secret.
+type standIn struct {
+	conn   net.PacketConn
+	secret string
+	users  map[string]string
+	lax    bool
+}
+
+func newStandIn(t *testing.T, secret string, users map[string]string, lax bool) *standIn {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &standIn{conn: conn, secret: secret, users: users, lax: lax}
+	t.Cleanup(func() { conn.Close() })
+	go server.serve()
+	return server
+}
+
+func (this *standIn) address() string {
+	return this.conn.LocalAddr().String()
+}
+
+func (this *standIn) serve() {
+	buffer := make([]byte, maxPacketLength)
+	for {
+		n, addr, err := this.conn.ReadFrom(buffer)
+		if err != nil {
+			return
+		}
+		if reply := this.handle(buffer[:n]); reply != nil {
+			this.conn.WriteTo(reply, addr)
+		}
+	}
+}
+
+func (this *standIn) handle(request []byte) []byte {
+	if len(request) < headerLength || request[0] != AccessRequest {
+		return nil
+	}
+	attrs := make(map[byte][]byte)
+	var macOffset int
+	for offset := headerLength; offset+2 <= len(request); offset += int(request[offset+1]) {
+		kind, length := request[offset], int(request[offset+1])
+		if length < 2 || offset+length > len(request) {
+			return nil
+		}
+		attrs[kind] = request[offset+2 : offset+length]
+		if kind == AttrMessageAuthenticator {
+			macOffset = offset + 2
+		}
+	}
+	if macOffset == 0 {
+		return nil
+	}
+	zeroed := bytes.Clone(request)
+	copy(zeroed[macOffset:macOffset+authenticatorLength], make([]byte, authenticatorLength))
+	mac := hmac.New(md5.New, []byte(this.secret))
+	mac.Write(zeroed)
+	if !hmac.Equal(mac.Sum(nil), attrs[AttrMessageAuthenticator]) && !this.lax {
+		return nil
+	}
+	// hiding is its own inverse given the same chain of cipher blocks, which are the hidden blocks
+	hidden := attrs[AttrUserPassword]
+	password := make([]byte, len(hidden))
+	previous := request[4:headerLength]
+	for i := 0; i+authenticatorLength <= len(hidden); i += authenticatorLength {
+		hash := md5.Sum(append([]byte(this.secret), previous...))
+		for j := range authenticatorLength {
+			password[i+j] = hidden[i+j] ^ hash[j]
+		}
+		previous = hidden[i : i+authenticatorLength]
+	}
+	code, message := byte(AccessReject), "Invalid credentials"
+	if expected, ok := this.users[string(attrs[AttrUserName])]; ok && expected == strings.TrimRight(string(password), "\x00") {
+		code, message = AccessAccept, "Welcome"
+	}
+	reply := bytes.NewBuffer([]byte{code, request[1], 0, 0})
+	reply.Write(request[4:headerLength])
+	writeAttribute(reply, AttrReplyMessage, []byte(message))
+	data := reply.Bytes()
+	binary.BigEndian.PutUint16(data[2:4], uint16(len(data)))
+	authenticator := md5.Sum(append(bytes.Clone(data), this.secret...))
+	copy(data[4:headerLength], authenticator[:])
+	return data
+}
+
+func TestAuthenticateChecksCredentials(t *testing.T) {
+	server := newStandIn(t, "radius-secret", map[string]string{"alice": "a rather long password of alice"}, false)
+
+	response, err := Authenticate(context.Background(), server.address(), "radius-secret",
+		&Request{User: "alice", Password: "a rather long password of alice", NasId: "wnetctl"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !response.Accepted() || response.Message != "Welcome" {
+		t.Errorf("response is %s", response)
+	}
+
+	response, err = Authenticate(context.Background(), server.address(), "radius-secret", &Request{User: "alice", Password: "guess"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Code != AccessReject || response.Message != "Invalid credentials" {
+		t.Errorf("response is %s", response)
+	}
+}
+
+func TestAuthenticateDetectsWrongSecret(t *testing.T) {
+	server := newStandIn(t, "radius-secret", nil, false)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	_, err := Authenticate(ctx, server.address(), "wrong-secret", &Request{User: "alice", Password: "password"})
+	if err == nil || !strings.HasPrefix(err.Error(), "No response from") {
+		t.Errorf("dropped request returned %v", err)
+	}
+
+	server = newStandIn(t, "radius-secret", nil, true)
+	_, err = Authenticate(context.Background(), server.address(), "wrong-secret", &Request{User: "alice", Password: "password"})
+	if !errors.Is(err, ErrBadAuthenticator) {
+		t.Errorf("response signed with another secret returned %v", err)
+	}
+}
@@ -1,13 +1,14 @@
 package site
 
+import "context"
+
 type AccessPoint interface {
-	Configure() error
-	AddNeighbour(neighbour AccessPoint) error
-	RemoveNeighbour(neighbour AccessPoint) error
-	AddSSID(ssid *SSID) error
-	RemoveSSID(ssid *SSID) error
-	AddStation(mac string) error
-	RemoveStation(mac string) error
+	Configure(ctx context.Context) error
+	AddNeighbour(ctx context.Context, neighbour AccessPoint) error
+	RemoveNeighbour(ctx context.Context, neighbour AccessPoint) error
+	AddSSID(ctx context.Context, ssid *SSID) error
+	RemoveSSID(ctx context.Context, ssid *SSID) error
+	UpdateStations(ctx context.Context, ssid *SSID) error
 	Name() string
 	ToResponse() *AccessPointResponse
 }
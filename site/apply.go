@@ -0,0 +1,110 @@
+package site
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Status of a change applied to a single access point.
+const (
+	StatusCommitted  = "committed"
+	StatusFailed     = "failed"
+	StatusReverted   = "reverted"
+	StatusNotStarted = "not started"
+	StatusUnchanged  = "unchanged"
+)
+
+type AccessPointResult struct {
+	Name     string
+	Status   string
+	Err      error
+	Duration time.Duration
+}
+
+// ApplyError reports a change which was not applied to the whole site, listing outcome for each access point.
+type ApplyError struct {
+	Operation string
+	Results   []*AccessPointResult
+}
+
+// ApplyReport lists outcome of a change committed to the site on each access point.
+type ApplyReport struct {
+	Operation string
+	Results   []*AccessPointResult
+}
+
+func (this *ApplyReport) String() string {
+	sb := new(strings.Builder)
+	sb.WriteString(this.Operation + ":\n")
+	writeResultsTable(sb, this.Results)
+	return strings.TrimRight(sb.String(), "\n")
+}
+
+func (this *AccessPointResult) String() string {
+	if this.Err != nil {
+		return fmt.Sprintf("%s: %s (%s)", this.Name, this.Status, this.Err.Error())
+	}
+	return fmt.Sprintf("%s: %s", this.Name, this.Status)
+}
+
+func (this *ApplyError) Error() string {
+	sb := new(strings.Builder)
+	if slices.ContainsFunc(this.Results, func(result *AccessPointResult) bool { return result.Status == StatusCommitted }) {
+		sb.WriteString(this.Operation + " failed, changes remain on access points which are committed:\n")
+	} else {
+		sb.WriteString(this.Operation + " failed, changes were not applied to the site:\n")
+	}
+	writeResultsTable(sb, this.Results)
+	return strings.TrimRight(sb.String(), "\n")
+}
+
+// Unwrap returns errors of access points, so errors.As finds a cause on any of them.
+func (this *ApplyError) Unwrap() []error {
+	errs := make([]error, 0, len(this.Results))
+	for _, result := range this.Results {
+		if result.Err != nil {
+			errs = append(errs, result.Err)
+		}
+	}
+	return errs
+}
+
+// writeResultsTable writes a table of access point results with columns aligned.
+func writeResultsTable(out io.Writer, results []*AccessPointResult) {
+	table := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "  ACCESS POINT\tSTATUS\tTIME\tERROR")
+	for _, result := range results {
+		message := ""
+		if result.Err != nil {
+			message = result.Err.Error()
+		}
+		fmt.Fprintf(table, "  %s\t%s\t%s\t%s\n", result.Name, result.Status, result.Duration.Round(time.Millisecond), message)
+	}
+	table.Flush()
+}
+
+// Failed returns results of access points the change failed on.
+func (this *ApplyError) Failed() []*AccessPointResult {
+	failed := make([]*AccessPointResult, 0)
+	for _, result := range this.Results {
+		if result.Status == StatusFailed {
+			failed = append(failed, result)
+		}
+	}
+	return failed
+}
+
+// ApplyOptions control how changes are applied to access points of a site.
+type ApplyOptions struct {
+	// ConfirmTimeout enables connectivity-safe apply: access point restores previous configuration by itself
+	// unless the change is confirmed over a new SSH connection within the timeout. Zero disables it.
+	ConfirmTimeout time.Duration
+	// Parallelism limits number of access points changed concurrently, non-positive value means no limit.
+	Parallelism int
+	// Timeout limits time to apply a change to a single access point, zero means no limit.
+	Timeout time.Duration
+}
@@ -0,0 +1,94 @@
+package site
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Authentication modes of SSIDs. Personal modes use the SSID password, enterprise ones authenticate clients
+// against a RADIUS server.
+const (
+	AuthOpen          = "open"
+	AuthOwe           = "owe"
+	AuthWpa2Psk       = "wpa2-psk"
+	AuthWpa3Sae       = "wpa3-sae"
+	AuthWpa2Wpa3Mixed = "wpa2/wpa3-mixed"
+	AuthWpa2Eap       = "wpa2-eap"
+	AuthWpa3Eap       = "wpa3-eap"
+)
+
+var AuthModes = []string{AuthOpen, AuthOwe, AuthWpa2Psk, AuthWpa3Sae, AuthWpa2Wpa3Mixed, AuthWpa2Eap, AuthWpa3Eap}
+
+// Personal tells whether clients of the authentication mode are authenticated with the SSID password.
+func Personal(auth string) bool {
+	return auth == AuthWpa2Psk || auth == AuthWpa3Sae || auth == AuthWpa2Wpa3Mixed
+}
+
+// Enterprise tells whether clients of the authentication mode are authenticated against RADIUS servers.
+func Enterprise(auth string) bool {
+	return auth == AuthWpa2Eap || auth == AuthWpa3Eap
+}
+
+// Length limits of WPA passphrase.
+const (
+	MinPasswordLength = 8
+	MaxPasswordLength = 63
+)
+
+// Default ports of RADIUS authentication and accounting servers.
+const (
+	RadiusAuthPort = 1812
+	RadiusAcctPort = 1813
+)
+
+// Radius is 802.1X configuration of an enterprise SSID. Access points authenticate clients against AuthServers
+// and report sessions to AcctServers, which are tried in order; zero ports are the default ones. NasId is the
+// NAS identifier access points send, BSSID when empty. DynamicVlan puts clients to VLANs RADIUS server assigns.
+type Radius struct {
+	AuthServers []string
+	AuthPort    int
+	AuthSecret  string
+	AcctServers []string
+	AcctPort    int
+	AcctSecret  string
+	NasId       string
+	DynamicVlan bool
+}
+
+// Empty tells whether no RADIUS setting is given.
+func (this *Radius) Empty() bool {
+	return this == nil || len(this.AuthServers) == 0 && this.AuthPort == 0 && this.AuthSecret == "" &&
+		len(this.AcctServers) == 0 && this.AcctPort == 0 && this.AcctSecret == "" && this.NasId == "" && !this.DynamicVlan
+}
+
+func (this *Radius) String() string {
+	if this.Empty() {
+		return "radius: none"
+	}
+	info := fmt.Sprintf("radius: auth %s port %d", strings.Join(this.AuthServers, ", "), radiusPort(this.AuthPort, RadiusAuthPort))
+	if len(this.AcctServers) > 0 {
+		info += fmt.Sprintf(", accounting %s port %d", strings.Join(this.AcctServers, ", "), radiusPort(this.AcctPort, RadiusAcctPort))
+	}
+	if this.NasId != "" {
+		info += ", NAS id " + this.NasId
+	}
+	return info + fmt.Sprintf(", dynamic vlan: %t", this.DynamicVlan)
+}
+
+// AuthAddresses returns host:port addresses of authentication servers.
+func (this *Radius) AuthAddresses() []string {
+	addresses := make([]string, len(this.AuthServers))
+	for i, server := range this.AuthServers {
+		addresses[i] = net.JoinHostPort(server, strconv.Itoa(radiusPort(this.AuthPort, RadiusAuthPort)))
+	}
+	return addresses
+}
+
+func radiusPort(port, defaultPort int) int {
+	if port == 0 {
+		return defaultPort
+	}
+	return port
+}
@@ -2,6 +2,8 @@ package site
 
 import (
 	"fmt"
+	"net"
+	"strconv"
 	"strings"
 )
 
@@ -35,6 +37,7 @@ type AccessPointRequest struct {
 	Model string
 	Mac   string
 	Ip    string
+	Port  int `yaml:",omitempty"`
 }
 
 type AccessPointResponse struct {
@@ -60,13 +63,40 @@ type Station struct {
 }
 
 type SSID struct {
-	Name        string
+	Name string
+	// Auth is one of AuthModes, Password is the passphrase of personal modes
 	Auth        string
 	Password    string
 	Vlan        int
 	Restricted  bool
 	Whitelisted bool
-	Stations    []*Station
+	// FastRoaming enables 802.11r fast BSS transition between access points of the site
+	FastRoaming bool
+	// Radius configures enterprise authentication modes
+	Radius   *Radius
+	Stations []*Station
+}
+
+// JumpHost is an SSH server access points of a site are reached through. HostKey is its public key
+// in authorized_keys format, Key is a private key file, the site key is used when it is empty.
+type JumpHost struct {
+	Host    string
+	Port    int `yaml:",omitempty"`
+	User    string
+	Key     string `yaml:",omitempty"`
+	HostKey string `yaml:"hostKey"`
+}
+
+func (this *JumpHost) String() string {
+	address := this.Host
+	if this.Port > 0 {
+		address = net.JoinHostPort(this.Host, strconv.Itoa(this.Port))
+	}
+	info := this.User + "@" + address
+	if this.Key != "" {
+		info += ", key " + this.Key
+	}
+	return info + ", host key " + this.HostKey
 }
 
 type SiteRequest struct {
@@ -74,8 +104,9 @@ type SiteRequest struct {
 	SshPublicKey string `yaml:"sshPublicKey"`
 	Password     string
 	Country      string
-	SsidSuffix2  string `yaml:"ssidSuffix2"`
-	SsidSuffix5  string `yaml:"ssidSuffix5"`
+	SsidSuffix2  string      `yaml:"ssidSuffix2"`
+	SsidSuffix5  string      `yaml:"ssidSuffix5"`
+	JumpHosts    []*JumpHost `yaml:"jumpHosts"`
 }
 
 type SiteResponse struct {
@@ -85,6 +116,8 @@ type SiteResponse struct {
 	Country      string
 	SsidSuffix2  string                `yaml:"ssidSuffix2"`
 	SsidSuffix5  string                `yaml:"ssidSuffix5"`
+	Steering     *Steering             `yaml:"steering"`
+	JumpHosts    []*JumpHost           `yaml:"jumpHosts"`
 	AccessPoints []*AccessPointRequest `yaml:"accessPoints"`
 	Ssid         []*SSID
 	Devices      []*AccessPointDevice
@@ -97,8 +130,12 @@ func (this *AccessPointRequest) String() string {
 	}
 	//return fmt.Sprintf("AP %s (%s) IP %s MAC %s. 2.4GHz radio on channel %d at %d dBm, 5GHz radio on channel %d at %d dBm",
 	//	this.Name, this.Model, this.Ip, mac, this.WLan2.Channel, this.WLan2.Power, this.WLan5.Channel, this.WLan5.Power)
+	ip := this.Ip
+	if this.Port > 0 {
+		ip = net.JoinHostPort(this.Ip, strconv.Itoa(this.Port))
+	}
 	return fmt.Sprintf("AP %s (%s) IP %s, MAC %s",
-		this.Name, this.Model, this.Ip, mac)
+		this.Name, this.Model, ip, mac)
 }
 
 func (this DeviceWirelessAdapter) String() string {
@@ -138,6 +175,12 @@ func (this *SiteResponse) String() string {
 	info := []string{}
 	info = append(info, fmt.Sprintf("Site configuration:\nSSH key: %s (public %s)", this.SshKey, this.SshPublicKey))
 	info = append(info, fmt.Sprintf("2.4GHz wlan networks suffix: \"%s\"; 5GHz wlan networks suffix: \"%s\"", this.SsidSuffix2, this.SsidSuffix5))
+	if this.Steering != nil {
+		info = append(info, this.Steering.String())
+	}
+	for _, jump := range this.JumpHosts {
+		info = append(info, "Jump host: "+jump.String())
+	}
 	info = append(info, "* Access points:")
 	for _, ap := range this.AccessPoints {
 		info = append(info, ap.String())
@@ -152,3 +195,15 @@ func (this *SiteResponse) String() string {
 	}
 	return strings.Join(info, "\n")
 }
+
+// NormalizeMac validates an Ethernet (EUI-48) MAC address and returns it in lower case colon separated form.
+func NormalizeMac(mac string) (string, error) {
+	hwAddr, err := net.ParseMAC(mac)
+	if err != nil {
+		return "", err
+	}
+	if len(hwAddr) != 6 {
+		return "", fmt.Errorf("%s is not an EUI-48 MAC address", mac)
+	}
+	return hwAddr.String(), nil
+}
@@ -0,0 +1,72 @@
+package site
+
+import (
+	"fmt"
+	"io"
+)
+
+// Actions of configuration changes.
+const (
+	ActionAdd    = "add"
+	ActionChange = "change"
+	ActionRemove = "remove"
+)
+
+// ConfigChange is a configuration entry of an access point which differs from the configuration defined by the site.
+// Old value is empty for added entries and New value is empty for removed ones.
+type ConfigChange struct {
+	Action string
+	Path   string
+	Old    string
+	New    string
+}
+
+// AccessPointPlan lists changes needed to bring an access point to the configuration of the site,
+// Err reports why configuration of the access point could not be compared.
+type AccessPointPlan struct {
+	Name    string
+	Changes []*ConfigChange
+	Err     error
+}
+
+var actionSigns = map[string]string{ActionAdd: "+", ActionChange: "~", ActionRemove: "-"}
+
+func (this *ConfigChange) String() string {
+	switch this.Action {
+	case ActionAdd:
+		return fmt.Sprintf("+ %s = %s", this.Path, this.New)
+	case ActionRemove:
+		return fmt.Sprintf("- %s = %s", this.Path, this.Old)
+	default:
+		return fmt.Sprintf("%s %s: %s -> %s", actionSigns[this.Action], this.Path, this.Old, this.New)
+	}
+}
+
+// Summary counts changes by action, e.g. "2 to add, 1 to change, 0 to remove".
+func (this *AccessPointPlan) Summary() string {
+	if this.Err != nil {
+		return "failed: " + this.Err.Error()
+	}
+	if len(this.Changes) == 0 {
+		return "no changes"
+	}
+	return countChanges(this.Changes)
+}
+
+func countChanges(changes []*ConfigChange) string {
+	counts := make(map[string]int)
+	for _, change := range changes {
+		counts[change.Action]++
+	}
+	return fmt.Sprintf("%d to add, %d to change, %d to remove", counts[ActionAdd], counts[ActionChange], counts[ActionRemove])
+}
+
+// WritePlans writes changes of each access point preceded by its summary.
+func WritePlans(out io.Writer, plans []*AccessPointPlan) {
+	for _, plan := range plans {
+		fmt.Fprintf(out, "%s: %s\n", plan.Name, plan.Summary())
+		for _, change := range plan.Changes {
+			fmt.Fprintf(out, "  %s\n", change)
+		}
+	}
+}
@@ -1,22 +1,37 @@
 package site
 
-import "io"
+import (
+	"context"
+	"io"
+)
 
 type SiteManager interface {
 	GetSite() *SiteResponse
-	AddAccessPoint(model *AccessPointRequest) (AccessPoint, error)
+	AddAccessPoint(ctx context.Context, model *AccessPointRequest) (AccessPoint, *ApplyReport, error)
 	GetAccessPoints() []*AccessPointResponse
 	//UpdateAccessPoint(*AccessPoint) error
-	RemoveAccessPoint(name string) error
-	AddSSID(*SSID) error
+	RemoveAccessPoint(ctx context.Context, name string) (*ApplyReport, error)
+	TrustAccessPoint(ctx context.Context, name string) (string, error)
+	ForgetAccessPointKey(name string) error
+	AddJumpHost(jump *JumpHost) error
+	RemoveJumpHost(host string) error
+	AddSSID(context.Context, *SSID) (*ApplyReport, error)
 	GetSSIDs() []*SSID
-	UpdateSSID(*SSID) error
-	RemoveSSID(string) error
-	AddStation(string, *Station) error
+	UpdateSSID(ctx context.Context, name string, ssid *SSID) (*ApplyReport, error)
+	RemoveSSID(context.Context, string) (*ApplyReport, error)
+	AddStation(ctx context.Context, ssidName string, station *Station) (*ApplyReport, error)
 	GetStations(ssid string) ([]*Station, error)
-	RemoveStation(ssidName, mac string) error
+	RemoveStation(ctx context.Context, ssidName, mac string) (*ApplyReport, error)
+	MoveStation(ctx context.Context, ssidName, mac, targetSsidName string) (*ApplyReport, error)
 	AddDeviceType(device *AccessPointDevice) error
 	RemoveDeviceType(deviceType string) error
 	GetDeviceTypes() []*AccessPointDevice
+	GetSteering() *Steering
+	SetSteering(context.Context, *Steering) (*ApplyReport, error)
 	Export(dest io.Writer) error
+	Plan(ctx context.Context) ([]*AccessPointPlan, error)
+	Reconcile(ctx context.Context) ([]*AccessPointPlan, *ApplyReport, error)
+	Status(ctx context.Context) ([]*AccessPointStatus, error)
+	SetApplyOptions(options *ApplyOptions)
+	Close() error
 }
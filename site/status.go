@@ -0,0 +1,85 @@
+package site
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+)
+
+// AccessPointStatus tells whether an access point is reachable, what it runs and how its configuration differs
+// from the site. Steering is the steering daemon of the site, if any. Err reports why the status could not
+// be determined completely.
+type AccessPointStatus struct {
+	Name            string
+	Reachable       bool
+	Firmware        string
+	Uptime          time.Duration
+	Steering        string
+	SteeringRunning bool
+	Changes         []*ConfigChange
+	Err             error
+}
+
+// InSync tells whether configuration of the access point is known to match the site.
+func (this *AccessPointStatus) InSync() bool {
+	return this.Err == nil && len(this.Changes) == 0
+}
+
+// SteeringHealthy tells whether the steering daemon of the site runs on the access point, if the site has one.
+func (this *AccessPointStatus) SteeringHealthy() bool {
+	return this.Steering == "" || this.SteeringRunning
+}
+
+func (this *AccessPointStatus) steeringState() string {
+	switch {
+	case this.Steering == "":
+		return "-"
+	case this.SteeringRunning:
+		return this.Steering + " running"
+	default:
+		return this.Steering + " not running"
+	}
+}
+
+func (this *AccessPointStatus) configState() string {
+	switch {
+	case this.Err != nil:
+		return "unknown: " + this.Err.Error()
+	case len(this.Changes) > 0:
+		return "drifted, " + countChanges(this.Changes)
+	default:
+		return "in sync"
+	}
+}
+
+// WriteStatuses writes a table of access point statuses followed by changes of drifted access points.
+func WriteStatuses(out io.Writer, statuses []*AccessPointStatus) {
+	table := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "ACCESS POINT\tREACHABLE\tFIRMWARE\tUPTIME\tSTEERING\tCONFIGURATION")
+	for _, status := range statuses {
+		reachable, uptime, steering := "no", "", ""
+		if status.Reachable {
+			reachable, uptime, steering = "yes", formatUptime(status.Uptime), status.steeringState()
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\n", status.Name, reachable, status.Firmware, uptime, steering, status.configState())
+	}
+	table.Flush()
+	for _, status := range statuses {
+		if len(status.Changes) > 0 {
+			fmt.Fprintf(out, "\n%s:\n", status.Name)
+			for _, change := range status.Changes {
+				fmt.Fprintf(out, "  %s\n", change)
+			}
+		}
+	}
+}
+
+// formatUptime formats duration as days, hours and minutes, e.g. "3d 4h 12m".
+func formatUptime(uptime time.Duration) string {
+	minutes := int(uptime.Minutes())
+	if days := minutes / (24 * 60); days > 0 {
+		return fmt.Sprintf("%dd %dh %dm", days, minutes/60%24, minutes%60)
+	}
+	return fmt.Sprintf("%dh %dm", minutes/60, minutes%60)
+}
@@ -0,0 +1,65 @@
+package site
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Steering policies: a daemon running on each access point which steers clients to better access points and bands.
+const (
+	SteeringNone   = "none"
+	SteeringUsteer = "usteer"
+	SteeringDawn   = "dawn"
+)
+
+var SteeringPolicies = []string{SteeringNone, SteeringUsteer, SteeringDawn}
+
+// Steering is the client steering policy of a site. Signal thresholds are in dBm, zero keeps the daemon default.
+// Clients below MinSignal are refused and disconnected, below RoamSignal they are pushed to roam;
+// LoadKickClients is the number of clients of an access point above which clients are moved to less loaded ones.
+type Steering struct {
+	Policy          string
+	MinSignal       int
+	RoamSignal      int
+	LoadKickClients int
+}
+
+// Validate checks that the policy is known and thresholds are in range.
+func (this *Steering) Validate() error {
+	if !slices.Contains(SteeringPolicies, this.Policy) {
+		return fmt.Errorf("Unknown steering policy \"%s\", expected one of %s", this.Policy, strings.Join(SteeringPolicies, ", "))
+	}
+	for _, signal := range []int{this.MinSignal, this.RoamSignal} {
+		if signal < -100 || signal > 0 {
+			return fmt.Errorf("Signal threshold must be in range -100..0 dBm, got %d", signal)
+		}
+	}
+	if this.MinSignal != 0 && this.RoamSignal != 0 && this.RoamSignal < this.MinSignal {
+		return errors.New("Roaming signal threshold must not be below minimal signal")
+	}
+	if this.LoadKickClients < 0 {
+		return fmt.Errorf("Number of clients must not be negative, got %d", this.LoadKickClients)
+	}
+	return nil
+}
+
+// Enabled tells whether a steering daemon runs on access points.
+func (this *Steering) Enabled() bool {
+	return this != nil && this.Policy != "" && this.Policy != SteeringNone
+}
+
+func (this *Steering) String() string {
+	if !this.Enabled() {
+		return "steering: none"
+	}
+	threshold := func(value int, unit string) string {
+		if value == 0 {
+			return "default"
+		}
+		return fmt.Sprintf("%d%s", value, unit)
+	}
+	return fmt.Sprintf("steering: %s, min signal %s, roam signal %s, load kick %s", this.Policy,
+		threshold(this.MinSignal, " dBm"), threshold(this.RoamSignal, " dBm"), threshold(this.LoadKickClients, " clients"))
+}
@@ -0,0 +1,153 @@
+package sshclient
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/term"
+	"io"
+	"net"
+	"os"
+)
+
+// AuthMethod names a source of credentials used to authenticate on a host.
+type AuthMethod string
+
+const (
+	// AuthKey authenticates with private key file set by SshClient.SetKey.
+	AuthKey AuthMethod = "key"
+	// AuthAgent authenticates with keys of ssh-agent listening on SSH_AUTH_SOCK.
+	AuthAgent AuthMethod = "agent"
+	// AuthPassword authenticates with password.
+	AuthPassword AuthMethod = "password"
+)
+
+// PassphraseEnv names the environment variable passphrase of a protected private key is taken from.
+const PassphraseEnv = "WNETCTL_SSH_PASSPHRASE"
+
+// DefaultAuthOrder is the order authentication methods are tried in unless SshClient.SetAuthOrder is called.
+var DefaultAuthOrder = []AuthMethod{AuthKey, AuthAgent, AuthPassword}
+
+// authMethods builds SSH authentication methods in the configured order. Key file and agent keys are offered
+// in a single public key method as SSH client does not retry a method of the same type.
+// Returned closer releases ssh-agent connection, if any, and must be called once handshake completes.
+func (this *sshClient) authMethods() ([]ssh.AuthMethod, io.Closer, error) {
+	order := this.authOrder
+	if order == nil {
+		order = DefaultAuthOrder
+	}
+	var methods []ssh.AuthMethod
+	var signers []ssh.Signer
+	var closer io.Closer = nopCloser{}
+	var errs []error
+	publicKeysAt := -1
+	for _, method := range order {
+		switch method {
+		case AuthKey:
+			if this.key == "" {
+				continue
+			}
+			signer, err := loadPrivateKey(this.key, this.passphrase)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			signers = append(signers, signer)
+		case AuthAgent:
+			socket := os.Getenv("SSH_AUTH_SOCK")
+			if socket == "" {
+				continue
+			}
+			conn, err := net.Dial("unix", socket)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			agentSigners, err := agent.NewClient(conn).Signers()
+			if err != nil {
+				conn.Close()
+				errs = append(errs, err)
+				continue
+			}
+			closer = conn
+			signers = append(signers, agentSigners...)
+		case AuthPassword:
+			methods = append(methods, ssh.Password(this.password))
+			continue
+		default:
+			return nil, nil, errors.New("Unsupported authentication method " + string(method))
+		}
+		if publicKeysAt < 0 {
+			publicKeysAt = len(methods)
+			methods = append(methods, nil)
+		}
+	}
+	if publicKeysAt >= 0 {
+		if len(signers) == 0 {
+			methods = append(methods[:publicKeysAt], methods[publicKeysAt+1:]...)
+		} else {
+			methods[publicKeysAt] = ssh.PublicKeys(signers...)
+		}
+	}
+	if len(methods) == 0 {
+		closer.Close()
+		errs = append(errs, errors.New("No authentication methods available for "+this.ip))
+		return nil, nil, errors.Join(errs...)
+	}
+	return methods, closer, nil
+}
+
+// loadPrivateKey reads private key file, decrypting it with passphrase when the key is protected.
+func loadPrivateKey(path, passphrase string) (ssh.Signer, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKey(pem)
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		if passphrase == "" {
+			return nil, errors.New("Private key " + path + " is protected with a passphrase, but no passphrase is given")
+		}
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(pem, []byte(passphrase))
+	}
+	if err != nil {
+		return nil, errors.New("Can't load private key " + path + ": " + err.Error())
+	}
+	return signer, nil
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// KeyPassphrase returns passphrase of the private key file, empty when the key is not protected or can't be read.
+// The passphrase is taken from PassphraseEnv if it is set, otherwise it is prompted for on the terminal.
+func KeyPassphrase(path string) (string, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		// loading the key reports the error
+		return "", nil
+	}
+	var missing *ssh.PassphraseMissingError
+	if _, err = ssh.ParsePrivateKey(pem); !errors.As(err, &missing) {
+		return "", nil
+	}
+	if passphrase, ok := os.LookupEnv(PassphraseEnv); ok {
+		return passphrase, nil
+	}
+	stdin := int(os.Stdin.Fd())
+	if !term.IsTerminal(stdin) {
+		return "", errors.New("Private key " + path + " is protected with a passphrase, set it in " + PassphraseEnv + " environment variable")
+	}
+	fmt.Fprintf(os.Stderr, "Passphrase for %s: ", path)
+	passphrase, err := term.ReadPassword(stdin)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("Can't read passphrase of %s: %w", path, err)
+	}
+	return string(passphrase), nil
+}
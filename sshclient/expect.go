@@ -0,0 +1,215 @@
+package sshclient
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultExpectTimeout limits waiting for a prompt unless the step or the script sets its own timeout.
+const DefaultExpectTimeout = 10 * time.Second
+
+// secretMask replaces secrets in transcripts.
+const secretMask = "********"
+
+// ExpectStep waits for output matching Prompt and answers it with Response followed by a newline.
+// Secret responses are masked in the transcript, as well as their echo in the output.
+type ExpectStep struct {
+	Prompt   *regexp.Regexp
+	Response string
+	Secret   bool
+	Timeout  time.Duration
+}
+
+// ExpectScript is an InteractiveProcess answering prompts of the command in order of steps. Output matching
+// any of Failures fails the script at once; after the last step the script waits for the command to finish
+// its output, Failures are checked there as well. Both stdout and stderr are matched.
+type ExpectScript struct {
+	Cmd        []string
+	Steps      []*ExpectStep
+	Failures   []*regexp.Regexp
+	Timeout    time.Duration
+	transcript strings.Builder
+	secrets    []string
+}
+
+// ExpectError reports a prompt which was not answered, with the transcript of the dialog so far.
+type ExpectError struct {
+	Prompt     string
+	Reason     string
+	Transcript string
+}
+
+func (this *ExpectError) Error() string {
+	msg := this.Reason
+	if this.Prompt != "" {
+		msg = fmt.Sprintf("Waiting for \"%s\": %s", this.Prompt, this.Reason)
+	}
+	return msg + "\n" + this.Transcript
+}
+
+// NewExpectScript creates script for the command, steps and failure patterns are added by Expect and FailOn.
+func NewExpectScript(command ...string) *ExpectScript {
+	return &ExpectScript{Cmd: command}
+}
+
+// Expect adds a step answering prompt matching the regular expression.
+func (this *ExpectScript) Expect(prompt, response string) *ExpectScript {
+	this.Steps = append(this.Steps, &ExpectStep{Prompt: regexp.MustCompile(prompt), Response: response})
+	return this
+}
+
+// ExpectSecret adds a step answering prompt with a response masked in the transcript.
+func (this *ExpectScript) ExpectSecret(prompt, response string) *ExpectScript {
+	this.Steps = append(this.Steps, &ExpectStep{Prompt: regexp.MustCompile(prompt), Response: response, Secret: true})
+	return this
+}
+
+// FailOn adds a pattern of output meaning the command failed.
+func (this *ExpectScript) FailOn(pattern string) *ExpectScript {
+	this.Failures = append(this.Failures, regexp.MustCompile(pattern))
+	return this
+}
+
+func (this *ExpectScript) Command() []string {
+	return this.Cmd
+}
+
+// Transcript returns the dialog with secrets masked.
+func (this *ExpectScript) Transcript() string {
+	return this.mask(this.transcript.String())
+}
+
+func (this *ExpectScript) Execute(stdin io.Writer, stdout io.Reader, stderr io.Reader) error {
+	this.transcript.Reset()
+	this.secrets = nil
+	for _, step := range this.Steps {
+		if step.Secret && step.Response != "" {
+			this.secrets = append(this.secrets, step.Response)
+		}
+	}
+	done := make(chan struct{})
+	defer close(done)
+	output := mergeOutput(done, stdout, stderr)
+	pending := ""
+	for _, step := range this.Steps {
+		var err error
+		pending, err = this.await(output, pending, step.Prompt, this.stepTimeout(step))
+		if err != nil {
+			return err
+		}
+		response := step.Response
+		if step.Secret {
+			response = secretMask
+		}
+		this.transcript.WriteString(response + "\n")
+		if _, err = io.WriteString(stdin, step.Response+"\n"); err != nil {
+			return this.failure("", "can't send response: "+err.Error())
+		}
+	}
+	_, err := this.await(output, pending, nil, this.stepTimeout(nil))
+	return err
+}
+
+// await reads output until it matches the prompt, which is consumed. Nil prompt waits for the end of output.
+// Output following the match is returned to be matched by the next step.
+func (this *ExpectScript) await(output <-chan string, pending string, prompt *regexp.Regexp, timeout time.Duration) (string, error) {
+	expected := ""
+	if prompt != nil {
+		expected = prompt.String()
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		if failure := this.failed(pending); failure != "" {
+			return "", this.failure(expected, "command failed with \""+failure+"\"")
+		}
+		if prompt != nil {
+			if match := prompt.FindStringIndex(pending); match != nil {
+				return pending[match[1]:], nil
+			}
+		}
+		select {
+		case chunk, ok := <-output:
+			if !ok {
+				if prompt == nil {
+					return "", nil
+				}
+				return "", this.failure(expected, "output ended")
+			}
+			this.transcript.WriteString(chunk)
+			pending += chunk
+		case <-timer.C:
+			if prompt == nil {
+				return "", this.failure("", fmt.Sprintf("command did not finish in %s", timeout))
+			}
+			return "", this.failure(expected, fmt.Sprintf("no prompt in %s", timeout))
+		}
+	}
+}
+
+func (this *ExpectScript) failed(output string) string {
+	for _, failure := range this.Failures {
+		if match := failure.FindString(output); match != "" {
+			return this.mask(match)
+		}
+	}
+	return ""
+}
+
+func (this *ExpectScript) failure(prompt, reason string) error {
+	return &ExpectError{Prompt: prompt, Reason: reason, Transcript: this.Transcript()}
+}
+
+func (this *ExpectScript) stepTimeout(step *ExpectStep) time.Duration {
+	switch {
+	case step != nil && step.Timeout > 0:
+		return step.Timeout
+	case this.Timeout > 0:
+		return this.Timeout
+	}
+	return DefaultExpectTimeout
+}
+
+func (this *ExpectScript) mask(text string) string {
+	for _, secret := range this.secrets {
+		text = strings.ReplaceAll(text, secret, secretMask)
+	}
+	return text
+}
+
+// mergeOutput delivers chunks read from readers to a single channel, closed when all readers reach EOF.
+// Readers are drained until done is closed, so the command never blocks on a full window.
+func mergeOutput(done <-chan struct{}, readers ...io.Reader) <-chan string {
+	output := make(chan string)
+	var wg sync.WaitGroup
+	for _, reader := range readers {
+		wg.Add(1)
+		go func(reader io.Reader) {
+			defer wg.Done()
+			buf := make([]byte, 4096)
+			for {
+				n, err := reader.Read(buf)
+				if n > 0 {
+					select {
+					case output <- string(buf[:n]):
+					case <-done:
+						io.Copy(io.Discard, reader)
+						return
+					}
+				}
+				if err != nil {
+					return
+				}
+			}
+		}(reader)
+	}
+	go func() {
+		wg.Wait()
+		close(output)
+	}()
+	return output
+}
@@ -1,36 +1,39 @@
 package sshclient
 
 import (
-	"io"
+	"bytes"
+	"context"
 	"os"
+	"strings"
 )
 
-type installSshKey string
+// AuthorizedKeysDir and AuthorizedKeysFile locate root's authorized keys of dropbear SSH server.
+const AuthorizedKeysDir = "/etc/dropbear"
+const AuthorizedKeysFile = AuthorizedKeysDir + "/authorized_keys"
 
-func (this *installSshKey) Command() []string {
-	return []string{"/usr/bin/tee", "-a", "/root/.sshclient/authorized_keys"}
-}
-
-func (this *installSshKey) Execute(stdin io.Writer, stdout io.Reader, stderr io.Reader) error {
-	file, err := os.OpenFile(string(*this), os.O_RDONLY, 0600)
+// InstallSshKey adds public key from the file to authorized keys of the host, unless it is there already.
+func InstallSshKey(ctx context.Context, client SshClient, publicKeyPath string) error {
+	publicKey, err := os.ReadFile(publicKeyPath)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
-	fileInfo, err := file.Stat()
-	if err != nil {
+	publicKey = bytes.TrimSpace(publicKey)
+	if _, err = client.RunCommands(ctx, []string{"mkdir -p " + AuthorizedKeysDir, "chmod 0700 " + AuthorizedKeysDir}); err != nil {
 		return err
 	}
-	publicKey := make([]byte, fileInfo.Size())
-	_, err = file.Read(publicKey)
+	result, err := client.Run(ctx, "cat "+AuthorizedKeysFile+" 2>/dev/null || true")
 	if err != nil {
 		return err
 	}
-	_, err = stdin.Write(publicKey)
-	return err
-}
-
-func NewInstallSshKey(publicKey string) InteractiveProcess {
-	cmd := installSshKey(publicKey)
-	return &cmd
+	authorizedKeys := result.Stdout
+	for _, line := range strings.Split(authorizedKeys, "\n") {
+		if strings.TrimSpace(line) == string(publicKey) {
+			return nil
+		}
+	}
+	if authorizedKeys != "" && !strings.HasSuffix(authorizedKeys, "\n") {
+		authorizedKeys += "\n"
+	}
+	content := strings.NewReader(authorizedKeys + string(publicKey) + "\n")
+	return client.Upload(ctx, &UploadFile{Path: AuthorizedKeysFile, Content: content, Mode: 0600})
 }
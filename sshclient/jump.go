@@ -0,0 +1,114 @@
+package sshclient
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultPort is the SSH port used when none is set.
+const DefaultPort = 22
+
+// JumpHost is an intermediate SSH server connections are tunnelled through, like OpenSSH ProxyJump.
+// HostKey is the public key of the jump host in authorized_keys format, it must be set.
+type JumpHost struct {
+	Host       string
+	Port       int
+	User       string
+	Key        string
+	Passphrase string
+	HostKey    string
+}
+
+// ParseHostKey parses a public key in authorized_keys format or a known_hosts line, as ssh-keyscan prints it,
+// and returns the key in authorized_keys format JumpHost.HostKey takes.
+func ParseHostKey(text string) (string, error) {
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(text))
+	if err != nil {
+		if _, _, key, _, _, err = ssh.ParseKnownHosts([]byte(text)); err != nil {
+			return "", fmt.Errorf("Invalid host key: %w", err)
+		}
+	}
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))), nil
+}
+
+func (this *JumpHost) address() string {
+	return joinHostPort(this.Host, this.Port)
+}
+
+// clientConfig authenticates on the jump host with its key or ssh-agent, the host key is pinned.
+func (this *JumpHost) clientConfig(timeout time.Duration) (*ssh.ClientConfig, io.Closer, error) {
+	if this.HostKey == "" {
+		return nil, nil, errors.New("Host key of jump host " + this.Host + " is not set")
+	}
+	hostKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(this.HostKey))
+	if err != nil {
+		return nil, nil, fmt.Errorf("Invalid host key of jump host %s: %w", this.Host, err)
+	}
+	hop := &sshClient{ip: this.Host, key: this.Key, passphrase: this.Passphrase, authOrder: []AuthMethod{AuthKey, AuthAgent}}
+	auth, agentConn, err := hop.authMethods()
+	if err != nil {
+		return nil, nil, err
+	}
+	config := &ssh.ClientConfig{
+		User:            this.User,
+		Auth:            auth,
+		HostKeyCallback: ssh.FixedHostKey(hostKey),
+		Timeout:         timeout,
+	}
+	return config, agentConn, nil
+}
+
+// openJumpHosts logs in to jump hosts one by one over conn connected to the first of them, each next hop
+// is reached through a direct-tcpip channel of the previous one. Returns clients of jump hosts and
+// the channel to the target, opened clients are returned on error as well to be closed by the caller.
+func (this *sshClient) openJumpHosts(conn net.Conn, target string) ([]*ssh.Client, net.Conn, error) {
+	hops := make([]*ssh.Client, 0, len(this.jumpHosts))
+	for i, jump := range this.jumpHosts {
+		config, agentConn, err := jump.clientConfig(this.dialTimeout)
+		if err != nil {
+			return hops, nil, err
+		}
+		hop, err := newClient(conn, jump.address(), config)
+		agentConn.Close()
+		if err != nil {
+			return hops, nil, fmt.Errorf("Jump host %s: %w", jump.Host, err)
+		}
+		hops = append(hops, hop)
+		next := target
+		if i+1 < len(this.jumpHosts) {
+			next = this.jumpHosts[i+1].address()
+		}
+		if conn, err = hop.Dial("tcp", next); err != nil {
+			return hops, nil, fmt.Errorf("Jump host %s can't reach %s: %w", jump.Host, next, err)
+		}
+	}
+	return hops, conn, nil
+}
+
+func newClient(conn net.Conn, address string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	clientConn, chans, reqs, err := ssh.NewClientConn(conn, address, config)
+	if err != nil {
+		return nil, err
+	}
+	return ssh.NewClient(clientConn, chans, reqs), nil
+}
+
+// closeClients closes clients in reverse order, so tunnels are closed before connections carrying them.
+func closeClients(clients []*ssh.Client) {
+	for i := len(clients) - 1; i >= 0; i-- {
+		clients[i].Close()
+	}
+}
+
+func joinHostPort(host string, port int) string {
+	if port <= 0 {
+		port = DefaultPort
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
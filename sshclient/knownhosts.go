@@ -0,0 +1,157 @@
+package sshclient
+
+import (
+	"bufio"
+	"errors"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// HostKeyPolicy defines how host keys missing in known hosts store are treated.
+type HostKeyPolicy int
+
+const (
+	// VerifyHostKey accepts only hosts with a known matching key.
+	VerifyHostKey HostKeyPolicy = iota
+	// TrustOnFirstUse records key of an unknown host, but refuses a host which key has changed.
+	TrustOnFirstUse
+	// TrustHostKey records presented key replacing a known one.
+	TrustHostKey
+)
+
+// KnownHosts is a store of SSH host keys in OpenSSH known_hosts file format.
+type KnownHosts struct {
+	path  string
+	mutex sync.Mutex
+}
+
+// UnknownHostError is returned when host key is not in the store and policy does not allow to trust it.
+type UnknownHostError struct {
+	Host        string
+	Fingerprint string
+}
+
+// HostKeyChangedError is returned when host presents a key different from the known one.
+type HostKeyChangedError struct {
+	Host        string
+	Fingerprint string
+}
+
+func (this *UnknownHostError) Error() string {
+	return "Host " + this.Host + " is unknown, its key fingerprint is " + this.Fingerprint
+}
+
+func (this *HostKeyChangedError) Error() string {
+	return "Host key of " + this.Host + " has changed to " + this.Fingerprint + ", possible man-in-the-middle attack"
+}
+
+func NewKnownHosts(path string) *KnownHosts {
+	return &KnownHosts{path: path}
+}
+
+// HostKeyCallback returns callback verifying host keys against the store, the store is updated as policy allows.
+func (this *KnownHosts) HostKeyCallback(policy HostKeyPolicy) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		this.mutex.Lock()
+		defer this.mutex.Unlock()
+		if policy == TrustHostKey {
+			return this.trust(hostname, key)
+		}
+		if err := this.ensureFile(); err != nil {
+			return err
+		}
+		callback, err := knownhosts.New(this.path)
+		if err != nil {
+			return err
+		}
+		err = callback(hostname, remote, key)
+		var keyErr *knownhosts.KeyError
+		if !errors.As(err, &keyErr) {
+			return err
+		}
+		fingerprint := ssh.FingerprintSHA256(key)
+		if len(keyErr.Want) > 0 {
+			return &HostKeyChangedError{Host: knownhosts.Normalize(hostname), Fingerprint: fingerprint}
+		}
+		if policy == TrustOnFirstUse {
+			return this.add(hostname, key)
+		}
+		return &UnknownHostError{Host: knownhosts.Normalize(hostname), Fingerprint: fingerprint}
+	}
+}
+
+// Forget removes all keys of the host from the store.
+func (this *KnownHosts) Forget(hostname string) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.remove(hostname)
+}
+
+func (this *KnownHosts) trust(hostname string, key ssh.PublicKey) error {
+	if err := this.remove(hostname); err != nil {
+		return err
+	}
+	return this.add(hostname, key)
+}
+
+func (this *KnownHosts) add(hostname string, key ssh.PublicKey) error {
+	out, err := os.OpenFile(this.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	_, err = out.WriteString(knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key) + "\n")
+	return err
+}
+
+func (this *KnownHosts) remove(hostname string) error {
+	if err := this.ensureFile(); err != nil {
+		return err
+	}
+	host := knownhosts.Normalize(hostname)
+	in, err := os.Open(this.path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	kept := new(strings.Builder)
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		line := scanner.Text()
+		fields := strings.Fields(line)
+		if len(fields) > 1 && !strings.HasPrefix(fields[0], "#") && !strings.HasPrefix(fields[0], "@") &&
+			containsHost(fields[0], host) {
+			continue
+		}
+		kept.WriteString(line)
+		kept.WriteByte('\n')
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+	return os.WriteFile(this.path, []byte(kept.String()), 0600)
+}
+
+func (this *KnownHosts) ensureFile() error {
+	if _, err := os.Stat(this.path); err == nil || !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(this.path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(this.path, nil, 0600)
+}
+
+func containsHost(hosts, host string) bool {
+	for _, h := range strings.Split(hosts, ",") {
+		if h == host {
+			return true
+		}
+	}
+	return false
+}
@@ -1,42 +1,19 @@
 package sshclient
 
-import (
-	"bufio"
-	"errors"
-	"io"
-)
+import "wnetctl/uci"
 
+// NewPasswd changes password of the user with busybox passwd. Old password is asked only when
+// the password of another user than root is changed, so it may be empty for root.
 func NewPasswd(user, password, newPassword string) InteractiveProcess {
-	return &passwd{user, password, newPassword}
-}
-
-type passwd struct {
-	user        string
-	oldPassword string
-	newPassword string
-}
-
-func (this *passwd) Command() []string {
-	return []string{"/sbin/passwd", this.user}
-}
-
-func (this *passwd) Execute(stdin io.Writer, stdout io.Reader, stderr io.Reader) error {
-	out := bufio.NewReader(stdout)
-
-	prompt, err := out.ReadString('\n')
-	if err != nil {
-		return err
-	}
-	if prompt != "Changing password for "+this.user {
-		return errors.New("Unexpected prompt from passwd")
-	}
-	prompt, err = out.ReadString(':')
-	if err != nil {
-		return err
-	}
-	if prompt != "New password:" {
-		return errors.New("Unexpected prompt from passwd")
+	script := NewExpectScript("passwd", uci.Quote(user))
+	if password != "" {
+		script.ExpectSecret(`(?i)old password:\s*$`, password)
 	}
-	_, err = stdin.Write([]byte(this.newPassword))
-	return err
+	script.ExpectSecret(`(?i)new password:\s*$`, newPassword).
+		ExpectSecret(`(?i)retype password:\s*$`, newPassword).
+		FailOn(`(?i)passwords don't match`).
+		FailOn(`(?i)password for \S+ is unchanged`).
+		FailOn(`(?i)incorrect password`).
+		FailOn(`(?i)unknown user \S+`)
+	return script
 }
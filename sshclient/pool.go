@@ -0,0 +1,220 @@
+package sshclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"sync"
+	"time"
+)
+
+// Dialer creates a new connected client, pool uses it to establish and re-establish connections.
+type Dialer func(ctx context.Context) (SshClient, error)
+
+// Pool caches authenticated connections by key (i.e. access point), so consecutive operations on a host
+// do not repeat the handshake. Clients returned by the pool are shared, their Close does nothing;
+// connections are closed by Forget or Close of the pool.
+type Pool struct {
+	mutex   sync.Mutex
+	clients map[string]*pooledClient
+}
+
+// pooledClient reconnects when its connection turns out to be lost before a command is started.
+type pooledClient struct {
+	mutex  sync.Mutex
+	client SshClient
+	dial   Dialer
+}
+
+func NewPool() *Pool {
+	return &Pool{clients: make(map[string]*pooledClient)}
+}
+
+// Get returns client connected to the host identified by the key, dialing it when there is no connection yet.
+func (this *Pool) Get(ctx context.Context, key string, dial Dialer) (SshClient, error) {
+	this.mutex.Lock()
+	pooled := this.clients[key]
+	if pooled == nil {
+		pooled = &pooledClient{dial: dial}
+		this.clients[key] = pooled
+	}
+	this.mutex.Unlock()
+	if _, err := pooled.connected(ctx); err != nil {
+		return nil, err
+	}
+	return pooled, nil
+}
+
+// Add puts connected client to the pool replacing existing one, dial is used if the connection gets lost.
+func (this *Pool) Add(key string, client SshClient, dial Dialer) SshClient {
+	pooled := &pooledClient{client: client, dial: dial}
+	this.mutex.Lock()
+	previous := this.clients[key]
+	this.clients[key] = pooled
+	this.mutex.Unlock()
+	if previous != nil {
+		previous.close()
+	}
+	return pooled
+}
+
+// Forget closes connection cached by the key, next Get dials the host again.
+func (this *Pool) Forget(key string) error {
+	this.mutex.Lock()
+	pooled := this.clients[key]
+	delete(this.clients, key)
+	this.mutex.Unlock()
+	if pooled == nil {
+		return nil
+	}
+	return pooled.close()
+}
+
+// Close closes all cached connections.
+func (this *Pool) Close() error {
+	this.mutex.Lock()
+	clients := this.clients
+	this.clients = make(map[string]*pooledClient)
+	this.mutex.Unlock()
+	var errs []error
+	for _, pooled := range clients {
+		errs = append(errs, pooled.close())
+	}
+	return errors.Join(errs...)
+}
+
+// connected returns underlying client, dialing again if it is not connected.
+func (this *pooledClient) connected(ctx context.Context) (SshClient, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if this.client != nil && this.client.Connected() {
+		return this.client, nil
+	}
+	if this.client != nil {
+		this.client.Close()
+		this.client = nil
+	}
+	client, err := this.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	this.client = client
+	return client, nil
+}
+
+// do runs the operation, retrying it once on a new connection if the session could not be opened.
+// Operations which have started a command are never retried.
+func (this *pooledClient) do(ctx context.Context, operation func(client SshClient) error) error {
+	client, err := this.connected(ctx)
+	if err != nil {
+		return err
+	}
+	err = operation(client)
+	if errors.Is(err, ErrNoSession) && ctx.Err() == nil {
+		this.mutex.Lock()
+		if this.client == client {
+			this.client.Close()
+			this.client = nil
+		}
+		this.mutex.Unlock()
+		if client, err = this.connected(ctx); err != nil {
+			return err
+		}
+		err = operation(client)
+	}
+	return err
+}
+
+func (this *pooledClient) close() error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if this.client == nil {
+		return nil
+	}
+	err := this.client.Close()
+	this.client = nil
+	return err
+}
+
+// Settings are applied by the dialer, pooled client ignores them.
+func (this *pooledClient) SetKey(keyPath string)                              {}
+func (this *pooledClient) SetPassphrase(passphrase string)                    {}
+func (this *pooledClient) SetAuthOrder(methods ...AuthMethod)                 {}
+func (this *pooledClient) SetHostKeyCallback(callback ssh.HostKeyCallback)    {}
+func (this *pooledClient) SetTimeouts(dial, command, keepAlive time.Duration) {}
+func (this *pooledClient) SetPort(port int)                                   {}
+func (this *pooledClient) SetJumpHosts(jumpHosts ...*JumpHost)                {}
+
+func (this *pooledClient) Connect(ctx context.Context) error {
+	_, err := this.connected(ctx)
+	return err
+}
+
+func (this *pooledClient) Connected() bool {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.client != nil && this.client.Connected()
+}
+
+func (this *pooledClient) Execute(ctx context.Context, command string) error {
+	return this.do(ctx, func(client SshClient) error {
+		return client.Execute(ctx, command)
+	})
+}
+
+func (this *pooledClient) Run(ctx context.Context, command string) (result *CommandResult, err error) {
+	err = this.do(ctx, func(client SshClient) error {
+		result, err = client.Run(ctx, command)
+		return err
+	})
+	return result, err
+}
+
+func (this *pooledClient) RunCommands(ctx context.Context, commands []string) ([]*CommandResult, error) {
+	return runCommands(ctx, this, commands)
+}
+
+func (this *pooledClient) ExecuteScript(ctx context.Context, script *Script) (result *CommandResult, err error) {
+	err = this.do(ctx, func(client SshClient) error {
+		result, err = client.ExecuteScript(ctx, script)
+		return err
+	})
+	return result, err
+}
+
+func (this *pooledClient) ExecuteInteractive(ctx context.Context, process InteractiveProcess) error {
+	return this.do(ctx, func(client SshClient) error {
+		return client.ExecuteInteractive(ctx, process)
+	})
+}
+
+// Upload rewinds the content before each attempt, as the lost session may have read a part of it.
+func (this *pooledClient) Upload(ctx context.Context, file *UploadFile) error {
+	content, ok := file.Content.(io.ReadSeeker)
+	if !ok {
+		data, err := io.ReadAll(file.Content)
+		if err != nil {
+			return err
+		}
+		content = bytes.NewReader(data)
+	}
+	start, err := content.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	upload := *file
+	upload.Content = content
+	return this.do(ctx, func(client SshClient) error {
+		if _, err := content.Seek(start, io.SeekStart); err != nil {
+			return err
+		}
+		return client.Upload(ctx, &upload)
+	})
+}
+
+// Close keeps the connection open for next users of the pool.
+func (this *pooledClient) Close() error {
+	return nil
+}
@@ -0,0 +1,127 @@
+package sshclient
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// scriptShell runs a script passed on its stdin, stopping at the first failed command.
+const scriptShell = "/bin/sh -e"
+
+// lineMarker prefixes number of the script line written to stderr before the line is executed.
+const lineMarker = "@@wnetctl-line:"
+
+// ScriptLine is a command of a script along with position in a source (i.e. template) it was produced from.
+type ScriptLine struct {
+	Command    string
+	Source     string
+	SourceLine int
+}
+
+// Script is a sequence of shell commands executed in a single SSH session.
+type Script struct {
+	Lines []*ScriptLine
+}
+
+// ScriptError reports a script line which failed, Line is 1-based number of the line in the script.
+type ScriptError struct {
+	Line       int
+	Command    string
+	Source     string
+	SourceLine int
+	ExitCode   int
+	Stderr     string
+}
+
+func NewScript() *Script {
+	return &Script{Lines: make([]*ScriptLine, 0)}
+}
+
+// Add appends a command produced from the line of the source. Blank commands are skipped.
+func (this *Script) Add(command, source string, sourceLine int) {
+	if command = strings.TrimSpace(command); command != "" {
+		this.Lines = append(this.Lines, &ScriptLine{Command: command, Source: source, SourceLine: sourceLine})
+	}
+}
+
+// Append adds all lines of other script to the end of this one.
+func (this *Script) Append(other *Script) {
+	if other != nil {
+		this.Lines = append(this.Lines, other.Lines...)
+	}
+}
+
+func (this *Script) Empty() bool {
+	return len(this.Lines) == 0
+}
+
+func (this *Script) String() string {
+	sb := new(strings.Builder)
+	for _, line := range this.Lines {
+		sb.WriteString(line.Command)
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+func (this *ScriptError) Error() string {
+	msg := fmt.Sprintf("Script line %d \"%s\"", this.Line, this.Command)
+	if this.Source != "" {
+		msg += fmt.Sprintf(" (%s:%d)", this.Source, this.SourceLine)
+	}
+	msg += fmt.Sprintf(" failed with exit code %d", this.ExitCode)
+	if stderr := strings.TrimSpace(this.Stderr); stderr != "" {
+		msg += ": " + stderr
+	}
+	return msg
+}
+
+// ExecuteScript streams the script to a shell in a single session. Each line is preceded with a marker written
+// to stderr, so the last marker tells which line failed. Markers are removed from the result.
+func (this *sshClient) ExecuteScript(ctx context.Context, script *Script) (*CommandResult, error) {
+	stdin := new(strings.Builder)
+	for i, line := range script.Lines {
+		fmt.Fprintf(stdin, "echo '%s%d' >&2\n%s\n", lineMarker, i+1, line.Command)
+	}
+	result, err := this.run(ctx, scriptShell, strings.NewReader(stdin.String()))
+	if result == nil {
+		return nil, err
+	}
+	lineNo, errOutput, lineOutput := parseScriptStderr(result.Stderr)
+	result.Stderr = errOutput
+	exitCode, ok := exitStatus(err)
+	if !ok {
+		return result, err
+	}
+	result.ExitCode = exitCode
+	scriptErr := &ScriptError{Line: lineNo, ExitCode: exitCode, Stderr: lineOutput}
+	if lineNo > 0 && lineNo <= len(script.Lines) {
+		line := script.Lines[lineNo-1]
+		scriptErr.Command = line.Command
+		scriptErr.Source = line.Source
+		scriptErr.SourceLine = line.SourceLine
+	}
+	return result, scriptErr
+}
+
+// parseScriptStderr returns number of the last started script line, stderr output without line markers
+// and output of the last started line only.
+func parseScriptStderr(stderr string) (int, string, string) {
+	lineNo := 0
+	output := new(strings.Builder)
+	lineOutput := new(strings.Builder)
+	for _, line := range strings.SplitAfter(stderr, "\n") {
+		if marker, found := strings.CutPrefix(strings.TrimRight(line, "\n"), lineMarker); found {
+			if n, err := strconv.Atoi(marker); err == nil {
+				lineNo = n
+				lineOutput.Reset()
+				continue
+			}
+		}
+		output.WriteString(line)
+		lineOutput.WriteString(line)
+	}
+	return lineNo, output.String(), lineOutput.String()
+}
@@ -1,18 +1,33 @@
 package sshclient
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"golang.org/x/crypto/ssh"
 	"io"
-	"os"
+	"net"
 	"strings"
+	"time"
 )
 
 type SshClient interface {
 	SetKey(keyPath string)
-	Connect() error
-	Execute(command string) error
-	ExecuteInteractive(process InteractiveProcess) error
+	SetPassphrase(passphrase string)
+	SetAuthOrder(methods ...AuthMethod)
+	SetHostKeyCallback(callback ssh.HostKeyCallback)
+	SetTimeouts(dial, command, keepAlive time.Duration)
+	SetPort(port int)
+	SetJumpHosts(jumpHosts ...*JumpHost)
+	Connect(ctx context.Context) error
+	Connected() bool
+	Execute(ctx context.Context, command string) error
+	Run(ctx context.Context, command string) (*CommandResult, error)
+	RunCommands(ctx context.Context, commands []string) ([]*CommandResult, error)
+	ExecuteScript(ctx context.Context, script *Script) (*CommandResult, error)
+	ExecuteInteractive(ctx context.Context, process InteractiveProcess) error
+	Upload(ctx context.Context, file *UploadFile) error
 	Close() error
 }
 
@@ -23,13 +38,46 @@ type InteractiveProcess interface {
 }
 
 type sshClient struct {
-	ip       string
-	username string
-	password string
-	key      string
-	client   *ssh.Client
+	ip          string
+	username    string
+	password    string
+	key         string
+	passphrase  string
+	authOrder   []AuthMethod
+	hostKey     ssh.HostKeyCallback
+	dialTimeout time.Duration
+	command     time.Duration
+	keepAlive   time.Duration
+	port        int
+	jumpHosts   []*JumpHost
+	client      *ssh.Client
+	hops        []*ssh.Client
+	done        chan struct{}
+	lost        chan struct{}
 }
 
+// ErrNoSession reports failure to open a session, no command was started on the host then.
+var ErrNoSession = errors.New("Failed to open SSH session")
+
+// DefaultDialTimeout limits time to establish TCP connection and complete SSH handshake.
+const DefaultDialTimeout = 15 * time.Second
+
+// DefaultCommandTimeout limits time of a single command, script or interactive process.
+const DefaultCommandTimeout = 60 * time.Second
+
+// DefaultKeepAliveInterval is a period of keepalive requests detecting a dead connection.
+const DefaultKeepAliveInterval = 15 * time.Second
+
+// CommandResult holds output and exit status of a command executed on a host.
+type CommandResult struct {
+	Command  string
+	Stdout   string
+	Stderr   string
+	ExitCode int
+}
+
+// CommandsExecutionError reports a command which exited with non-zero status, Index is its position in a sequence
+// of executed commands. ExitCode is -1 when command was terminated without reporting exit status.
 type CommandsExecutionError struct {
 	Index    int
 	Command  string
@@ -38,7 +86,11 @@ type CommandsExecutionError struct {
 }
 
 func (this *CommandsExecutionError) Error() string {
-	return fmt.Sprintf("Command \"%s\" execution failed with exit code %d", this.Command, this.ExitCode)
+	msg := fmt.Sprintf("Command #%d \"%s\" execution failed with exit code %d", this.Index+1, this.Command, this.ExitCode)
+	if stderr := strings.TrimSpace(this.Stderr); stderr != "" {
+		msg += ": " + stderr
+	}
+	return msg
 }
 
 func NewCommandExecutionError(command string, exitCode int, stderr string) error {
@@ -49,66 +101,305 @@ func (this *sshClient) SetKey(keyPath string) {
 	this.key = keyPath
 }
 
-func (this *sshClient) Connect() error {
-	var hostkey ssh.PublicKey
+func (this *sshClient) SetPassphrase(passphrase string) {
+	this.passphrase = passphrase
+}
+
+func (this *sshClient) SetAuthOrder(methods ...AuthMethod) {
+	this.authOrder = methods
+}
+
+func (this *sshClient) SetHostKeyCallback(callback ssh.HostKeyCallback) {
+	this.hostKey = callback
+}
+
+// SetTimeouts sets dial timeout, command timeout and keepalive interval, zero value disables respective limit.
+func (this *sshClient) SetTimeouts(dial, command, keepAlive time.Duration) {
+	this.dialTimeout = dial
+	this.command = command
+	this.keepAlive = keepAlive
+}
+
+// SetPort sets SSH port of the host, zero means DefaultPort.
+func (this *sshClient) SetPort(port int) {
+	this.port = port
+}
+
+// SetJumpHosts sets jump hosts connection is tunnelled through, in order of hops.
+func (this *sshClient) SetJumpHosts(jumpHosts ...*JumpHost) {
+	this.jumpHosts = jumpHosts
+}
+
+// Connect dials the host and authenticates. Dial and handshake are limited by the dial timeout and ctx.
+func (this *sshClient) Connect(ctx context.Context) error {
+	if this.hostKey == nil {
+		return errors.New("Host key verification is not configured for " + this.ip)
+	}
+	auth, agentConn, err := this.authMethods()
+	if err != nil {
+		return err
+	}
+	defer agentConn.Close()
 	config := &ssh.ClientConfig{
-		User: this.username,
-		Auth: []ssh.AuthMethod{
-			ssh.Password(this.password),
-		},
-		HostKeyCallback: ssh.FixedHostKey(hostkey),
+		User:            this.username,
+		Auth:            auth,
+		HostKeyCallback: this.hostKey,
+		Timeout:         this.dialTimeout,
+	}
+	ctx, cancel := withTimeout(ctx, this.dialTimeout)
+	defer cancel()
+	address := joinHostPort(this.ip, this.port)
+	first := address
+	if len(this.jumpHosts) > 0 {
+		first = this.jumpHosts[0].address()
+	}
+	dialer := new(net.Dialer)
+	conn, err := dialer.DialContext(ctx, "tcp", first)
+	if err != nil {
+		return err
+	}
+	// handshakes do not accept a context, closing the first connection interrupts all of them
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+	hops, target, err := this.openJumpHosts(conn, address)
+	var client *ssh.Client
+	if err == nil {
+		client, err = newClient(target, address, config)
+	}
+	if !stop() {
+		if client != nil {
+			client.Close()
+		}
+		closeClients(hops)
+		return ctx.Err()
 	}
-	client, err := ssh.Dial("tcp", this.ip+":22", config)
 	if err != nil {
+		closeClients(hops)
+		conn.Close()
 		return err
 	}
 	this.client = client
+	this.hops = hops
+	this.done = make(chan struct{})
+	this.lost = make(chan struct{})
+	go func(client *ssh.Client, lost chan struct{}) {
+		client.Wait()
+		close(lost)
+	}(this.client, this.lost)
+	if this.keepAlive > 0 {
+		go this.keepConnectionAlive(this.client, this.done)
+	}
 	return nil
 }
 
-func (this *sshClient) Execute(command string) error {
+// keepConnectionAlive sends keepalive requests and closes the connection when host stops responding to them,
+// so commands waiting for a dead host fail instead of hanging.
+func (this *sshClient) keepConnectionAlive(client *ssh.Client, done chan struct{}) {
+	ticker := time.NewTicker(this.keepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			reply := make(chan error, 1)
+			go func() {
+				_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+				reply <- err
+			}()
+			select {
+			case err := <-reply:
+				if err != nil {
+					client.Close()
+					return
+				}
+			case <-time.After(this.keepAlive):
+				client.Close()
+				return
+			case <-done:
+				return
+			}
+		}
+	}
+}
+
+// Connected tells whether connection is established and was not lost since.
+func (this *sshClient) Connected() bool {
+	if this.client == nil {
+		return false
+	}
+	select {
+	case <-this.lost:
+		return false
+	default:
+		return true
+	}
+}
+
+func (this *sshClient) Execute(ctx context.Context, command string) error {
+	_, err := this.Run(ctx, command)
+	return err
+}
+
+// Run executes command and collects its output. Non-zero exit status is reported as *CommandsExecutionError
+// along with the result, other errors mean the command status is unknown. Command is killed when ctx is done.
+func (this *sshClient) Run(ctx context.Context, command string) (*CommandResult, error) {
+	result, err := this.run(ctx, command, nil)
+	if result == nil || err == nil {
+		return result, err
+	}
+	if exitCode, ok := exitStatus(err); ok {
+		result.ExitCode = exitCode
+		return result, &CommandsExecutionError{Command: command, ExitCode: exitCode, Stderr: result.Stderr}
+	}
+	return result, err
+}
+
+// run executes command with given stdin in a new session, waiting for its completion or ctx to be done.
+func (this *sshClient) run(ctx context.Context, command string, stdin io.Reader) (*CommandResult, error) {
+	if this.client == nil {
+		return nil, errors.New("Not connected to " + this.ip)
+	}
 	session, err := this.client.NewSession()
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("%w: %w", ErrNoSession, err)
 	}
 	defer session.Close()
-	return session.Run(command)
+	ctx, cancel := withTimeout(ctx, this.command)
+	defer cancel()
+	stdout := new(bytes.Buffer)
+	stderr := new(bytes.Buffer)
+	session.Stdin = stdin
+	session.Stdout = stdout
+	session.Stderr = stderr
+	if err = session.Start(command); err != nil {
+		return nil, err
+	}
+	err = waitSession(ctx, session)
+	return &CommandResult{Command: command, Stdout: stdout.String(), Stderr: stderr.String()}, err
 }
 
-func (this *sshClient) ExecuteInteractive(process InteractiveProcess) error {
-	session, err := this.client.NewSession()
-	if err != nil {
+// withTimeout derives context limited by timeout, zero timeout means no limit.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// waitSession waits for the remote command to complete. When ctx is done first the command is killed
+// and the session closed, ctx error is returned.
+func waitSession(ctx context.Context, session *ssh.Session) error {
+	finished := make(chan error, 1)
+	go func() {
+		finished <- session.Wait()
+	}()
+	select {
+	case err := <-finished:
 		return err
+	case <-ctx.Done():
+		session.Signal(ssh.SIGKILL)
+		session.Close()
+		return ctx.Err()
 	}
-	defer session.Close()
-	pipes := make([]*os.File, 6)
-	for i := 0; i < 3; i++ {
-		pipes[i*2], pipes[i*2+1], err = os.Pipe()
+}
+
+// exitStatus tells exit code of a command which completed with error, -1 if it exited without a status.
+func exitStatus(err error) (int, bool) {
+	var exitErr *ssh.ExitError
+	var missingErr *ssh.ExitMissingError
+	switch {
+	case errors.As(err, &exitErr):
+		return exitErr.ExitStatus(), true
+	case errors.As(err, &missingErr):
+		return -1, true
+	}
+	return 0, false
+}
+
+// RunCommands executes commands one by one until the first failure. Results of executed commands are returned
+// in any case, *CommandsExecutionError of the failed command has its index set.
+func (this *sshClient) RunCommands(ctx context.Context, commands []string) ([]*CommandResult, error) {
+	return runCommands(ctx, this, commands)
+}
+
+func runCommands(ctx context.Context, client SshClient, commands []string) ([]*CommandResult, error) {
+	results := make([]*CommandResult, 0, len(commands))
+	for i, command := range commands {
+		result, err := client.Run(ctx, command)
+		if result != nil {
+			results = append(results, result)
+		}
 		if err != nil {
-			return err
+			var execErr *CommandsExecutionError
+			if errors.As(err, &execErr) {
+				execErr.Index = i
+			}
+			return results, err
 		}
-		defer pipes[i*2].Close()
-		defer pipes[i*2+1].Close()
 	}
-	session.Stdin = pipes[0]
-	session.Stdout = pipes[3]
-	session.Stderr = pipes[5]
-	err = session.Start(strings.Join(process.Command(), " "))
+	return results, nil
+}
+
+// ExecuteInteractive starts process command and lets the process talk to it. The session is closed
+// when ctx is done, so the process gets EOF reading output of the command.
+func (this *sshClient) ExecuteInteractive(ctx context.Context, process InteractiveProcess) error {
+	if this.client == nil {
+		return errors.New("Not connected to " + this.ip)
+	}
+	session, err := this.client.NewSession()
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrNoSession, err)
+	}
+	defer session.Close()
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := session.StderrPipe()
 	if err != nil {
 		return err
 	}
-	err = process.Execute(pipes[1], pipes[2], pipes[4])
+	if err = session.Start(strings.Join(process.Command(), " ")); err != nil {
+		return err
+	}
+	ctx, cancel := withTimeout(ctx, this.command)
+	defer cancel()
+	stop := context.AfterFunc(ctx, func() {
+		session.Signal(ssh.SIGKILL)
+		session.Close()
+	})
+	defer stop()
+	err = process.Execute(stdin, stdout, stderr)
+	stdin.Close()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
 	if err != nil {
 		return err
 	}
-	return session.Wait()
+	return waitSession(ctx, session)
 }
 
 func (this *sshClient) Close() error {
-	return this.client.Close()
+	if this.client == nil {
+		return nil
+	}
+	close(this.done)
+	err := this.client.Close()
+	closeClients(this.hops)
+	this.client = nil
+	this.hops = nil
+	return err
 }
 
 func NewSshClient(ip, login, password, sshKey string) SshClient {
-	client := &sshClient{ip: ip, username: login, password: password, key: sshKey}
+	client := &sshClient{ip: ip, username: login, password: password, key: sshKey,
+		dialTimeout: DefaultDialTimeout, command: DefaultCommandTimeout, keepAlive: DefaultKeepAliveInterval}
 	return client
 }
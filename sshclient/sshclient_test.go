@@ -0,0 +1,339 @@
+package sshclient
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+	"wnetctl/sshclient/sshtest"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestServer(t *testing.T) *sshtest.Server {
+	t.Helper()
+	server, err := sshtest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		server.Close()
+	})
+	return server
+}
+
+func newTestClient(server *sshtest.Server) SshClient {
+	client := NewSshClient(server.Host(), "root", "", "")
+	client.SetPort(server.Port())
+	client.SetAuthOrder(AuthPassword)
+	client.SetHostKeyCallback(ssh.FixedHostKey(server.HostKey()))
+	return client
+}
+
+func connectTestClient(t *testing.T, server *sshtest.Server) SshClient {
+	t.Helper()
+	return connectTestClientWith(t, server, DefaultDialTimeout, DefaultCommandTimeout, DefaultKeepAliveInterval)
+}
+
+// connectTestClientWith connects a client with given dial and command timeouts and keepalive interval.
+func connectTestClientWith(t *testing.T, server *sshtest.Server, dial, command, keepAlive time.Duration) SshClient {
+	t.Helper()
+	client := newTestClient(server)
+	client.SetTimeouts(dial, command, keepAlive)
+	if err := client.Connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		client.Close()
+	})
+	return client
+}
+
+func uploadedByCat(server *sshtest.Server) bool {
+	for _, command := range server.Commands() {
+		// redirections are not recorded
+		if command == "cat" {
+			return true
+		}
+	}
+	return false
+}
+
+func TestUploadSetsContentAndMode(t *testing.T) {
+	for _, withSftp := range []bool{true, false} {
+		server := newTestServer(t)
+		server.SetSftp(withSftp)
+		client := connectTestClient(t, server)
+		content := "config 'it''s'\n"
+		file := &UploadFile{Path: "/etc/config/test", Content: strings.NewReader(content), Mode: 0600}
+		if err := client.Upload(context.Background(), file); err != nil {
+			t.Fatalf("sftp %t: %v", withSftp, err)
+		}
+		if data, ok := server.ReadFile(file.Path); !ok || string(data) != content {
+			t.Errorf("sftp %t: uploaded content is %q", withSftp, data)
+		}
+		if mode, ok := server.FileMode(file.Path); !ok || mode != 0600 {
+			t.Errorf("sftp %t: mode of uploaded file is %04o", withSftp, mode)
+		}
+		if _, ok := server.ReadFile(file.Path + uploadSuffix); ok {
+			t.Errorf("sftp %t: temporary file is left", withSftp)
+		}
+		if uploadedByCat(server) == withSftp {
+			t.Errorf("sftp %t: commands are %q", withSftp, server.Commands())
+		}
+	}
+}
+
+func TestUploadChecksumMismatchKeepsTarget(t *testing.T) {
+	for _, withSftp := range []bool{true, false} {
+		server := newTestServer(t)
+		server.SetSftp(withSftp)
+		if err := server.WriteFile("/etc/config/test", []byte("old")); err != nil {
+			t.Fatal(err)
+		}
+		server.Handle("^sha256sum ", sshtest.Response{Stdout: "bad  /etc/config/test" + uploadSuffix + "\n"})
+		client := connectTestClient(t, server)
+		err := client.Upload(context.Background(), &UploadFile{Path: "/etc/config/test", Content: strings.NewReader("new")})
+		var checksumErr *ChecksumError
+		if !errors.As(err, &checksumErr) || checksumErr.Actual != "bad" {
+			t.Fatalf("sftp %t: expected checksum error, got %v", withSftp, err)
+		}
+		if data, _ := server.ReadFile("/etc/config/test"); string(data) != "old" {
+			t.Errorf("sftp %t: target is %q", withSftp, data)
+		}
+		if _, ok := server.ReadFile("/etc/config/test" + uploadSuffix); ok {
+			t.Errorf("sftp %t: temporary file is left", withSftp)
+		}
+	}
+}
+
+func TestCommandTimeoutStopsHungCommand(t *testing.T) {
+	server := newTestServer(t)
+	client := connectTestClientWith(t, server, DefaultDialTimeout, 100*time.Millisecond, 0)
+	hung := make(chan struct{})
+	t.Cleanup(func() {
+		close(hung)
+	})
+	server.HandleFunc(`^opkg update$`, func(server *sshtest.Server, exec *sshtest.Exec) int {
+		<-hung
+		return 0
+	})
+	started := time.Now()
+	if err := client.Execute(context.Background(), "opkg update"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected command timeout, got %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("timeout took %s", elapsed)
+	}
+}
+
+func TestKeepAliveDetectsStalledConnection(t *testing.T) {
+	server := newTestServer(t)
+	client := connectTestClientWith(t, server, DefaultDialTimeout, DefaultCommandTimeout, 50*time.Millisecond)
+	if err := client.Execute(context.Background(), "true"); err != nil {
+		t.Fatal(err)
+	}
+	server.Stall()
+	for deadline := time.Now().Add(5 * time.Second); client.Connected(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("stalled connection is not detected")
+		}
+	}
+}
+
+func TestDialTimeoutStopsHandshake(t *testing.T) {
+	// a host accepting connections without answering the handshake
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			accepted <- conn
+		}
+	}()
+	client := newTestClient(newTestServer(t))
+	client.SetPort(listener.Addr().(*net.TCPAddr).Port)
+	client.SetTimeouts(100*time.Millisecond, DefaultCommandTimeout, 0)
+	started := time.Now()
+	if err := client.Connect(context.Background()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected dial timeout, got %v", err)
+	}
+	select {
+	case conn := <-accepted:
+		conn.Close()
+	default:
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("dial took %s", elapsed)
+	}
+}
+
+func TestScriptErrorMapsFailedLineToSource(t *testing.T) {
+	server := newTestServer(t)
+	server.Handle(`^uci commit wireless$`, sshtest.Response{Stderr: "uci: I/O error\n", ExitCode: 3})
+	client := connectTestClient(t, server)
+	script := NewScript()
+	script.Add("uci set wireless.radio0.country=US", "radio.sh", 4)
+	script.Add("  ", "radio.sh", 5)
+	script.Add("uci commit wireless", "radio.sh", 6)
+	script.Add("wifi reload", "radio.sh", 7)
+
+	result, err := client.ExecuteScript(context.Background(), script)
+	var scriptErr *ScriptError
+	if !errors.As(err, &scriptErr) {
+		t.Fatalf("expected script error, got %v", err)
+	}
+	if scriptErr.Line != 2 || scriptErr.Command != "uci commit wireless" || scriptErr.Source != "radio.sh" ||
+		scriptErr.SourceLine != 6 || scriptErr.ExitCode != 3 || scriptErr.Stderr != "uci: I/O error\n" {
+		t.Errorf("script error is %+v", scriptErr)
+	}
+	if result == nil || strings.Contains(result.Stderr, lineMarker) || result.Stderr != "uci: I/O error\n" {
+		t.Errorf("result is %+v", result)
+	}
+	// all lines run in one session which stops at the failed line
+	if slices.Contains(server.Commands(), "wifi reload") {
+		t.Errorf("commands are %q", server.Commands())
+	}
+}
+
+// sessionLostClient reads the uploaded content and fails as the connection lost meanwhile.
+type sessionLostClient struct {
+	SshClient
+}
+
+func (this *sessionLostClient) Upload(ctx context.Context, file *UploadFile) error {
+	io.ReadAll(file.Content)
+	return fmt.Errorf("%w: connection lost", ErrNoSession)
+}
+
+func TestPooledUploadRetriesOnLostSession(t *testing.T) {
+	content := "ssh-ed25519 AAAA admin\n"
+	for _, seekable := range []bool{true, false} {
+		server := newTestServer(t)
+		dials := 0
+		pool := NewPool()
+		defer pool.Close()
+		client, err := pool.Get(context.Background(), "ap1", func(ctx context.Context) (SshClient, error) {
+			dials++
+			if dials == 1 {
+				return &sessionLostClient{connectTestClient(t, server)}, nil
+			}
+			return connectTestClient(t, server), nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var reader io.Reader = strings.NewReader(content)
+		if !seekable {
+			reader = bufio.NewReader(reader)
+		}
+		if err := client.Upload(context.Background(), &UploadFile{Path: "/etc/config/test", Content: reader}); err != nil {
+			t.Fatalf("seekable %t: %v", seekable, err)
+		}
+		if data, _ := server.ReadFile("/etc/config/test"); string(data) != content || dials != 2 {
+			t.Errorf("seekable %t: uploaded content is %q after %d dials", seekable, data, dials)
+		}
+	}
+}
+
+// runExpect executes the script against dialog acting as the command, it gets responses by lines.
+// Stdin is closed when the script finishes, so the dialog waiting for a response returns.
+func runExpect(script *ExpectScript, dialog func(stdin *bufio.Reader, stdout io.Writer)) error {
+	stdinReader, stdinWriter := io.Pipe()
+	stdoutReader, stdoutWriter := io.Pipe()
+	go func() {
+		dialog(bufio.NewReader(stdinReader), stdoutWriter)
+		stdoutWriter.Close()
+	}()
+	err := script.Execute(stdinWriter, stdoutReader, strings.NewReader(""))
+	stdinReader.Close()
+	return err
+}
+
+func TestExpectAnswersPromptsAndMasksSecrets(t *testing.T) {
+	responses := make(chan string, 3)
+	script := NewExpectScript("passwd").
+		Expect("(?i)user: ", "root").
+		ExpectSecret("New password: ", "s3cret").
+		ExpectSecret("Retype password: ", "s3cret")
+	err := runExpect(script, func(stdin *bufio.Reader, stdout io.Writer) {
+		for _, prompt := range []string{"User: ", "New password: ", "Retype password: "} {
+			io.WriteString(stdout, prompt)
+			line, err := stdin.ReadString('\n')
+			if err != nil {
+				return
+			}
+			responses <- strings.TrimSuffix(line, "\n")
+			// a terminal echoes the response
+			io.WriteString(stdout, line)
+		}
+		io.WriteString(stdout, "passwd: password for root changed\n")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	close(responses)
+	var got []string
+	for response := range responses {
+		got = append(got, response)
+	}
+	if strings.Join(got, ",") != "root,s3cret,s3cret" {
+		t.Errorf("responses are %q", got)
+	}
+	transcript := script.Transcript()
+	if strings.Contains(transcript, "s3cret") || !strings.Contains(transcript, "New password: "+secretMask) ||
+		!strings.Contains(transcript, "User: root") || !strings.Contains(transcript, "password for root changed") {
+		t.Errorf("transcript is %q", transcript)
+	}
+}
+
+func TestExpectTimesOutWaitingForPrompt(t *testing.T) {
+	script := NewExpectScript("passwd").ExpectSecret("New password: ", "s3cret")
+	script.Timeout = 50 * time.Millisecond
+	started := time.Now()
+	err := runExpect(script, func(stdin *bufio.Reader, stdout io.Writer) {
+		io.WriteString(stdout, "Changing password for root\n")
+		stdin.ReadString('\n')
+	})
+	var expectErr *ExpectError
+	if !errors.As(err, &expectErr) || expectErr.Prompt != "New password: " || !strings.HasPrefix(expectErr.Reason, "no prompt in") {
+		t.Fatalf("expected prompt timeout, got %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("timeout took %s", elapsed)
+	}
+	if !strings.Contains(expectErr.Transcript, "Changing password for root") {
+		t.Errorf("transcript is %q", expectErr.Transcript)
+	}
+}
+
+func TestExpectFailsOnPatternWithSecretsMasked(t *testing.T) {
+	script := NewExpectScript("passwd").
+		ExpectSecret("New password: ", "s3cret").
+		ExpectSecret("Retype password: ", "s3cret").
+		FailOn("Bad password: .*")
+	err := runExpect(script, func(stdin *bufio.Reader, stdout io.Writer) {
+		io.WriteString(stdout, "New password: ")
+		if _, err := stdin.ReadString('\n'); err != nil {
+			return
+		}
+		io.WriteString(stdout, "Bad password: s3cret is too short\nRetype password: ")
+		stdin.ReadString('\n')
+	})
+	var expectErr *ExpectError
+	if !errors.As(err, &expectErr) || expectErr.Prompt != "Retype password: " || !strings.Contains(expectErr.Reason, "Bad password: "+secretMask) {
+		t.Fatalf("expected failure on bad password, got %v", err)
+	}
+	if strings.Contains(err.Error(), "s3cret") || strings.Contains(script.Transcript(), "s3cret") {
+		t.Errorf("secret is not masked in %q", err.Error())
+	}
+}
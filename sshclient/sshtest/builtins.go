@@ -0,0 +1,441 @@
+package sshtest
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// builtins emulate busybox and OpenWrt commands wnetctl runs. Each one runs with the server lock held,
+// except passwd which waits for the client.
+var builtins map[string]HandlerFunc
+
+func init() {
+	builtins = map[string]HandlerFunc{
+		"true":              func(*Server, *Exec) int { return 0 },
+		"false":             func(*Server, *Exec) int { return 1 },
+		"sleep":             func(*Server, *Exec) int { return 0 },
+		"echo":              echo,
+		"[":                 test,
+		"test":              test,
+		"cat":               cat,
+		"tee":               tee,
+		"rm":                rm,
+		"mv":                mv,
+		"mkdir":             mkdir,
+		"chmod":             chmod,
+		"chown":             func(*Server, *Exec) int { return 0 },
+		"sha256sum":         sha256sum,
+		"uci":               uci,
+		"passwd":            passwd,
+		"tar":               tar,
+		"start-stop-daemon": startStopDaemon,
+		"network":           network,
+		"reload_config":     func(*Server, *Exec) int { return 0 },
+		"ubus":              ubus,
+		"hostapd":           hostapd,
+		"opkg":              opkg,
+		"usteer":            initScript,
+		"dawn":              initScript,
+	}
+}
+
+func echo(server *Server, exec *Exec) int {
+	args := exec.Args[1:]
+	newline := "\n"
+	if len(args) > 0 && args[0] == "-n" {
+		args = args[1:]
+		newline = ""
+	}
+	io.WriteString(exec.Stdout, strings.Join(args, " ")+newline)
+	return 0
+}
+
+func test(server *Server, exec *Exec) int {
+	args := exec.Args[1:]
+	if exec.Args[0] == "[" {
+		if len(args) == 0 || args[len(args)-1] != "]" {
+			fmt.Fprintln(exec.Stderr, "sh: [: missing ]")
+			return 2
+		}
+		args = args[:len(args)-1]
+	}
+	negate := len(args) > 0 && args[0] == "!"
+	if negate {
+		args = args[1:]
+	}
+	result := false
+	switch {
+	case len(args) == 1:
+		result = args[0] != ""
+	case len(args) == 2 && args[0] == "-z":
+		result = args[1] == ""
+	case len(args) == 2 && args[0] == "-n":
+		result = args[1] != ""
+	case len(args) == 2 && args[0] == "-d":
+		result = server.fs.isDir(args[1])
+	case len(args) == 2 && args[0] == "-f":
+		result = server.fs.files[args[1]] != nil
+	case len(args) == 2 && args[0] == "-e":
+		result = server.fs.files[args[1]] != nil || server.fs.isDir(args[1])
+	case len(args) == 3 && args[1] == "=":
+		result = args[0] == args[2]
+	case len(args) == 3 && args[1] == "!=":
+		result = args[0] != args[2]
+	default:
+		fmt.Fprintln(exec.Stderr, "sh: test: syntax error")
+		return 2
+	}
+	if result != negate {
+		return 0
+	}
+	return 1
+}
+
+func cat(server *Server, exec *Exec) int {
+	if len(exec.Args) == 1 {
+		io.Copy(exec.Stdout, exec.Stdin)
+		return 0
+	}
+	status := 0
+	for _, name := range exec.Args[1:] {
+		file := server.fs.files[name]
+		if file == nil {
+			fmt.Fprintf(exec.Stderr, "cat: can't open '%s': No such file or directory\n", name)
+			status = 1
+			continue
+		}
+		exec.Stdout.Write(file.data)
+	}
+	return status
+}
+
+func tee(server *Server, exec *Exec) int {
+	args, flags := splitFlags(exec.Args[1:])
+	data, _ := io.ReadAll(exec.Stdin)
+	exec.Stdout.Write(data)
+	for _, name := range args {
+		if err := server.fs.write(name, data, strings.Contains(flags, "a")); err != nil {
+			fmt.Fprintf(exec.Stderr, "tee: %s: %s\n", name, err.Error())
+			return 1
+		}
+	}
+	return 0
+}
+
+func rm(server *Server, exec *Exec) int {
+	args, flags := splitFlags(exec.Args[1:])
+	status := 0
+	for _, name := range args {
+		if server.fs.files[name] == nil {
+			if !strings.Contains(flags, "f") {
+				fmt.Fprintf(exec.Stderr, "rm: can't remove '%s': No such file or directory\n", name)
+				status = 1
+			}
+			continue
+		}
+		delete(server.fs.files, name)
+	}
+	return status
+}
+
+func mv(server *Server, exec *Exec) int {
+	args, _ := splitFlags(exec.Args[1:])
+	if len(args) != 2 {
+		fmt.Fprintln(exec.Stderr, "Usage: mv [-fin] SOURCE DEST")
+		return 1
+	}
+	file := server.fs.files[args[0]]
+	if file == nil {
+		fmt.Fprintf(exec.Stderr, "mv: can't rename '%s': No such file or directory\n", args[0])
+		return 1
+	}
+	if !server.fs.isDir(path.Dir(args[1])) {
+		fmt.Fprintf(exec.Stderr, "mv: can't rename '%s': No such file or directory\n", args[0])
+		return 1
+	}
+	delete(server.fs.files, args[0])
+	server.fs.files[args[1]] = file
+	return 0
+}
+
+func mkdir(server *Server, exec *Exec) int {
+	args, flags := splitFlags(exec.Args[1:])
+	for _, name := range args {
+		if server.fs.isDir(name) {
+			if !strings.Contains(flags, "p") {
+				fmt.Fprintf(exec.Stderr, "mkdir: can't create directory '%s': File exists\n", name)
+				return 1
+			}
+			continue
+		}
+		if !strings.Contains(flags, "p") && !server.fs.isDir(path.Dir(name)) {
+			fmt.Fprintf(exec.Stderr, "mkdir: can't create directory '%s': No such file or directory\n", name)
+			return 1
+		}
+		server.fs.mkdirAll(name)
+	}
+	return 0
+}
+
+func chmod(server *Server, exec *Exec) int {
+	args, _ := splitFlags(exec.Args[1:])
+	if len(args) < 2 {
+		fmt.Fprintln(exec.Stderr, "Usage: chmod MODE FILE...")
+		return 1
+	}
+	mode, err := strconv.ParseUint(args[0], 8, 32)
+	if err != nil {
+		fmt.Fprintf(exec.Stderr, "chmod: invalid mode '%s'\n", args[0])
+		return 1
+	}
+	for _, name := range args[1:] {
+		if file := server.fs.files[name]; file != nil {
+			file.mode = uint32(mode)
+		} else if !server.fs.isDir(name) {
+			fmt.Fprintf(exec.Stderr, "chmod: %s: No such file or directory\n", name)
+			return 1
+		}
+	}
+	return 0
+}
+
+func sha256sum(server *Server, exec *Exec) int {
+	for _, name := range exec.Args[1:] {
+		file := server.fs.files[name]
+		if file == nil {
+			fmt.Fprintf(exec.Stderr, "sha256sum: can't open '%s': No such file or directory\n", name)
+			return 1
+		}
+		sum := sha256.Sum256(file.data)
+		fmt.Fprintf(exec.Stdout, "%s  %s\n", hex.EncodeToString(sum[:]), name)
+	}
+	return 0
+}
+
+func uci(server *Server, exec *Exec) int {
+	return server.uci.run(exec.Args[1:], exec.Stdout, exec.Stderr)
+}
+
+// passwd emulates busybox passwd run by root: asks new password twice and sets it if both match.
+func passwd(server *Server, exec *Exec) int {
+	user := "root"
+	if args, _ := splitFlags(exec.Args[1:]); len(args) > 0 {
+		user = args[0]
+	}
+	server.mutex.Lock()
+	_, known := server.passwords[user]
+	server.mutex.Unlock()
+	if !known {
+		fmt.Fprintf(exec.Stderr, "passwd: unknown user %s\n", user)
+		return 1
+	}
+	in := bufio.NewReader(exec.Stdin)
+	io.WriteString(exec.Stdout, "Changing password for "+user+"\nNew password: ")
+	password, err := in.ReadString('\n')
+	if err != nil {
+		fmt.Fprintf(exec.Stderr, "passwd: password for %s is unchanged\n", user)
+		return 1
+	}
+	io.WriteString(exec.Stdout, "Retype password: ")
+	retyped, err := in.ReadString('\n')
+	if err != nil || retyped != password {
+		fmt.Fprintln(exec.Stderr, "Passwords don't match")
+		fmt.Fprintf(exec.Stderr, "passwd: password for %s is unchanged\n", user)
+		return 1
+	}
+	server.SetPassword(user, strings.TrimSuffix(password, "\n"))
+	fmt.Fprintf(exec.Stdout, "passwd: password for %s changed by root\n", user)
+	return 0
+}
+
+// tar emulates archiving of /etc/config: the archive holds committed uci packages, extracting it restores them.
+func tar(server *Server, exec *Exec) int {
+	args := exec.Args[1:]
+	if len(args) < 2 {
+		fmt.Fprintln(exec.Stderr, "tar: invalid arguments")
+		return 1
+	}
+	switch {
+	case strings.Contains(args[0], "c"):
+		archive := new(bytes.Buffer)
+		for _, pkg := range server.uci.Packages() {
+			io.WriteString(archive, server.uci.Export(pkg))
+			archive.WriteString("\x00")
+		}
+		if err := server.fs.write(args[1], archive.Bytes(), false); err != nil {
+			fmt.Fprintf(exec.Stderr, "tar: %s\n", err.Error())
+			return 1
+		}
+	case strings.Contains(args[0], "x"):
+		file := server.fs.files[args[1]]
+		if file == nil {
+			fmt.Fprintf(exec.Stderr, "tar: can't open '%s': No such file or directory\n", args[1])
+			return 1
+		}
+		for _, text := range strings.Split(string(file.data), "\x00") {
+			header, _, _ := strings.Cut(strings.TrimSpace(text), "\n")
+			if pkg, ok := strings.CutPrefix(header, "package "); ok {
+				server.uci.Load(pkg, text)
+			}
+		}
+	default:
+		fmt.Fprintln(exec.Stderr, "tar: invalid arguments")
+		return 1
+	}
+	return 0
+}
+
+// startStopDaemon registers background processes, which run only when Server.RunJobs is called,
+// and stops them by pid file.
+func startStopDaemon(server *Server, exec *Exec) int {
+	args := exec.Args[1:]
+	start, stop := false, false
+	pidFile := ""
+	var command []string
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-S":
+			start = true
+		case "-K":
+			stop = true
+		case "-p":
+			i++
+			if i < len(args) {
+				pidFile = args[i]
+			}
+		case "-x":
+			i++
+			if i < len(args) {
+				command = append(command, args[i])
+			}
+		case "--":
+			command = append(command, args[i+1:]...)
+			i = len(args)
+		}
+	}
+	switch {
+	case start && len(command) > 0:
+		server.jobs = append(server.jobs, &job{pidFile: pidFile, args: command})
+		if pidFile != "" {
+			server.fs.write(pidFile, []byte(strconv.Itoa(len(server.jobs))+"\n"), false)
+		}
+		return 0
+	case stop && pidFile != "":
+		for i, job := range server.jobs {
+			if job.pidFile == pidFile {
+				server.jobs = append(server.jobs[:i], server.jobs[i+1:]...)
+				return 0
+			}
+		}
+		return 1
+	}
+	fmt.Fprintln(exec.Stderr, "start-stop-daemon: invalid arguments")
+	return 1
+}
+
+// network emulates /etc/init.d/network init script.
+func network(server *Server, exec *Exec) int {
+	if len(exec.Args) < 2 {
+		fmt.Fprintln(exec.Stderr, "Syntax: /etc/init.d/network [command]")
+		return 1
+	}
+	switch exec.Args[1] {
+	case "reload", "restart":
+		server.reloads++
+		// hostapd is restarted and forgets neighbour reports
+		server.neighbours = make(map[string][][]string)
+	}
+	return 0
+}
+
+// splitFlags separates single-letter flags from arguments, all flags are joined into one string.
+func splitFlags(args []string) ([]string, string) {
+	var rest []string
+	flags := ""
+	for _, arg := range args {
+		if len(arg) > 1 && strings.HasPrefix(arg, "-") {
+			flags += arg[1:]
+		} else {
+			rest = append(rest, arg)
+		}
+	}
+	return rest, flags
+}
+
+type file struct {
+	data []byte
+	mode uint32
+}
+
+// fileSystem keeps files in memory, directories are implied by paths of files and created explicitly.
+type fileSystem struct {
+	files map[string]*file
+	dirs  map[string]bool
+}
+
+func newFileSystem() *fileSystem {
+	fs := &fileSystem{files: make(map[string]*file), dirs: make(map[string]bool)}
+	for _, dir := range []string{"/etc/config", "/etc/init.d", "/proc", "/tmp", "/root"} {
+		fs.mkdirAll(dir)
+	}
+	return fs
+}
+
+func (this *fileSystem) write(name string, data []byte, appending bool) error {
+	if !this.isDir(path.Dir(name)) {
+		return errors.New("No such file or directory")
+	}
+	existing := this.files[name]
+	if existing == nil {
+		this.files[name] = &file{data: bytes.Clone(data), mode: 0644}
+		return nil
+	}
+	if appending {
+		existing.data = append(existing.data, data...)
+	} else {
+		existing.data = bytes.Clone(data)
+	}
+	return nil
+}
+
+func (this *fileSystem) isDir(name string) bool {
+	return name == "/" || this.dirs[path.Clean(name)]
+}
+
+func (this *fileSystem) mkdirAll(name string) {
+	for name = path.Clean(name); name != "/" && name != "."; name = path.Dir(name) {
+		this.dirs[name] = true
+	}
+}
+
+// authorizedKeysFile is where dropbear looks for public keys root may log in with.
+const authorizedKeysFile = "/etc/dropbear/authorized_keys"
+
+// authorized tells whether the key is in root's authorized keys of dropbear.
+func (this *fileSystem) authorized(key ssh.PublicKey) bool {
+	file := this.files[authorizedKeysFile]
+	if file == nil {
+		return false
+	}
+	rest := file.data
+	for len(rest) > 0 {
+		authorized, _, _, next, err := ssh.ParseAuthorizedKey(rest)
+		if err != nil {
+			return false
+		}
+		if bytes.Equal(authorized.Marshal(), key.Marshal()) {
+			return true
+		}
+		rest = next
+	}
+	return false
+}
@@ -0,0 +1,194 @@
+package sshtest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// bss is a hostapd instance serving a wifi-iface, published on ubus as hostapd.<ifname>.
+type bss struct {
+	object string
+	bssid  string
+	ssid   string
+	report string
+}
+
+// DefaultWpad is the wpad variant of OpenWrt 23.05 images.
+const DefaultWpad = "wpad-basic-mbedtls"
+
+// wpadFeatures are features hostapd of wpad variants is built with, as reported by hostapd -v<feature>.
+var wpadFeatures = map[string][]string{
+	"wpad-mini":          {"11n", "11ac", "11r"},
+	"wpad-basic-mbedtls": {"11n", "11ac", "11ax", "11r", "11w", "sae", "owe"},
+	"wpad-mbedtls":       {"11n", "11ac", "11ax", "11r", "11w", "sae", "owe", "eap", "wps", "acs", "suiteb192"},
+}
+
+// SetWpad replaces wpad of the server with the variant, one of wpad-mini, wpad-basic-mbedtls and wpad-mbedtls.
+func (this *Server) SetWpad(variant string) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.wpad = variant
+}
+
+// Hostapd returns ubus objects of hostapd instances, one for each enabled access point wifi-iface
+// of committed wireless configuration, named as OpenWrt names interfaces: hostapd.phy<radio>-ap<index>.
+func (this *Server) Hostapd() []string {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	var objects []string
+	for _, bss := range this.bsses() {
+		objects = append(objects, bss.object)
+	}
+	return objects
+}
+
+// NeighbourReports returns neighbour reports set to the hostapd instance by rrm_nr_set ubus method.
+// Reports are lost when network is reloaded, as hostapd is restarted.
+func (this *Server) NeighbourReports(object string) [][]string {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return append([][]string(nil), this.neighbours[object]...)
+}
+
+func (this *Server) bsses() []*bss {
+	pkg := this.uci.committed["wireless"]
+	if pkg == nil {
+		return nil
+	}
+	var bsses []*bss
+	counts := make(map[string]int)
+	for _, sec := range pkg.sections {
+		if sec.kind != "wifi-iface" || optionValue(sec, "mode") != "ap" || optionValue(sec, "disabled") == "1" {
+			continue
+		}
+		radio := optionValue(sec, "device")
+		ifname := optionValue(sec, "ifname")
+		if ifname == "" {
+			ifname = fmt.Sprintf("phy%s-ap%d", strings.TrimPrefix(radio, "radio"), counts[radio])
+		}
+		counts[radio]++
+		bssid := optionValue(sec, "macaddr")
+		if bssid == "" {
+			sum := sha256.Sum256([]byte(this.listener.Addr().String() + "/" + ifname))
+			sum[0] = sum[0]&0xfc | 0x02
+			bssid = colonMac(hex.EncodeToString(sum[:6]))
+		}
+		device := pkg.find(radio)
+		channel, _ := strconv.Atoi(optionValue(device, "channel"))
+		class, phy := 81, 7
+		if optionValue(device, "band") == "5g" {
+			class, phy = 115, 9
+		}
+		// BSSID, BSSID information, operating class, channel and PHY type of 802.11k neighbour report element
+		report := fmt.Sprintf("%s%s%02x%02x%02x", strings.ReplaceAll(bssid, ":", ""), "af090000", class, channel, phy)
+		bsses = append(bsses, &bss{object: "hostapd." + ifname, bssid: bssid, ssid: optionValue(sec, "ssid"), report: report})
+	}
+	return bsses
+}
+
+func optionValue(sec *uciSection, name string) string {
+	if sec == nil {
+		return ""
+	}
+	if opt := sec.find(name); opt != nil && len(opt.values) > 0 {
+		return opt.values[len(opt.values)-1]
+	}
+	return ""
+}
+
+func colonMac(digits string) string {
+	octets := make([]string, 0, len(digits)/2)
+	for i := 0; i+1 < len(digits); i += 2 {
+		octets = append(octets, digits[i:i+2])
+	}
+	return strings.Join(octets, ":")
+}
+
+// ubus emulates ubus utility for hostapd objects: list and call of rrm_nr_get_own, rrm_nr_set and rrm_nr_list.
+func ubus(server *Server, exec *Exec) int {
+	args := exec.Args[1:]
+	switch {
+	case len(args) >= 1 && args[0] == "list":
+		pattern := "*"
+		if len(args) > 1 {
+			pattern = args[1]
+		}
+		for _, bss := range server.bsses() {
+			if matched, _ := path.Match(pattern, bss.object); matched {
+				fmt.Fprintln(exec.Stdout, bss.object)
+			}
+		}
+		return 0
+	case len(args) >= 3 && args[0] == "call":
+		var target *bss
+		for _, bss := range server.bsses() {
+			if bss.object == args[1] {
+				target = bss
+			}
+		}
+		if target == nil {
+			fmt.Fprintln(exec.Stderr, "Command failed: Not found")
+			return 4
+		}
+		return hostapdCall(server, exec, target, args[2], args[3:])
+	default:
+		fmt.Fprintln(exec.Stderr, "Usage: ubus [<options>] <command> [arguments...]")
+		return 1
+	}
+}
+
+func hostapdCall(server *Server, exec *Exec, target *bss, method string, args []string) int {
+	var reply any
+	switch method {
+	case "rrm_nr_get_own":
+		reply = map[string][]string{"value": {target.bssid, target.ssid, target.report}}
+	case "rrm_nr_list":
+		reply = map[string][][]string{"list": server.neighbours[target.object]}
+	case "rrm_nr_set":
+		var request struct {
+			List [][]string `json:"list"`
+		}
+		if len(args) != 1 || json.Unmarshal([]byte(args[0]), &request) != nil {
+			fmt.Fprintln(exec.Stderr, "Command failed: Invalid argument")
+			return 2
+		}
+		for _, report := range request.List {
+			if len(report) != 3 {
+				fmt.Fprintln(exec.Stderr, "Command failed: Invalid argument")
+				return 2
+			}
+		}
+		server.neighbours[target.object] = request.List
+		return 0
+	default:
+		fmt.Fprintln(exec.Stderr, "Command failed: Method not found")
+		return 3
+	}
+	out, _ := json.MarshalIndent(reply, "", "\t")
+	fmt.Fprintln(exec.Stdout, string(out))
+	return 0
+}
+
+// hostapd emulates feature checks of OpenWrt hostapd: -v<feature> exits with zero status when wpad is built
+// with the feature, -v prints the version.
+func hostapd(server *Server, exec *Exec) int {
+	if len(exec.Args) != 2 || !strings.HasPrefix(exec.Args[1], "-v") {
+		fmt.Fprintln(exec.Stderr, "usage: hostapd [-v[feature]]")
+		return 1
+	}
+	feature := strings.TrimPrefix(exec.Args[1], "-v")
+	if feature == "" {
+		fmt.Fprintln(exec.Stderr, "hostapd v2.11-devel")
+		return 1
+	}
+	if slices.Contains(wpadFeatures[server.wpad], feature) {
+		return 0
+	}
+	return 1
+}
@@ -0,0 +1,156 @@
+package sshtest
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DefaultUsteer and DefaultDawn are uci packages installed along with usteer and dawn packages.
+const DefaultUsteer = `
+config usteer
+	option network 'lan'
+	option syslog '1'
+	option local_mode '0'
+	option ipv6 '0'
+	option debug_level '2'
+`
+
+const DefaultDawn = `
+config local
+	option loglevel '0'
+
+config network
+	option broadcast_port '1025'
+	option tcp_port '1026'
+	option network_option '2'
+	option shared_key 'Niiiiiiiiiiiiick'
+	option iv 'Niiiiiiiiiiiiick'
+	option use_symm_enc '1'
+	option collision_domain '-1'
+	option bandwidth '-1'
+
+config metric 'global'
+	option kicking '0'
+	option set_hostapd_nr '2'
+
+config metric '802_11g'
+	option rssi_val '-60'
+	option low_rssi_val '-80'
+	option rssi '10'
+	option low_rssi '-15'
+
+config metric '802_11a'
+	option rssi_val '-60'
+	option low_rssi_val '-80'
+	option rssi '10'
+	option low_rssi '-15'
+`
+
+// packageConfigs are packages opkg of a server can install, along with their uci configuration.
+var packageConfigs = map[string]string{"usteer": DefaultUsteer, "dawn": DefaultDawn}
+
+// service is a procd service of an installed package.
+type service struct {
+	enabled bool
+	running bool
+}
+
+// Installed tells whether the package is installed by opkg.
+func (this *Server) Installed(pkg string) bool {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.services[pkg] != nil
+}
+
+// ServiceState tells whether the service of an installed package is enabled and running.
+func (this *Server) ServiceState(name string) (enabled, running bool) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if svc := this.services[name]; svc != nil {
+		return svc.enabled, svc.running
+	}
+	return false, false
+}
+
+// StopService stops the service as if it crashed.
+func (this *Server) StopService(name string) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if svc := this.services[name]; svc != nil {
+		svc.running = false
+	}
+}
+
+// opkg emulates update, list-installed and install commands of opkg for packages of packageConfigs.
+func opkg(server *Server, exec *Exec) int {
+	args := exec.Args[1:]
+	if len(args) == 0 {
+		fmt.Fprintln(exec.Stderr, "opkg: no command given")
+		return 1
+	}
+	switch args[0] {
+	case "update":
+		return 0
+	case "list-installed":
+		var names []string
+		for name := range server.services {
+			if len(args) == 1 || args[1] == name {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(exec.Stdout, "%s - 1.0-1\n", name)
+		}
+		return 0
+	case "install":
+		for _, name := range args[1:] {
+			config, ok := packageConfigs[name]
+			if !ok {
+				fmt.Fprintf(exec.Stderr, "Unknown package '%s'.\nCollected errors:\n * opkg_install_cmd: Cannot install package %s.\n", name, name)
+				return 255
+			}
+			if server.services[name] == nil {
+				server.services[name] = new(service)
+				server.uci.Load(name, config)
+			}
+		}
+		return 0
+	default:
+		fmt.Fprintf(exec.Stderr, "opkg: unknown sub-command %s\n", args[0])
+		return 1
+	}
+}
+
+// initScript emulates /etc/init.d script of a service installed by opkg.
+func initScript(server *Server, exec *Exec) int {
+	name := baseName(exec.Args[0])
+	svc := server.services[name]
+	if svc == nil || !strings.HasPrefix(exec.Args[0], "/etc/init.d/") {
+		fmt.Fprintf(exec.Stderr, "sh: %s: not found\n", exec.Args[0])
+		return 127
+	}
+	if len(exec.Args) < 2 {
+		fmt.Fprintf(exec.Stderr, "Syntax: %s [command]\n", exec.Args[0])
+		return 1
+	}
+	switch exec.Args[1] {
+	case "enable":
+		svc.enabled = true
+	case "disable":
+		svc.enabled = false
+	case "start", "restart", "reload":
+		svc.running = true
+	case "stop":
+		svc.running = false
+	case "running":
+		if !svc.running {
+			return 1
+		}
+	default:
+		fmt.Fprintf(exec.Stderr, "Syntax: %s [command]\n", exec.Args[0])
+		return 1
+	}
+	return 0
+}
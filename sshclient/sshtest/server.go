@@ -0,0 +1,592 @@
+// Package sshtest provides an in-process SSH server emulating an OpenWrt access point for tests: a shell
+// with common busybox commands, in-memory uci configuration, passwd, hostapd ubus objects, opkg with a few packages
+// and a table of scripted commands.
+package sshtest
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"net"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Response is a scripted result of a command.
+type Response struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+}
+
+// Exec is a command handled by a HandlerFunc.
+type Exec struct {
+	Args   []string
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// HandlerFunc handles a command, returning its exit status. It runs with the server lock held,
+// so it may change state of the server directly.
+type HandlerFunc func(server *Server, exec *Exec) int
+
+type handler struct {
+	pattern *regexp.Regexp
+	handle  HandlerFunc
+}
+
+// job is a background process started by start-stop-daemon.
+type job struct {
+	pidFile string
+	args    []string
+}
+
+// Server is a fake access point listening on a loopback port.
+type Server struct {
+	listener  net.Listener
+	mutex     sync.Mutex
+	hostKey   ssh.Signer
+	passwords map[string]string
+	fs        *fileSystem
+	uci       *Uci
+	handlers  []*handler
+	commands  []string
+	jobs      []*job
+	reloads   int
+	// neighbour reports of hostapd instances by ubus object
+	neighbours map[string][][]string
+	// services of packages installed by opkg
+	services map[string]*service
+	// wpad variant hostapd is built as
+	wpad string
+	// addresses of direct-tcpip channels, the server forwards them as a jump host
+	forwards []string
+	// successful authentications as "user method"
+	logins []string
+	// sftp subsystem is served
+	sftp  bool
+	down  bool
+	conns map[net.Conn]bool
+	// connections which stopped responding
+	stalled map[net.Conn]bool
+	wg      sync.WaitGroup
+}
+
+// DefaultNetwork and DefaultWireless are the initial network and wireless uci packages of a server,
+// a dual band access point with default SSIDs.
+const DefaultNetwork = `
+config interface 'loopback'
+	option device 'lo'
+	option proto 'static'
+	option ipaddr '127.0.0.1'
+	option netmask '255.0.0.0'
+
+config device
+	option name 'br-lan'
+	option type 'bridge'
+	list ports 'lan'
+
+config interface 'lan'
+	option device 'br-lan'
+	option proto 'dhcp'
+`
+
+const DefaultWireless = `
+config wifi-device 'radio0'
+	option type 'mac80211'
+	option band '2g'
+	option channel '1'
+
+config wifi-iface 'default_radio0'
+	option device 'radio0'
+	option network 'lan'
+	option mode 'ap'
+	option ssid 'OpenWrt'
+	option encryption 'none'
+
+config wifi-device 'radio1'
+	option type 'mac80211'
+	option band '5g'
+	option channel '36'
+
+config wifi-iface 'default_radio1'
+	option device 'radio1'
+	option network 'lan'
+	option mode 'ap'
+	option ssid 'OpenWrt'
+	option encryption 'none'
+`
+
+// DefaultRelease is /etc/openwrt_release of a server, DefaultUptime is its /proc/uptime.
+const DefaultRelease = `DISTRIB_ID='OpenWrt'
+DISTRIB_RELEASE='23.05.3'
+DISTRIB_REVISION='r23809-234f1a2efa'
+DISTRIB_TARGET='mediatek/filogic'
+DISTRIB_ARCH='aarch64_cortex-a53'
+DISTRIB_DESCRIPTION='OpenWrt 23.05.3 r23809-234f1a2efa'
+DISTRIB_TAINTS=''
+`
+
+const DefaultUptime = "93784.52 181920.17\n"
+
+// NewServer starts a server on a random loopback port. Like a freshly installed OpenWrt it lets root in
+// with an empty password and has default network and wireless configuration, release and uptime files.
+func NewServer() (*Server, error) {
+	hostKey, err := NewHostKey()
+	if err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	server := &Server{listener: listener, hostKey: hostKey, passwords: map[string]string{"root": ""},
+		fs: newFileSystem(), uci: NewUci(), neighbours: make(map[string][][]string),
+		services: make(map[string]*service), wpad: DefaultWpad, conns: make(map[net.Conn]bool),
+		stalled: make(map[net.Conn]bool)}
+	server.uci.Load("network", DefaultNetwork)
+	server.uci.Load("wireless", DefaultWireless)
+	server.fs.write("/etc/openwrt_release", []byte(DefaultRelease), false)
+	server.fs.write("/proc/uptime", []byte(DefaultUptime), false)
+	server.wg.Add(1)
+	go server.serve()
+	return server, nil
+}
+
+// NewHostKey generates a random ed25519 host key.
+func NewHostKey() (ssh.Signer, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return ssh.NewSignerFromKey(key)
+}
+
+// Host and Port of the server address.
+func (this *Server) Host() string {
+	return this.listener.Addr().(*net.TCPAddr).IP.String()
+}
+
+func (this *Server) Port() int {
+	return this.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (this *Server) Addr() string {
+	return this.listener.Addr().String()
+}
+
+// HostKey returns public key the server presents.
+func (this *Server) HostKey() ssh.PublicKey {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.hostKey.PublicKey()
+}
+
+// SetHostKey replaces the host key for following connections, like a reinstalled access point does.
+func (this *Server) SetHostKey(hostKey ssh.Signer) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.hostKey = hostKey
+}
+
+// SetDown makes the server drop new connections and closes open ones, as an unreachable access point.
+func (this *Server) SetDown(down bool) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.down = down
+	if down {
+		for conn := range this.conns {
+			conn.Close()
+		}
+	}
+}
+
+// Stall makes open connections stop responding without closing them, as when the management path is lost
+// during a network reload. New connections are served.
+func (this *Server) Stall() {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	for conn := range this.conns {
+		this.stalled[conn] = true
+	}
+}
+
+func (this *Server) isStalled(conn net.Conn) bool {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.stalled[conn]
+}
+
+// Password returns password of the user.
+func (this *Server) Password(user string) string {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.passwords[user]
+}
+
+func (this *Server) SetPassword(user, password string) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.passwords[user] = password
+}
+
+// Handle adds scripted response to commands matching the pattern. Patterns are matched against command
+// arguments joined with space, the first matching handler wins; builtin commands are used when none match.
+func (this *Server) Handle(pattern string, response Response) {
+	this.HandleFunc(pattern, func(server *Server, exec *Exec) int {
+		io.WriteString(exec.Stdout, response.Stdout)
+		io.WriteString(exec.Stderr, response.Stderr)
+		return response.ExitCode
+	})
+}
+
+func (this *Server) HandleFunc(pattern string, handle HandlerFunc) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.handlers = append(this.handlers, &handler{pattern: regexp.MustCompile(pattern), handle: handle})
+}
+
+// Uci returns uci configuration of the server. Use it when no connection is active or from handlers.
+func (this *Server) Uci() *Uci {
+	return this.uci
+}
+
+// ReadFile returns content of a file of the server file system.
+func (this *Server) ReadFile(path string) ([]byte, bool) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	file := this.fs.files[path]
+	if file == nil {
+		return nil, false
+	}
+	return append([]byte(nil), file.data...), true
+}
+
+// FileMode returns permission bits of a file as set by chmod.
+func (this *Server) FileMode(path string) (uint32, bool) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	file := this.fs.files[path]
+	if file == nil {
+		return 0, false
+	}
+	return file.mode, true
+}
+
+func (this *Server) WriteFile(path string, data []byte) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.fs.write(path, data, false)
+}
+
+// AuthorizeKey adds the key to authorized keys of root, so that it logs in as to a jump host set up beforehand.
+func (this *Server) AuthorizeKey(key ssh.PublicKey) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.fs.mkdirAll(path.Dir(authorizedKeysFile))
+	return this.fs.write(authorizedKeysFile, ssh.MarshalAuthorizedKey(key), true)
+}
+
+// Commands returns executed commands, arguments joined with space, in order of execution.
+func (this *Server) Commands() []string {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return append([]string(nil), this.commands...)
+}
+
+// Reloads tells how many times network configuration was reloaded.
+func (this *Server) Reloads() int {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.reloads
+}
+
+// Forwards returns addresses clients opened direct-tcpip channels to through the server, in order.
+func (this *Server) Forwards() []string {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return append([]string(nil), this.forwards...)
+}
+
+// Logins returns successful authentications, user and method joined with space, in order.
+func (this *Server) Logins() []string {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return append([]string(nil), this.logins...)
+}
+
+// Jobs returns pid files of background processes started by start-stop-daemon and not stopped yet.
+func (this *Server) Jobs() []string {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	pidFiles := make([]string, len(this.jobs))
+	for i, job := range this.jobs {
+		pidFiles[i] = job.pidFile
+	}
+	return pidFiles
+}
+
+// RunJobs runs background processes to completion at once, e.g. a scheduled configuration restore.
+// Their sleep is skipped.
+func (this *Server) RunJobs() {
+	this.mutex.Lock()
+	jobs := this.jobs
+	this.jobs = nil
+	this.mutex.Unlock()
+	for _, job := range jobs {
+		if baseName(job.args[0]) == "sh" && len(job.args) > 1 {
+			script, _ := this.ReadFile(job.args[len(job.args)-1])
+			sh := &shell{server: this}
+			sh.runScript(strings.NewReader(string(script)), io.Discard, io.Discard)
+			continue
+		}
+		this.execute(job.args, strings.NewReader(""), io.Discard, io.Discard)
+	}
+}
+
+func (this *Server) Close() error {
+	err := this.listener.Close()
+	this.mutex.Lock()
+	for conn := range this.conns {
+		conn.Close()
+	}
+	this.mutex.Unlock()
+	this.wg.Wait()
+	return err
+}
+
+func (this *Server) serve() {
+	defer this.wg.Done()
+	for {
+		conn, err := this.listener.Accept()
+		if err != nil {
+			return
+		}
+		this.mutex.Lock()
+		if this.down {
+			this.mutex.Unlock()
+			conn.Close()
+			continue
+		}
+		this.conns[conn] = true
+		this.mutex.Unlock()
+		this.wg.Add(1)
+		go func() {
+			defer this.wg.Done()
+			this.handleConn(conn)
+			this.mutex.Lock()
+			delete(this.conns, conn)
+			delete(this.stalled, conn)
+			this.mutex.Unlock()
+		}()
+	}
+}
+
+func (this *Server) serverConfig() *ssh.ServerConfig {
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(meta ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			this.mutex.Lock()
+			defer this.mutex.Unlock()
+			if expected, ok := this.passwords[meta.User()]; ok && expected == string(password) {
+				this.logins = append(this.logins, meta.User()+" password")
+				return nil, nil
+			}
+			return nil, errors.New("wrong password")
+		},
+		PublicKeyCallback: func(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			this.mutex.Lock()
+			defer this.mutex.Unlock()
+			if meta.User() == "root" && this.fs.authorized(key) {
+				this.logins = append(this.logins, meta.User()+" publickey")
+				return nil, nil
+			}
+			return nil, errors.New("unknown public key")
+		},
+	}
+	this.mutex.Lock()
+	config.AddHostKey(this.hostKey)
+	this.mutex.Unlock()
+	return config
+}
+
+func (this *Server) handleConn(conn net.Conn) {
+	defer conn.Close()
+	serverConn, chans, reqs, err := ssh.NewServerConn(conn, this.serverConfig())
+	if err != nil {
+		return
+	}
+	defer serverConn.Close()
+	go func() {
+		for req := range reqs {
+			// keepalive and other global requests
+			if !this.isStalled(conn) {
+				req.Reply(req.Type == "keepalive@openssh.com", nil)
+			}
+		}
+	}()
+	for newChannel := range chans {
+		if this.isStalled(conn) {
+			// the client waits for the channel until it gives up
+			continue
+		}
+		if newChannel.ChannelType() == "direct-tcpip" {
+			this.wg.Add(1)
+			go func() {
+				defer this.wg.Done()
+				this.forward(newChannel)
+			}()
+			continue
+		}
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only sessions and direct-tcpip are supported")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go this.handleSession(channel, requests)
+	}
+}
+
+// forward connects a direct-tcpip channel to the address the client asks for, as dropbear does by default.
+// Both directions are closed once either of them ends.
+func (this *Server) forward(newChannel ssh.NewChannel) {
+	var payload struct {
+		Host       string
+		Port       uint32
+		OriginHost string
+		OriginPort uint32
+	}
+	if err := ssh.Unmarshal(newChannel.ExtraData(), &payload); err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, "invalid direct-tcpip request")
+		return
+	}
+	address := net.JoinHostPort(payload.Host, strconv.Itoa(int(payload.Port)))
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	defer conn.Close()
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		return
+	}
+	defer channel.Close()
+	go ssh.DiscardRequests(requests)
+	this.mutex.Lock()
+	this.forwards = append(this.forwards, address)
+	this.mutex.Unlock()
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(conn, channel)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(channel, conn)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+func (this *Server) handleSession(channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close()
+	for req := range requests {
+		switch req.Type {
+		case "exec":
+			var payload struct{ Command string }
+			if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			req.Reply(true, nil)
+			go func() {
+				for req := range requests {
+					// signals are not delivered, the session is closed by the client after them
+					req.Reply(false, nil)
+				}
+			}()
+			status := this.run(payload.Command, channel, channel, channel.Stderr())
+			exitStatus := make([]byte, 4)
+			binary.BigEndian.PutUint32(exitStatus, uint32(status))
+			channel.SendRequest("exit-status", false, exitStatus)
+			return
+		case "subsystem":
+			var payload struct{ Name string }
+			this.mutex.Lock()
+			enabled := this.sftp
+			this.mutex.Unlock()
+			if ssh.Unmarshal(req.Payload, &payload) != nil || payload.Name != "sftp" || !enabled {
+				req.Reply(false, nil)
+				continue
+			}
+			req.Reply(true, nil)
+			go ssh.DiscardRequests(requests)
+			this.serveSftp(channel)
+			return
+		case "env", "pty-req":
+			req.Reply(req.Type == "env", nil)
+		default:
+			// no shell, like a minimal dropbear image
+			req.Reply(false, nil)
+		}
+	}
+}
+
+// run executes a command line sent by the client. "sh -e" and "/bin/sh" read a script from stdin.
+func (this *Server) run(command string, stdin io.Reader, stdout, stderr io.Writer) int {
+	sh := &shell{server: this}
+	args := strings.Fields(command)
+	if len(args) > 0 && (args[0] == "/bin/sh" || args[0] == "sh") {
+		for _, arg := range args[1:] {
+			if arg != "-e" {
+				return sh.runLine(strings.Join(args[1:], " "), stdin, stdout, stderr)
+			}
+		}
+		sh.errexit = len(args) > 1
+		return sh.runScript(stdin, stdout, stderr)
+	}
+	return sh.runLine(command, stdin, stdout, stderr)
+}
+
+// execute runs a simple command by a scripted handler or a builtin.
+func (this *Server) execute(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	line := strings.Join(args, " ")
+	this.mutex.Lock()
+	this.commands = append(this.commands, line)
+	var handle HandlerFunc
+	for _, h := range this.handlers {
+		if h.pattern.MatchString(line) {
+			handle = h.handle
+			break
+		}
+	}
+	if handle == nil {
+		handle = builtins[baseName(args[0])]
+	}
+	if handle == nil {
+		this.mutex.Unlock()
+		fmt.Fprintf(stderr, "sh: %s: not found\n", args[0])
+		return 127
+	}
+	if baseName(args[0]) == "passwd" {
+		// passwd waits for the client, other commands must not be blocked meanwhile
+		this.mutex.Unlock()
+		return handle(this, &Exec{Args: args, Stdin: stdin, Stdout: stdout, Stderr: stderr})
+	}
+	defer this.mutex.Unlock()
+	return handle(this, &Exec{Args: args, Stdin: stdin, Stdout: stdout, Stderr: stderr})
+}
+
+func baseName(path string) string {
+	return path[strings.LastIndex(path, "/")+1:]
+}
@@ -0,0 +1,81 @@
+package sshtest
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path"
+	"sync"
+
+	"github.com/pkg/sftp"
+)
+
+// SetSftp enables sftp subsystem, as an image with sftp-server installed has. It is off by default.
+func (this *Server) SetSftp(enabled bool) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.sftp = enabled
+}
+
+// serveSftp serves the server file system over SFTP on the channel until the client closes it.
+func (this *Server) serveSftp(channel io.ReadWriteCloser) {
+	handler := &sftpHandler{server: this}
+	server := sftp.NewRequestServer(channel, sftp.Handlers{FileGet: handler, FilePut: handler, FileCmd: handler, FileList: handler})
+	server.Serve()
+	server.Close()
+}
+
+// sftpHandler reads and writes files of the server file system, other requests are not supported.
+type sftpHandler struct {
+	server *Server
+}
+
+func (this *sftpHandler) Fileread(request *sftp.Request) (io.ReaderAt, error) {
+	data, ok := this.server.ReadFile(request.Filepath)
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return bytes.NewReader(data), nil
+}
+
+func (this *sftpHandler) Filewrite(request *sftp.Request) (io.WriterAt, error) {
+	this.server.mutex.Lock()
+	defer this.server.mutex.Unlock()
+	if !this.server.fs.isDir(path.Dir(request.Filepath)) {
+		return nil, os.ErrNotExist
+	}
+	return &sftpFile{server: this.server, path: request.Filepath}, nil
+}
+
+func (this *sftpHandler) Filecmd(request *sftp.Request) error {
+	return sftp.ErrSSHFxOpUnsupported
+}
+
+func (this *sftpHandler) Filelist(request *sftp.Request) (sftp.ListerAt, error) {
+	return nil, sftp.ErrSSHFxOpUnsupported
+}
+
+// sftpFile collects content written over SFTP, it is stored to the file system when the file is closed.
+type sftpFile struct {
+	server *Server
+	path   string
+	mutex  sync.Mutex
+	data   []byte
+}
+
+func (this *sftpFile) WriteAt(p []byte, offset int64) (int, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if end := int(offset) + len(p); end > len(this.data) {
+		this.data = append(this.data, make([]byte, end-len(this.data))...)
+	}
+	return copy(this.data[offset:], p), nil
+}
+
+func (this *sftpFile) Close() error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.server.mutex.Lock()
+	defer this.server.mutex.Unlock()
+	return this.server.fs.write(this.path, this.data, false)
+}
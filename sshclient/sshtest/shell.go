@@ -0,0 +1,341 @@
+package sshtest
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// shell interprets the subset of POSIX shell wnetctl sends to access points: simple commands with quoting,
+// command substitution, redirections to files, &&, || and ; lists. Pipes, variables and control
+// structures are not supported.
+type shell struct {
+	server  *Server
+	errexit bool
+}
+
+// word is a shell word made of literal text and command substitutions expanded when the command runs.
+type word struct {
+	parts []wordPart
+}
+
+type wordPart struct {
+	text  string
+	subst bool
+}
+
+type redirect struct {
+	fd     int
+	target string
+	append bool
+	toFd   int
+}
+
+type simpleCommand struct {
+	words     []*word
+	redirects []*redirect
+}
+
+// listItem is a command of a list along with the operator joining it to the previous one.
+type listItem struct {
+	operator string
+	command  *simpleCommand
+}
+
+var errSyntax = errors.New("syntax error")
+
+// runLine executes a command line, returning its exit status.
+func (this *shell) runLine(line string, stdin io.Reader, stdout, stderr io.Writer) int {
+	items, err := parseList(line)
+	if err != nil {
+		fmt.Fprintf(stderr, "sh: %s: %s\n", err.Error(), line)
+		return 2
+	}
+	status := 0
+	for i, item := range items {
+		if i > 0 && (item.operator == "&&" && status != 0 || item.operator == "||" && status == 0) {
+			continue
+		}
+		status = this.runCommand(item.command, stdin, stdout, stderr)
+	}
+	return status
+}
+
+// runScript executes lines read from stdin; with errexit set it stops at the first failed line.
+func (this *shell) runScript(stdin io.Reader, stdout, stderr io.Writer) int {
+	scanner := bufio.NewScanner(stdin)
+	status := 0
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		status = this.runLine(line, strings.NewReader(""), stdout, stderr)
+		if status != 0 && this.errexit {
+			return status
+		}
+	}
+	return status
+}
+
+func (this *shell) runCommand(command *simpleCommand, stdin io.Reader, stdout, stderr io.Writer) int {
+	args := make([]string, 0, len(command.words))
+	for _, w := range command.words {
+		args = append(args, this.expand(w, stderr))
+	}
+	out, errOut := stdout, stderr
+	var files []*redirect
+	var outputs []*bytes.Buffer
+	for _, r := range command.redirects {
+		var target io.Writer
+		switch {
+		case r.toFd == 1:
+			target = stdout
+		case r.toFd == 2:
+			target = stderr
+		case r.target == "/dev/null":
+			target = io.Discard
+		default:
+			buf := new(bytes.Buffer)
+			files = append(files, r)
+			outputs = append(outputs, buf)
+			target = buf
+		}
+		if r.fd == 2 {
+			errOut = target
+		} else {
+			out = target
+		}
+	}
+	if len(args) == 0 {
+		return 0
+	}
+	status := this.server.execute(args, stdin, out, errOut)
+	for i, r := range files {
+		if err := this.server.fs.write(r.target, outputs[i].Bytes(), r.append); err != nil {
+			fmt.Fprintf(stderr, "sh: can't create %s: %s\n", r.target, err.Error())
+			return 1
+		}
+	}
+	return status
+}
+
+func (this *shell) expand(w *word, stderr io.Writer) string {
+	sb := new(strings.Builder)
+	for _, part := range w.parts {
+		if !part.subst {
+			sb.WriteString(part.text)
+			continue
+		}
+		out := new(strings.Builder)
+		this.runLine(part.text, strings.NewReader(""), out, stderr)
+		sb.WriteString(strings.TrimRight(out.String(), "\n"))
+	}
+	return sb.String()
+}
+
+// parseList splits a command line into simple commands joined with &&, || and ;.
+func parseList(line string) ([]*listItem, error) {
+	p := &parser{input: []rune(line)}
+	var items []*listItem
+	operator := ""
+	for {
+		command, next, err := p.command()
+		if err != nil {
+			return nil, err
+		}
+		if len(command.words) == 0 && len(command.redirects) == 0 {
+			if next != "" || operator != "" && operator != ";" {
+				return nil, errSyntax
+			}
+			return items, nil
+		}
+		items = append(items, &listItem{operator: operator, command: command})
+		if next == "" {
+			return items, nil
+		}
+		operator = next
+	}
+}
+
+type parser struct {
+	input []rune
+	pos   int
+}
+
+// command parses words and redirections up to a list operator, which is returned.
+func (this *parser) command() (*simpleCommand, string, error) {
+	command := new(simpleCommand)
+	for {
+		this.skipSpaces()
+		if this.pos >= len(this.input) {
+			return command, "", nil
+		}
+		switch {
+		case this.consume("&&"):
+			return command, "&&", nil
+		case this.consume("||"):
+			return command, "||", nil
+		case this.consume(";"):
+			return command, ";", nil
+		case this.peek("|") || this.peek("&") || this.peek("<"):
+			return nil, "", errSyntax
+		case this.peek(">") || this.peek("2>"):
+			r, err := this.redirect()
+			if err != nil {
+				return nil, "", err
+			}
+			command.redirects = append(command.redirects, r)
+		default:
+			w, err := this.word()
+			if err != nil {
+				return nil, "", err
+			}
+			command.words = append(command.words, w)
+		}
+	}
+}
+
+func (this *parser) redirect() (*redirect, error) {
+	r := &redirect{fd: 1}
+	if this.consume("2") {
+		r.fd = 2
+	}
+	this.consume(">")
+	if this.consume(">") {
+		r.append = true
+	}
+	if this.consume("&") {
+		switch {
+		case this.consume("1"):
+			r.toFd = 1
+		case this.consume("2"):
+			r.toFd = 2
+		default:
+			return nil, errSyntax
+		}
+		return r, nil
+	}
+	this.skipSpaces()
+	w, err := this.word()
+	if err != nil {
+		return nil, err
+	}
+	for _, part := range w.parts {
+		if part.subst {
+			return nil, errSyntax
+		}
+		r.target += part.text
+	}
+	if r.target == "" {
+		return nil, errSyntax
+	}
+	return r, nil
+}
+
+// word parses a word with single and double quotes, backslash escapes and $(...) substitutions.
+func (this *parser) word() (*word, error) {
+	w := new(word)
+	literal := new(strings.Builder)
+	flush := func() {
+		if literal.Len() > 0 {
+			w.parts = append(w.parts, wordPart{text: literal.String()})
+			literal.Reset()
+		}
+	}
+	inDouble := false
+	for this.pos < len(this.input) {
+		r := this.input[this.pos]
+		if !inDouble && (r == ' ' || r == '\t' || strings.ContainsRune(";&|<>", r)) {
+			break
+		}
+		switch {
+		case r == '\'' && !inDouble:
+			end := this.pos + 1
+			for end < len(this.input) && this.input[end] != '\'' {
+				end++
+			}
+			if end >= len(this.input) {
+				return nil, errSyntax
+			}
+			literal.WriteString(string(this.input[this.pos+1 : end]))
+			this.pos = end + 1
+		case r == '"':
+			inDouble = !inDouble
+			this.pos++
+		case r == '\\' && this.pos+1 < len(this.input):
+			next := this.input[this.pos+1]
+			if inDouble && !strings.ContainsRune("\"\\$`", next) {
+				literal.WriteRune(r)
+			}
+			literal.WriteRune(next)
+			this.pos += 2
+		case r == '$' && this.pos+1 < len(this.input) && this.input[this.pos+1] == '(':
+			end, err := this.matchParen(this.pos + 2)
+			if err != nil {
+				return nil, err
+			}
+			flush()
+			w.parts = append(w.parts, wordPart{text: string(this.input[this.pos+2 : end]), subst: true})
+			this.pos = end + 1
+		default:
+			literal.WriteRune(r)
+			this.pos++
+		}
+	}
+	if inDouble {
+		return nil, errSyntax
+	}
+	flush()
+	if len(w.parts) == 0 {
+		// quoted empty string is still a word
+		w.parts = append(w.parts, wordPart{})
+	}
+	return w, nil
+}
+
+// matchParen finds closing parenthesis of a substitution starting at pos, skipping quoted text.
+func (this *parser) matchParen(pos int) (int, error) {
+	depth := 1
+	quote := rune(0)
+	for ; pos < len(this.input); pos++ {
+		r := this.input[pos]
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == '(':
+			depth++
+		case r == ')':
+			depth--
+			if depth == 0 {
+				return pos, nil
+			}
+		}
+	}
+	return 0, errSyntax
+}
+
+func (this *parser) skipSpaces() {
+	for this.pos < len(this.input) && (this.input[this.pos] == ' ' || this.input[this.pos] == '\t') {
+		this.pos++
+	}
+}
+
+func (this *parser) peek(s string) bool {
+	return strings.HasPrefix(string(this.input[this.pos:]), s)
+}
+
+func (this *parser) consume(s string) bool {
+	if this.peek(s) {
+		this.pos += len([]rune(s))
+		return true
+	}
+	return false
+}
@@ -0,0 +1,557 @@
+package sshtest
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Uci is an in-memory uci configuration with separate committed and staged states, like /etc/config
+// and /tmp/.uci of a real device. Methods are not synchronized, Server serializes access to it.
+type Uci struct {
+	committed map[string]*uciPackage
+	staged    map[string]*uciPackage
+	changes   map[string][]string
+	anonymous int
+}
+
+type uciPackage struct {
+	sections []*uciSection
+}
+
+type uciSection struct {
+	name      string
+	kind      string
+	anonymous bool
+	options   []*uciOption
+}
+
+type uciOption struct {
+	name   string
+	list   bool
+	values []string
+}
+
+var errNotFound = errors.New("Entry not found")
+var errInvalid = errors.New("Invalid argument")
+
+func NewUci() *Uci {
+	return &Uci{committed: make(map[string]*uciPackage), staged: make(map[string]*uciPackage),
+		changes: make(map[string][]string)}
+}
+
+// Load replaces committed and staged content of the package with the configuration in uci file format.
+func (this *Uci) Load(pkg, text string) error {
+	parsed, err := this.parse(text)
+	if err != nil {
+		return fmt.Errorf("uci package %s: %w", pkg, err)
+	}
+	this.committed[pkg] = parsed
+	this.staged[pkg] = parsed.clone()
+	delete(this.changes, pkg)
+	return nil
+}
+
+// Get returns committed value of an option or type of a section, list values are joined with space.
+func (this *Uci) Get(path string) (string, bool) {
+	return this.get(this.committed, path)
+}
+
+// GetStaged returns value as Get does, but including changes which are not committed yet.
+func (this *Uci) GetStaged(path string) (string, bool) {
+	return this.get(this.staged, path)
+}
+
+// Changes returns uncommitted changes of the package in format of uci changes.
+func (this *Uci) Changes(pkg string) []string {
+	return append([]string(nil), this.changes[pkg]...)
+}
+
+// Export returns committed package in uci file format.
+func (this *Uci) Export(pkg string) string {
+	return this.export(pkg, this.committed[pkg])
+}
+
+// Packages returns names of known packages in alphabetical order.
+func (this *Uci) Packages() []string {
+	names := make([]string, 0, len(this.committed))
+	for name := range this.committed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (this *Uci) get(state map[string]*uciPackage, path string) (string, bool) {
+	pkgName, section, option, _ := splitUciPath(path)
+	pkg := state[pkgName]
+	if pkg == nil {
+		return "", false
+	}
+	sec := pkg.find(section)
+	if sec == nil {
+		return "", false
+	}
+	if option == "" {
+		return sec.kind, true
+	}
+	opt := sec.find(option)
+	if opt == nil {
+		return "", false
+	}
+	return strings.Join(opt.values, " "), true
+}
+
+// run executes uci command line arguments (without "uci" itself) as uci utility does.
+func (this *Uci) run(args []string, stdout, stderr io.Writer) int {
+	quiet := false
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		quiet = quiet || args[0] == "-q"
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		fmt.Fprintln(stderr, "Usage: uci [<options>] <command> [<arguments>]")
+		return 255
+	}
+	err := this.command(args[0], args[1:], stdout)
+	if err != nil {
+		if !quiet {
+			fmt.Fprintln(stderr, "uci: "+err.Error())
+		}
+		return 1
+	}
+	return 0
+}
+
+func (this *Uci) command(name string, args []string, stdout io.Writer) error {
+	switch name {
+	case "set", "add_list", "del_list":
+		if len(args) != 1 {
+			return errInvalid
+		}
+		return this.set(name, args[0])
+	case "delete":
+		if len(args) != 1 {
+			return errInvalid
+		}
+		return this.delete(args[0])
+	case "add":
+		if len(args) != 2 {
+			return errInvalid
+		}
+		return this.add(args[0], args[1], stdout)
+	case "get":
+		if len(args) != 1 {
+			return errInvalid
+		}
+		value, ok := this.get(this.staged, args[0])
+		if !ok {
+			return errNotFound
+		}
+		fmt.Fprintln(stdout, value)
+	case "show":
+		return this.show(args, stdout)
+	case "export":
+		for _, pkg := range this.selectPackages(args) {
+			if this.staged[pkg] == nil {
+				return errNotFound
+			}
+			io.WriteString(stdout, this.export(pkg, this.staged[pkg]))
+		}
+	case "changes":
+		for _, pkg := range this.selectPackages(args) {
+			for _, change := range this.changes[pkg] {
+				fmt.Fprintln(stdout, change)
+			}
+		}
+	case "commit":
+		for _, pkg := range this.selectPackages(args) {
+			if this.staged[pkg] == nil {
+				return errNotFound
+			}
+			this.committed[pkg] = this.staged[pkg].clone()
+			delete(this.changes, pkg)
+		}
+	case "revert":
+		for _, pkg := range this.selectPackages(args) {
+			pkgName, _, _, _ := splitUciPath(pkg)
+			if this.committed[pkgName] == nil {
+				return errNotFound
+			}
+			this.staged[pkgName] = this.committed[pkgName].clone()
+			delete(this.changes, pkgName)
+		}
+	default:
+		return errInvalid
+	}
+	return nil
+}
+
+func (this *Uci) selectPackages(args []string) []string {
+	if len(args) > 0 {
+		return args
+	}
+	return this.Packages()
+}
+
+func (this *Uci) set(command, assignment string) error {
+	path, value, hasValue := strings.Cut(assignment, "=")
+	pkgName, section, option, err := splitUciPath(path)
+	if err != nil || !hasValue || section == "" {
+		return errInvalid
+	}
+	pkg := this.staged[pkgName]
+	if pkg == nil {
+		return errNotFound
+	}
+	sec := pkg.find(section)
+	change := pkgName + "." + section
+	if option == "" {
+		if command != "set" || !validName(section) || !validName(value) {
+			return errInvalid
+		}
+		if sec == nil {
+			pkg.sections = append(pkg.sections, &uciSection{name: section, kind: value})
+		} else {
+			sec.kind = value
+		}
+		this.record(pkgName, change+"="+quoteUci(value))
+		return nil
+	}
+	if sec == nil {
+		return errNotFound
+	}
+	if !validName(option) {
+		return errInvalid
+	}
+	change += "." + option
+	opt := sec.find(option)
+	switch command {
+	case "set":
+		if opt == nil {
+			opt = &uciOption{name: option}
+			sec.options = append(sec.options, opt)
+		}
+		opt.list = false
+		opt.values = []string{value}
+		this.record(pkgName, change+"="+quoteUci(value))
+	case "add_list":
+		if opt == nil {
+			opt = &uciOption{name: option, list: true}
+			sec.options = append(sec.options, opt)
+		}
+		opt.list = true
+		opt.values = append(opt.values, value)
+		this.record(pkgName, change+"+="+quoteUci(value))
+	case "del_list":
+		if opt == nil || !opt.list {
+			return errNotFound
+		}
+		kept := opt.values[:0]
+		for _, v := range opt.values {
+			if v != value {
+				kept = append(kept, v)
+			}
+		}
+		opt.values = kept
+		this.record(pkgName, change+"-="+quoteUci(value))
+	}
+	return nil
+}
+
+func (this *Uci) delete(path string) error {
+	pkgName, section, option, err := splitUciPath(path)
+	if err != nil || section == "" {
+		return errInvalid
+	}
+	pkg := this.staged[pkgName]
+	if pkg == nil {
+		return errNotFound
+	}
+	sec := pkg.find(section)
+	if sec == nil {
+		return errNotFound
+	}
+	if option == "" {
+		for i, s := range pkg.sections {
+			if s == sec {
+				pkg.sections = append(pkg.sections[:i], pkg.sections[i+1:]...)
+				break
+			}
+		}
+		this.record(pkgName, "-"+pkgName+"."+section)
+		return nil
+	}
+	for i, opt := range sec.options {
+		if opt.name == option {
+			sec.options = append(sec.options[:i], sec.options[i+1:]...)
+			this.record(pkgName, "-"+pkgName+"."+section+"."+option)
+			return nil
+		}
+	}
+	return errNotFound
+}
+
+func (this *Uci) add(pkgName, kind string, stdout io.Writer) error {
+	pkg := this.staged[pkgName]
+	if pkg == nil {
+		return errNotFound
+	}
+	if !validName(kind) {
+		return errInvalid
+	}
+	this.anonymous++
+	name := fmt.Sprintf("cfg%06x", this.anonymous)
+	pkg.sections = append(pkg.sections, &uciSection{name: name, kind: kind, anonymous: true})
+	this.record(pkgName, pkgName+"."+name+"="+quoteUci(kind))
+	fmt.Fprintln(stdout, name)
+	return nil
+}
+
+func (this *Uci) show(args []string, stdout io.Writer) error {
+	selected := this.selectPackages(args)
+	for _, arg := range selected {
+		pkgName, section, option, err := splitUciPath(arg)
+		if err != nil {
+			return errInvalid
+		}
+		pkg := this.staged[pkgName]
+		if pkg == nil {
+			return errNotFound
+		}
+		found := false
+		for i, sec := range pkg.sections {
+			ref := sec.name
+			if sec.anonymous {
+				ref = fmt.Sprintf("@%s[%d]", sec.kind, pkg.indexOfKind(i))
+			}
+			if section != "" && pkg.find(section) != sec {
+				continue
+			}
+			found = true
+			if option == "" {
+				fmt.Fprintf(stdout, "%s.%s=%s\n", pkgName, ref, sec.kind)
+			}
+			for _, opt := range sec.options {
+				if option != "" && opt.name != option {
+					continue
+				}
+				values := make([]string, len(opt.values))
+				for j, v := range opt.values {
+					values[j] = quoteUci(v)
+				}
+				fmt.Fprintf(stdout, "%s.%s.%s=%s\n", pkgName, ref, opt.name, strings.Join(values, " "))
+			}
+		}
+		if section != "" && !found {
+			return errNotFound
+		}
+	}
+	return nil
+}
+
+func (this *Uci) record(pkg, change string) {
+	this.changes[pkg] = append(this.changes[pkg], change)
+}
+
+func (this *Uci) export(pkgName string, pkg *uciPackage) string {
+	if pkg == nil {
+		return ""
+	}
+	sb := new(strings.Builder)
+	sb.WriteString("package " + pkgName + "\n\n")
+	for _, sec := range pkg.sections {
+		sb.WriteString("config " + sec.kind)
+		if !sec.anonymous {
+			sb.WriteString(" " + quoteUci(sec.name))
+		}
+		sb.WriteByte('\n')
+		for _, opt := range sec.options {
+			keyword := "option"
+			if opt.list {
+				keyword = "list"
+			}
+			for _, value := range opt.values {
+				fmt.Fprintf(sb, "\t%s %s %s\n", keyword, opt.name, quoteUci(value))
+			}
+		}
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+// parse reads uci file format: config, option and list statements, comments and package statement.
+func (this *Uci) parse(text string) (*uciPackage, error) {
+	pkg := new(uciPackage)
+	var sec *uciSection
+	for n, line := range strings.Split(text, "\n") {
+		words, err := splitUciWords(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n+1, err)
+		}
+		if len(words) == 0 {
+			continue
+		}
+		switch {
+		case words[0] == "package":
+		case words[0] == "config" && (len(words) == 2 || len(words) == 3):
+			sec = &uciSection{kind: words[1]}
+			if len(words) == 3 {
+				sec.name = words[2]
+			} else {
+				this.anonymous++
+				sec.name = fmt.Sprintf("cfg%06x", this.anonymous)
+				sec.anonymous = true
+			}
+			pkg.sections = append(pkg.sections, sec)
+		case (words[0] == "option" || words[0] == "list") && len(words) == 3 && sec != nil:
+			opt := sec.find(words[1])
+			if opt == nil || words[0] == "option" {
+				if opt == nil {
+					opt = &uciOption{name: words[1]}
+					sec.options = append(sec.options, opt)
+				}
+				opt.values = nil
+			}
+			opt.list = words[0] == "list"
+			opt.values = append(opt.values, words[2])
+		default:
+			return nil, fmt.Errorf("line %d: invalid statement \"%s\"", n+1, strings.TrimSpace(line))
+		}
+	}
+	return pkg, nil
+}
+
+func (this *uciPackage) clone() *uciPackage {
+	clone := &uciPackage{sections: make([]*uciSection, len(this.sections))}
+	for i, sec := range this.sections {
+		secClone := *sec
+		secClone.options = make([]*uciOption, len(sec.options))
+		for j, opt := range sec.options {
+			optClone := *opt
+			optClone.values = append([]string(nil), opt.values...)
+			secClone.options[j] = &optClone
+		}
+		clone.sections[i] = &secClone
+	}
+	return clone
+}
+
+// find looks a section up by name or by @type[index] reference, negative index counts from the end.
+func (this *uciPackage) find(ref string) *uciSection {
+	if strings.HasPrefix(ref, "@") && strings.HasSuffix(ref, "]") {
+		kind, index, ok := strings.Cut(ref[1:len(ref)-1], "[")
+		i, err := strconv.Atoi(index)
+		if !ok || err != nil {
+			return nil
+		}
+		var matching []*uciSection
+		for _, sec := range this.sections {
+			if sec.kind == kind {
+				matching = append(matching, sec)
+			}
+		}
+		if i < 0 {
+			i += len(matching)
+		}
+		if i < 0 || i >= len(matching) {
+			return nil
+		}
+		return matching[i]
+	}
+	for _, sec := range this.sections {
+		if sec.name == ref {
+			return sec
+		}
+	}
+	return nil
+}
+
+// indexOfKind returns index of i-th section among sections of the same type.
+func (this *uciPackage) indexOfKind(i int) int {
+	index := 0
+	for _, sec := range this.sections[:i] {
+		if sec.kind == this.sections[i].kind {
+			index++
+		}
+	}
+	return index
+}
+
+func (this *uciSection) find(name string) *uciOption {
+	for _, opt := range this.options {
+		if opt.name == name {
+			return opt
+		}
+	}
+	return nil
+}
+
+// splitUciPath splits package.section.option path, section reference may contain dots inside brackets only.
+func splitUciPath(path string) (string, string, string, error) {
+	parts := strings.SplitN(path, ".", 3)
+	if parts[0] == "" {
+		return "", "", "", errInvalid
+	}
+	for len(parts) < 3 {
+		parts = append(parts, "")
+	}
+	return parts[0], parts[1], parts[2], nil
+}
+
+func validName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if r != '_' && r != '-' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func quoteUci(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// splitUciWords splits a line of uci file into words, removing quotes and comments.
+func splitUciWords(line string) ([]string, error) {
+	var words []string
+	word := new(strings.Builder)
+	inWord := false
+	quote := rune(0)
+	for _, r := range line {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			word.WriteRune(r)
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == '#' && !inWord:
+			return words, nil
+		case unicode.IsSpace(r):
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, errors.New("unterminated quote")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
@@ -0,0 +1,132 @@
+package sshclient
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/pkg/sftp"
+	"io"
+	"os"
+	"strings"
+	"wnetctl/uci"
+)
+
+// uploadSuffix marks a file being uploaded, it is renamed to the target path once verified.
+const uploadSuffix = ".wnetctl-upload"
+
+// defaultFileMode is set to uploaded files unless UploadFile.Mode is set.
+const defaultFileMode os.FileMode = 0644
+
+// UploadFile describes a file to put on a host. Owner and Group are user and group names or ids,
+// ownership is not changed when they are empty.
+type UploadFile struct {
+	Path    string
+	Content io.Reader
+	Mode    os.FileMode
+	Owner   string
+	Group   string
+}
+
+// ChecksumError reports uploaded file which content differs from the source.
+type ChecksumError struct {
+	Path     string
+	Expected string
+	Actual   string
+}
+
+func (this *ChecksumError) Error() string {
+	return fmt.Sprintf("Checksum of uploaded %s is %s, expected %s", this.Path, this.Actual, this.Expected)
+}
+
+// errNoSftp means the host has no SFTP subsystem, i.e. dropbear image without sftp-server.
+var errNoSftp = errors.New("SFTP is not available")
+
+// Upload transfers the file over SFTP, or with cat when the host has no SFTP server. Content is written
+// to a temporary file next to the target, which gets mode and owner set and SHA-256 checksum verified
+// before it replaces the target, so a failed upload leaves the target intact.
+func (this *sshClient) Upload(ctx context.Context, file *UploadFile) error {
+	if this.client == nil {
+		return errors.New("Not connected to " + this.ip)
+	}
+	temp := file.Path + uploadSuffix
+	hash := sha256.New()
+	content := io.TeeReader(file.Content, hash)
+	err := this.uploadSftp(ctx, temp, content)
+	if errors.Is(err, errNoSftp) {
+		_, err = this.run(ctx, "cat > "+uci.Quote(temp), content)
+	}
+	if err != nil {
+		this.Execute(context.WithoutCancel(ctx), "rm -f "+uci.Quote(temp))
+		return fmt.Errorf("Upload of %s failed: %w", file.Path, err)
+	}
+	if err = this.finishUpload(ctx, file, temp, hex.EncodeToString(hash.Sum(nil))); err != nil {
+		this.Execute(context.WithoutCancel(ctx), "rm -f "+uci.Quote(temp))
+		return err
+	}
+	return nil
+}
+
+func (this *sshClient) uploadSftp(ctx context.Context, remotePath string, content io.Reader) error {
+	client, err := sftp.NewClient(this.client)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errNoSftp, err)
+	}
+	stop := context.AfterFunc(ctx, func() {
+		client.Close()
+	})
+	defer stop()
+	defer client.Close()
+	out, err := client.Create(remotePath)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, content)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// finishUpload sets mode and owner of uploaded temporary file, verifies its checksum and moves it to the target path.
+func (this *sshClient) finishUpload(ctx context.Context, file *UploadFile, temp, checksum string) error {
+	mode := file.Mode
+	if mode == 0 {
+		mode = defaultFileMode
+	}
+	commands := []string{fmt.Sprintf("chmod %04o %s", mode.Perm(), uci.Quote(temp))}
+	if owner := ownerSpec(file.Owner, file.Group); owner != "" {
+		commands = append(commands, "chown "+owner+" "+uci.Quote(temp))
+	}
+	if _, err := this.RunCommands(ctx, commands); err != nil {
+		return err
+	}
+	result, err := this.Run(ctx, "sha256sum "+uci.Quote(temp))
+	if err != nil {
+		return err
+	}
+	if fields := strings.Fields(result.Stdout); len(fields) == 0 || fields[0] != checksum {
+		actual := ""
+		if len(fields) > 0 {
+			actual = fields[0]
+		}
+		return &ChecksumError{Path: file.Path, Expected: checksum, Actual: actual}
+	}
+	return this.Execute(ctx, "mv -f "+uci.Quote(temp)+" "+uci.Quote(file.Path))
+}
+
+func ownerSpec(owner, group string) string {
+	switch {
+	case owner != "" && group != "":
+		return uci.Quote(owner + ":" + group)
+	case owner != "":
+		return uci.Quote(owner)
+	case group != "":
+		return uci.Quote(":" + group)
+	}
+	return ""
+}
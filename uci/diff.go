@@ -0,0 +1,370 @@
+package uci
+
+import (
+	"errors"
+	"slices"
+	"strings"
+)
+
+// Command is a uci command changing configuration. Package, Section and Option form the path of the command,
+// Section is a name or @type[index] reference. Add command has no section and takes section type as Value.
+type Command struct {
+	Verb    string
+	Package string
+	Section string
+	Option  string
+	Value   string
+}
+
+const (
+	VerbSet     = "set"
+	VerbAddList = "add_list"
+	VerbDelList = "del_list"
+	VerbDelete  = "delete"
+	VerbAdd     = "add"
+)
+
+// Path returns package.section.option path the command applies to.
+func (this *Command) Path() string {
+	path := this.Package
+	if this.Section != "" {
+		path += "." + this.Section
+	}
+	if this.Option != "" {
+		path += "." + this.Option
+	}
+	return path
+}
+
+// String formats the command as a line of uci batch input, it is also valid arguments of uci utility in shell.
+func (this *Command) String() string {
+	switch {
+	case this.Verb == VerbAdd:
+		return VerbAdd + " " + this.Package + " " + this.Value
+	case this.Verb == VerbDelete:
+		return VerbDelete + " " + this.Path()
+	case this.Option == "":
+		// section type is an identifier, uci writes it without quotes
+		return this.Verb + " " + this.Path() + "=" + this.Value
+	default:
+		return this.Verb + " " + this.Path() + "=" + Quote(this.Value)
+	}
+}
+
+// Args returns arguments of uci utility executing the command.
+func (this *Command) Args() []string {
+	switch {
+	case this.Verb == VerbAdd:
+		return []string{VerbAdd, this.Package, this.Value}
+	case this.Verb == VerbDelete:
+		return []string{VerbDelete, this.Path()}
+	default:
+		return []string{this.Verb, this.Path() + "=" + this.Value}
+	}
+}
+
+// ParseCommand parses a line of uci batch input.
+func ParseCommand(line string) (*Command, error) {
+	words, err := splitWords(line)
+	if err != nil {
+		return nil, err
+	}
+	if len(words) == 3 && words[0] == VerbAdd {
+		return &Command{Verb: VerbAdd, Package: words[1], Value: words[2]}, nil
+	}
+	if len(words) != 2 {
+		return nil, errors.New("Invalid uci command: " + line)
+	}
+	command := &Command{Verb: words[0]}
+	path, value, assigned := strings.Cut(words[1], "=")
+	parts := strings.SplitN(path, ".", 3)
+	switch command.Verb {
+	case VerbSet, VerbAddList, VerbDelList:
+		if !assigned || len(parts) < 2 || command.Verb != VerbSet && len(parts) < 3 {
+			return nil, errors.New("Invalid uci command: " + line)
+		}
+	case VerbDelete:
+		if assigned || len(parts) < 2 {
+			return nil, errors.New("Invalid uci command: " + line)
+		}
+	default:
+		return nil, errors.New("Unsupported uci command: " + line)
+	}
+	command.Package, command.Section, command.Value = parts[0], parts[1], value
+	if len(parts) == 3 {
+		command.Option = parts[2]
+	}
+	return command, nil
+}
+
+// Batch formats commands as uci batch input.
+func Batch(commands []*Command) string {
+	sb := new(strings.Builder)
+	for _, command := range commands {
+		sb.WriteString(command.String())
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+// Diff returns commands which turn configuration of the package from into the package to, nil from stands for
+// an empty package. Named sections are matched by name, anonymous ones by position among anonymous sections
+// of the same type. Sections are deleted first, then changed, then new ones are added to the end, order of
+// existing sections and options is not reconciled.
+func Diff(from, to *Package) []*Command {
+	if from == nil {
+		from = NewPackage(to.Name)
+	}
+	d := &differ{pkg: to.Name, current: slices.Clone(from.Sections), types: make(map[*Section]string)}
+	pairs := match(from, to)
+	// sections are deleted from the end, so that indices of remaining anonymous sections do not change
+	for _, old := range slices.Backward(from.Sections) {
+		if pairs[old] == nil {
+			d.deleteSection(old)
+		}
+	}
+	matched := make(map[*Section]bool)
+	for _, old := range from.Sections {
+		if section := pairs[old]; section != nil {
+			matched[section] = true
+			d.changeSection(old, section)
+		}
+	}
+	for _, section := range to.Sections {
+		if !matched[section] {
+			d.addSection(section)
+		}
+	}
+	return d.commands
+}
+
+// match pairs sections of from with sections of to: named sections by name, anonymous ones by position
+// among anonymous sections of the same type.
+func match(from, to *Package) map[*Section]*Section {
+	pairs := make(map[*Section]*Section)
+	for _, section := range to.Sections {
+		if section.Name != "" {
+			if old := from.Section(section.Name); old != nil {
+				pairs[old] = section
+			}
+		}
+	}
+	anonymous := anonymousByType(from)
+	for kind, sections := range anonymousByType(to) {
+		for i, old := range anonymous[kind] {
+			if i < len(sections) {
+				pairs[old] = sections[i]
+			}
+		}
+	}
+	return pairs
+}
+
+func anonymousByType(pkg *Package) map[string][]*Section {
+	sections := make(map[string][]*Section)
+	for _, section := range pkg.Sections {
+		if section.Name == "" {
+			sections[section.Type] = append(sections[section.Type], section)
+		}
+	}
+	return sections
+}
+
+// differ collects commands while tracking sections of the package as they are changed by the commands,
+// so that anonymous sections are referenced by their index at the time a command runs.
+type differ struct {
+	pkg      string
+	current  []*Section
+	types    map[*Section]string
+	commands []*Command
+}
+
+func (this *differ) typeOf(section *Section) string {
+	if kind, ok := this.types[section]; ok {
+		return kind
+	}
+	return section.Type
+}
+
+func (this *differ) ref(section *Section) string {
+	if section.Name != "" {
+		return section.Name
+	}
+	index := 0
+	for _, s := range this.current {
+		if s == section {
+			break
+		}
+		if this.typeOf(s) == section.Type {
+			index++
+		}
+	}
+	return anonymousRef(section.Type, index)
+}
+
+func (this *differ) add(verb, section, option, value string) {
+	this.commands = append(this.commands,
+		&Command{Verb: verb, Package: this.pkg, Section: section, Option: option, Value: value})
+}
+
+func (this *differ) deleteSection(section *Section) {
+	this.add(VerbDelete, this.ref(section), "", "")
+	this.current = slices.DeleteFunc(this.current, func(s *Section) bool { return s == section })
+}
+
+func (this *differ) changeSection(old, section *Section) {
+	ref := this.ref(old)
+	if old.Type != section.Type {
+		// uci keeps options of a section when its type is set
+		this.add(VerbSet, ref, "", section.Type)
+		this.types[old] = section.Type
+	}
+	for _, option := range old.Options {
+		if section.Option(option.Name) == nil {
+			this.add(VerbDelete, ref, option.Name, "")
+		}
+	}
+	for _, option := range section.Options {
+		this.changeOption(ref, old.Option(option.Name), option)
+	}
+}
+
+func (this *differ) addSection(section *Section) {
+	ref := section.Name
+	if ref == "" {
+		this.add(VerbAdd, "", "", section.Type)
+		ref = anonymousRef(section.Type, -1)
+	} else {
+		this.add(VerbSet, ref, "", section.Type)
+	}
+	this.current = append(this.current, section)
+	for _, option := range section.Options {
+		this.changeOption(ref, nil, option)
+	}
+}
+
+// changeOption turns option old, which may be nil, into option. Values are appended to and removed from
+// lists when it is enough, otherwise the list is replaced.
+func (this *differ) changeOption(ref string, old, option *Option) {
+	if old != nil && old.equal(option) {
+		return
+	}
+	if !option.List {
+		this.add(VerbSet, ref, option.Name, option.Value())
+		return
+	}
+	if old != nil && old.List {
+		kept := slices.DeleteFunc(slices.Clone(old.Values), func(v string) bool { return !slices.Contains(option.Values, v) })
+		if len(kept) <= len(option.Values) && slices.Equal(kept, option.Values[:len(kept)]) {
+			var removed []string
+			for _, value := range old.Values {
+				if !slices.Contains(option.Values, value) && !slices.Contains(removed, value) {
+					removed = append(removed, value)
+					this.add(VerbDelList, ref, option.Name, value)
+				}
+			}
+			for _, value := range option.Values[len(kept):] {
+				this.add(VerbAddList, ref, option.Name, value)
+			}
+			return
+		}
+	}
+	if old != nil {
+		this.add(VerbDelete, ref, option.Name, "")
+	}
+	for _, value := range option.Values {
+		this.add(VerbAddList, ref, option.Name, value)
+	}
+}
+
+// Kinds of changes between packages.
+const (
+	Added    = "add"
+	Modified = "change"
+	Removed  = "remove"
+)
+
+// Change is a section or an option which differs between two packages. Values of sections are their types,
+// values of options are quoted, list values are separated with space. Old value of added and new value
+// of removed entries are empty.
+type Change struct {
+	Kind string
+	Path string
+	Old  string
+	New  string
+}
+
+// Changes compares packages section by section, sections are matched the same way Diff does. Removed sections
+// go first in order of from, followed by changed and added sections in order of to. Options of added
+// and removed sections are reported along with the section.
+func Changes(from, to *Package) []*Change {
+	if from == nil {
+		from = NewPackage(to.Name)
+	}
+	pairs := match(from, to)
+	// sections of to paired with sections of from
+	origins := make(map[*Section]*Section)
+	for old, section := range pairs {
+		origins[section] = old
+	}
+	var changes []*Change
+	for _, old := range from.Sections {
+		if pairs[old] == nil {
+			changes = appendSection(changes, Removed, from.Name+"."+from.Ref(old), old)
+		}
+	}
+	for _, section := range to.Sections {
+		path := to.Name + "." + to.Ref(section)
+		old := origins[section]
+		if old == nil {
+			changes = appendSection(changes, Added, path, section)
+			continue
+		}
+		if old.Type != section.Type {
+			changes = append(changes, &Change{Kind: Modified, Path: path, Old: old.Type, New: section.Type})
+		}
+		for _, option := range section.Options {
+			if oldOption := old.Option(option.Name); oldOption == nil {
+				changes = append(changes, &Change{Kind: Added, Path: path + "." + option.Name, New: option.text()})
+			} else if !oldOption.equal(option) {
+				changes = append(changes, &Change{Kind: Modified, Path: path + "." + option.Name,
+					Old: oldOption.text(), New: option.text()})
+			}
+		}
+		for _, option := range old.Options {
+			if section.Option(option.Name) == nil {
+				changes = append(changes, &Change{Kind: Removed, Path: path + "." + option.Name, Old: option.text()})
+			}
+		}
+	}
+	return changes
+}
+
+func appendSection(changes []*Change, kind, path string, section *Section) []*Change {
+	change := &Change{Kind: kind, Path: path}
+	if kind == Added {
+		change.New = section.Type
+	} else {
+		change.Old = section.Type
+	}
+	changes = append(changes, change)
+	for _, option := range section.Options {
+		change := &Change{Kind: kind, Path: path + "." + option.Name}
+		if kind == Added {
+			change.New = option.text()
+		} else {
+			change.Old = option.text()
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// text returns quoted values of the option separated with space.
+func (this *Option) text() string {
+	quoted := make([]string, len(this.Values))
+	for i, value := range this.Values {
+		quoted[i] = Quote(value)
+	}
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,235 @@
+package uci
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// ParseError reports a statement of uci file which cannot be parsed, Line is 1-based.
+type ParseError struct {
+	Package string
+	Line    int
+	Err     error
+}
+
+var errUnterminatedQuote = errors.New("Unterminated quote")
+var errUnterminatedEscape = errors.New("Unterminated escape")
+
+func (this *ParseError) Error() string {
+	return fmt.Sprintf("Invalid uci configuration %s, line %d: %s", this.Package, this.Line, this.Err.Error())
+}
+
+func (this *ParseError) Unwrap() error {
+	return this.Err
+}
+
+// Parse reads a configuration file of the package. Package statement of the file, if any, is not checked
+// against the name. Repeated statements of an option are grouped under its first statement.
+func Parse(name string, r io.Reader) (*Package, error) {
+	packages, err := parse(name, r, false)
+	if err != nil {
+		return nil, err
+	}
+	return packages[0], nil
+}
+
+// ParseExport reads output of uci export: packages each starting with a package statement.
+func ParseExport(r io.Reader) ([]*Package, error) {
+	return parse("", r, true)
+}
+
+// parser builds packages statement by statement, keeping lines which are not statements until the next one.
+type parser struct {
+	packages []*Package
+	pkg      *Package
+	section  *Section
+	pending  []string
+}
+
+func parse(name string, r io.Reader, export bool) ([]*Package, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	p := new(parser)
+	if !export {
+		p.start(name)
+	}
+	if len(data) == 0 {
+		return p.finish(false), nil
+	}
+	text := string(data)
+	noEOL := !strings.HasSuffix(text, "\n")
+	text = strings.TrimSuffix(text, "\n")
+	lines := strings.Split(text, "\n")
+	for n := 0; n < len(lines); n++ {
+		start, statement := n, lines[n]
+		err := p.statement(statement)
+		// a quoted value continues on the next line, e.g. a multi-line value of uci export
+		for errors.Is(err, errUnterminatedQuote) && n+1 < len(lines) {
+			n++
+			statement += "\n" + lines[n]
+			err = p.statement(statement)
+		}
+		if err != nil {
+			pkgName := name
+			if p.pkg != nil {
+				pkgName = p.pkg.Name
+			}
+			return nil, &ParseError{Package: pkgName, Line: start + 1, Err: err}
+		}
+	}
+	return p.finish(noEOL), nil
+}
+
+func (this *parser) statement(line string) error {
+	words, err := splitWords(line)
+	if err != nil {
+		return err
+	}
+	if len(words) == 0 {
+		this.pending = append(this.pending, line)
+		return nil
+	}
+	switch words[0] {
+	case "package":
+		if len(words) != 2 {
+			return errors.New("Package statement requires a name")
+		}
+		if this.pkg != nil && (len(this.pkg.Sections) > 0 || len(this.pkg.header) > 0) {
+			this.finishPackage()
+			this.start(words[1])
+		} else if this.pkg == nil {
+			this.start(words[1])
+		}
+		this.pkg.Name = words[1]
+		this.pkg.header = append(this.pkg.header, this.pending...)
+		this.pkg.header = append(this.pkg.header, line)
+		this.pending = nil
+	case "config":
+		if len(words) != 2 && len(words) != 3 {
+			return errors.New("Config statement requires a type and an optional name")
+		}
+		if this.pkg == nil {
+			return errors.New("Config statement outside of a package")
+		}
+		this.section = &Section{Type: words[1]}
+		if len(words) == 3 {
+			this.section.Name = words[2]
+		}
+		this.section.source = &sectionSource{leading: this.pending, line: line, kind: this.section.Type,
+			name: this.section.Name}
+		this.pending = nil
+		this.pkg.Sections = append(this.pkg.Sections, this.section)
+	case "option", "list":
+		if len(words) != 3 {
+			return fmt.Errorf("Statement %s requires a name and a value", words[0])
+		}
+		if this.section == nil {
+			return fmt.Errorf("Statement %s outside of a section", words[0])
+		}
+		this.option(words[0] == "list", words[1], words[2], line)
+	default:
+		return fmt.Errorf("Unknown statement %s", words[0])
+	}
+	return nil
+}
+
+// option adds a statement to the current section, an option statement replaces previous values
+// while a list statement appends to them.
+func (this *parser) option(list bool, name, value, line string) {
+	option := this.section.Option(name)
+	if option == nil {
+		option = &Option{Name: name, source: &optionSource{leading: this.pending}}
+		this.section.Options = append(this.section.Options, option)
+	} else {
+		option.source.lines = append(option.source.lines, this.pending...)
+	}
+	this.pending = nil
+	if !list || !option.List {
+		option.Values = nil
+	}
+	option.List = list
+	option.Values = append(option.Values, value)
+	option.source.lines = append(option.source.lines, line)
+	option.source.list = list
+	option.source.values = slices.Clone(option.Values)
+}
+
+func (this *parser) start(name string) {
+	this.pkg = &Package{Name: name, header: this.pending}
+	this.pending = nil
+	this.section = nil
+}
+
+func (this *parser) finishPackage() {
+	if len(this.pkg.Sections) == 0 {
+		this.pkg.header = append(this.pkg.header, this.pending...)
+	} else {
+		this.pkg.trailer = this.pending
+	}
+	this.pending = nil
+	this.packages = append(this.packages, this.pkg)
+}
+
+func (this *parser) finish(noEOL bool) []*Package {
+	if this.pkg != nil {
+		this.pkg.noEOL = noEOL
+		this.finishPackage()
+	}
+	return this.packages
+}
+
+// splitWords splits a line of uci file into words, removing quotes, escapes and comments.
+func splitWords(line string) ([]string, error) {
+	var words []string
+	word := new(strings.Builder)
+	inWord := false
+	quote := rune(0)
+	escaped := false
+	for _, r := range line {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inWord = true
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			word.WriteRune(r)
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == '#':
+			if inWord {
+				words = append(words, word.String())
+			}
+			return words, nil
+		case unicode.IsSpace(r):
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, errUnterminatedQuote
+	}
+	if escaped {
+		return nil, errUnterminatedEscape
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
@@ -0,0 +1,324 @@
+package uci
+
+import (
+	"errors"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Package is a uci configuration file, e.g. /etc/config/wireless. Package parsed from a file remembers its text:
+// comments, blank lines, quoting and indentation of statements which are not changed are written back as they were.
+type Package struct {
+	Name     string
+	Sections []*Section
+	// lines before the first section and after the last one
+	header  []string
+	trailer []string
+	// file does not end with a newline
+	noEOL bool
+}
+
+// Section is a config statement along with its options, Name is empty for anonymous sections.
+type Section struct {
+	Type    string
+	Name    string
+	Options []*Option
+	source  *sectionSource
+}
+
+// Option is an option or list statement, list options may have any number of values, other ones have exactly one.
+type Option struct {
+	Name   string
+	List   bool
+	Values []string
+	source *optionSource
+}
+
+// sectionSource is the text a section was parsed from.
+type sectionSource struct {
+	leading []string
+	line    string
+	kind    string
+	name    string
+}
+
+// optionSource is the text an option was parsed from, lines contain all statements of the option.
+type optionSource struct {
+	leading []string
+	lines   []string
+	list    bool
+	values  []string
+}
+
+// ErrNotFound is returned by Apply for commands referring to missing sections or options, as uci reports it.
+var ErrNotFound = errors.New("Entry not found")
+
+// NewPackage creates an empty package, which is written the way uci itself writes configuration files.
+func NewPackage(name string) *Package {
+	return &Package{Name: name, trailer: []string{""}}
+}
+
+// Section returns a named section or nil.
+func (this *Package) Section(name string) *Section {
+	for _, section := range this.Sections {
+		if section.Name != "" && section.Name == name {
+			return section
+		}
+	}
+	return nil
+}
+
+// Find looks a section up by a reference: its name or @type[index], negative index counts from the end.
+func (this *Package) Find(ref string) *Section {
+	kind, index, ok := strings.Cut(strings.TrimPrefix(ref, "@"), "[")
+	if !strings.HasPrefix(ref, "@") || !ok || !strings.HasSuffix(index, "]") {
+		return this.Section(ref)
+	}
+	i, err := strconv.Atoi(strings.TrimSuffix(index, "]"))
+	if err != nil {
+		return nil
+	}
+	sections := this.SectionsOfType(kind)
+	if i < 0 {
+		i += len(sections)
+	}
+	if i < 0 || i >= len(sections) {
+		return nil
+	}
+	return sections[i]
+}
+
+// SectionsOfType returns named and anonymous sections of the type in order of the package.
+func (this *Package) SectionsOfType(kind string) []*Section {
+	var sections []*Section
+	for _, section := range this.Sections {
+		if section.Type == kind {
+			sections = append(sections, section)
+		}
+	}
+	return sections
+}
+
+// Add appends a new section, empty name makes it anonymous. Existing named section is replaced instead.
+func (this *Package) Add(kind, name string) *Section {
+	section := &Section{Type: kind, Name: name}
+	if existing := this.Section(name); existing != nil {
+		*existing = *section
+		return existing
+	}
+	this.Sections = append(this.Sections, section)
+	return section
+}
+
+// Remove deletes the section from the package.
+func (this *Package) Remove(section *Section) {
+	this.Sections = slices.DeleteFunc(this.Sections, func(s *Section) bool { return s == section })
+}
+
+// Clone returns a deep copy of the package, which is written the same way as the package.
+func (this *Package) Clone() *Package {
+	clone := *this
+	clone.Sections = make([]*Section, len(this.Sections))
+	for i, section := range this.Sections {
+		sectionClone := *section
+		sectionClone.Options = make([]*Option, len(section.Options))
+		for j, option := range section.Options {
+			optionClone := *option
+			optionClone.Values = slices.Clone(option.Values)
+			sectionClone.Options[j] = &optionClone
+		}
+		clone.Sections[i] = &sectionClone
+	}
+	return &clone
+}
+
+// Apply changes the package as uci does executing the command.
+func (this *Package) Apply(command *Command) error {
+	if command.Verb == VerbAdd {
+		this.Add(command.Value, "")
+		return nil
+	}
+	section := this.Find(command.Section)
+	if command.Verb == VerbSet && command.Option == "" {
+		if section == nil {
+			this.Add(command.Value, command.Section)
+		} else {
+			section.Type = command.Value
+		}
+		return nil
+	}
+	if section == nil {
+		return ErrNotFound
+	}
+	option := section.Option(command.Option)
+	switch command.Verb {
+	case VerbDelete:
+		if command.Option == "" {
+			this.Remove(section)
+		} else if option != nil {
+			section.Delete(command.Option)
+		} else {
+			return ErrNotFound
+		}
+	case VerbSet:
+		section.Set(command.Option, command.Value)
+	case VerbAddList:
+		// value of an option turns into the first item of the list
+		var values []string
+		if option != nil {
+			values = option.Values
+		}
+		section.SetList(command.Option, append(slices.Clone(values), command.Value)...)
+	case VerbDelList:
+		if option != nil && option.List {
+			section.SetList(command.Option, slices.DeleteFunc(slices.Clone(option.Values),
+				func(value string) bool { return value == command.Value })...)
+		}
+	default:
+		return errors.New("Unsupported uci command " + command.Verb)
+	}
+	return nil
+}
+
+// Ref returns a reference to the section usable in uci paths: its name or @type[index] for anonymous sections.
+func (this *Package) Ref(section *Section) string {
+	if section.Name != "" {
+		return section.Name
+	}
+	return anonymousRef(section.Type, slices.Index(this.SectionsOfType(section.Type), section))
+}
+
+func anonymousRef(kind string, index int) string {
+	return "@" + kind + "[" + strconv.Itoa(index) + "]"
+}
+
+// Option returns the option of the section or nil.
+func (this *Section) Option(name string) *Option {
+	for _, option := range this.Options {
+		if option.Name == name {
+			return option
+		}
+	}
+	return nil
+}
+
+// Get returns value of the option, values of list options are joined with space as uci get does.
+func (this *Section) Get(name string) (string, bool) {
+	option := this.Option(name)
+	if option == nil {
+		return "", false
+	}
+	return strings.Join(option.Values, " "), true
+}
+
+// Set sets a single value option.
+func (this *Section) Set(name, value string) {
+	this.set(name, false, []string{value})
+}
+
+// SetList sets a list option, list with no values is removed.
+func (this *Section) SetList(name string, values ...string) {
+	if len(values) == 0 {
+		this.Delete(name)
+		return
+	}
+	this.set(name, true, values)
+}
+
+func (this *Section) set(name string, list bool, values []string) {
+	if option := this.Option(name); option != nil {
+		option.List = list
+		option.Values = slices.Clone(values)
+		return
+	}
+	this.Options = append(this.Options, &Option{Name: name, List: list, Values: slices.Clone(values)})
+}
+
+// Delete removes the option from the section.
+func (this *Section) Delete(name string) {
+	this.Options = slices.DeleteFunc(this.Options, func(option *Option) bool { return option.Name == name })
+}
+
+// Value returns the value of a single value option or the last value of a list.
+func (this *Option) Value() string {
+	if len(this.Values) == 0 {
+		return ""
+	}
+	return this.Values[len(this.Values)-1]
+}
+
+// equal tells whether two options have the same kind and values.
+func (this *Option) equal(other *Option) bool {
+	return this.List == other.List && slices.Equal(this.Values, other.Values)
+}
+
+// String returns the package in uci file format.
+func (this *Package) String() string {
+	sb := new(strings.Builder)
+	this.WriteTo(sb)
+	return sb.String()
+}
+
+// WriteTo writes the package in uci file format. Statements of sections and options changed since the package
+// was parsed are written in the format of uci, other text is written as it was read.
+func (this *Package) WriteTo(w io.Writer) (int64, error) {
+	var lines []string
+	lines = append(lines, this.header...)
+	for _, section := range this.Sections {
+		lines = section.appendLines(lines)
+	}
+	lines = append(lines, this.trailer...)
+	text := strings.Join(lines, "\n")
+	if !this.noEOL && len(lines) > 0 {
+		text += "\n"
+	}
+	n, err := io.WriteString(w, text)
+	return int64(n), err
+}
+
+func (this *Section) appendLines(lines []string) []string {
+	source := this.source
+	if source == nil {
+		// uci separates sections with a blank line
+		source = &sectionSource{leading: []string{""}}
+	}
+	lines = append(lines, source.leading...)
+	if source.line != "" && source.kind == this.Type && source.name == this.Name {
+		lines = append(lines, source.line)
+	} else {
+		line := "config " + this.Type
+		if this.Name != "" {
+			line += " " + Quote(this.Name)
+		}
+		lines = append(lines, line)
+	}
+	for _, option := range this.Options {
+		lines = option.appendLines(lines)
+	}
+	return lines
+}
+
+func (this *Option) appendLines(lines []string) []string {
+	source := this.source
+	if source != nil {
+		lines = append(lines, source.leading...)
+		if source.list == this.List && slices.Equal(source.values, this.Values) {
+			return append(lines, source.lines...)
+		}
+	}
+	keyword := "option"
+	if this.List {
+		keyword = "list"
+	}
+	for _, value := range this.Values {
+		lines = append(lines, "\t"+keyword+" "+this.Name+" "+Quote(value))
+	}
+	return lines
+}
+
+// Quote puts the value into single quotes the way uci does, it is safe to use in shell commands as well.
+func Quote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
@@ -0,0 +1,376 @@
+package uci
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const wireless = `
+config wifi-device 'radio0'
+	option type 'mac80211'
+	option path 'platform/soc/18000000.wifi'
+	option channel '1'
+	option band '2g'
+	option htmode 'HE20'
+	option cell_density '0'
+
+config wifi-iface 'default_radio0'
+	option device 'radio0'
+	option network 'lan'
+	option mode 'ap'
+	option ssid 'OpenWrt'
+	option encryption 'none'
+
+`
+
+// handwritten file with comments, various quoting and no newline at the end
+const handwritten = `# managed by hand
+package network
+
+config interface loopback   # local
+	option device "lo"
+	option proto static
+	option ipaddr '127.0.0.1'
+
+# bridge
+config device
+    option name 'br-lan'
+    option type "bridge"
+    list ports 'lan1'
+    # second port
+    list ports 'lan2'
+
+config interface 'lan'
+	option device 'br-lan'
+	option  proto 'static'
+	option ipaddr 'it'\''s'
+	option ip6assign "60"  # prefix
+
+config device
+	option name 'br-guest'`
+
+func mustParse(t *testing.T, name, text string) *Package {
+	t.Helper()
+	pkg, err := Parse(name, strings.NewReader(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pkg
+}
+
+func TestParse(t *testing.T) {
+	pkg := mustParse(t, "network", handwritten)
+	if len(pkg.Sections) != 4 {
+		t.Fatalf("parsed %d sections, expected 4", len(pkg.Sections))
+	}
+	loopback := pkg.Section("loopback")
+	if loopback == nil || loopback.Type != "interface" {
+		t.Fatalf("loopback section is %+v", loopback)
+	}
+	if device, _ := loopback.Get("device"); device != "lo" {
+		t.Errorf("loopback device is %q", device)
+	}
+	devices := pkg.SectionsOfType("device")
+	if len(devices) != 2 || devices[0].Name != "" || pkg.Ref(devices[1]) != "@device[1]" {
+		t.Fatalf("anonymous devices are %+v", devices)
+	}
+	ports := devices[0].Option("ports")
+	if ports == nil || !ports.List || !slices.Equal(ports.Values, []string{"lan1", "lan2"}) {
+		t.Errorf("ports are %+v", ports)
+	}
+	lan := pkg.Section("lan")
+	if ipaddr, _ := lan.Get("ipaddr"); ipaddr != "it's" {
+		t.Errorf("escaped value is %q", ipaddr)
+	}
+	if prefix, _ := lan.Get("ip6assign"); prefix != "60" {
+		t.Errorf("value followed by comment is %q", prefix)
+	}
+}
+
+func TestParseError(t *testing.T) {
+	_, err := Parse("network", strings.NewReader("\nconfig interface 'lan'\n\toption proto 'static\n"))
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) || parseErr.Line != 3 || !errors.Is(err, errUnterminatedQuote) {
+		t.Errorf("unexpected error %v", err)
+	}
+	_, err = Parse("network", strings.NewReader("option proto 'static'\n"))
+	if !errors.As(err, &parseErr) || parseErr.Line != 1 {
+		t.Errorf("option outside of section is accepted: %v", err)
+	}
+}
+
+func TestWriteIsLossless(t *testing.T) {
+	for name, text := range map[string]string{"wireless": wireless, "network": handwritten, "empty": "", "blank": "\n"} {
+		if written := mustParse(t, name, text).String(); written != text {
+			t.Errorf("%s is written as\n%s", name, written)
+		}
+	}
+}
+
+func TestWriteChanges(t *testing.T) {
+	pkg := mustParse(t, "network", handwritten)
+	pkg.Section("lan").Set("proto", "dhcp")
+	pkg.SectionsOfType("device")[0].SetList("ports", "lan1", "lan3")
+	pkg.Section("loopback").Delete("ipaddr")
+	guest := pkg.Add("interface", "guest")
+	guest.Set("proto", "none")
+	expected := strings.NewReplacer(
+		"\toption  proto 'static'", "\toption proto 'dhcp'",
+		"    list ports 'lan1'\n    # second port\n    list ports 'lan2'", "\tlist ports 'lan1'\n\tlist ports 'lan3'",
+		"\toption ipaddr '127.0.0.1'\n", "",
+		"'br-guest'", "'br-guest'\n\nconfig interface 'guest'\n\toption proto 'none'",
+	).Replace(handwritten)
+	if written := pkg.String(); written != expected {
+		t.Errorf("package is written as\n%s\nexpected\n%s", written, expected)
+	}
+}
+
+func TestWriteNewPackage(t *testing.T) {
+	pkg := NewPackage("wireless")
+	radio := pkg.Add("wifi-device", "radio0")
+	for _, option := range []string{"type", "path", "channel", "band", "htmode", "cell_density"} {
+		value, _ := mustParse(t, "wireless", wireless).Section("radio0").Get(option)
+		radio.Set(option, value)
+	}
+	iface := pkg.Add("wifi-iface", "default_radio0")
+	for _, option := range []string{"device", "network", "mode", "ssid", "encryption"} {
+		value, _ := mustParse(t, "wireless", wireless).Section("default_radio0").Get(option)
+		iface.Set(option, value)
+	}
+	if written := pkg.String(); written != wireless {
+		t.Errorf("package is written as\n%s", written)
+	}
+}
+
+func TestParseExport(t *testing.T) {
+	text := "package dhcp\n\nconfig dnsmasq\n\toption domain 'lan'\n\npackage wireless\n" + wireless
+	packages, err := ParseExport(strings.NewReader(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packages) != 2 || packages[0].Name != "dhcp" || packages[1].Name != "wireless" {
+		t.Fatalf("parsed packages %+v", packages)
+	}
+	if len(packages[1].Sections) != 2 {
+		t.Errorf("wireless has %d sections", len(packages[1].Sections))
+	}
+	if written := packages[0].String() + packages[1].String(); written != text {
+		t.Errorf("export is written as\n%s", written)
+	}
+}
+
+func TestParseMultiLineValue(t *testing.T) {
+	text := "package uhttpd\n\nconfig cert 'defaults'\n\toption banner 'Welcome\n\n  to the lab'\n" +
+		"\tlist motd \"first\nsecond\"\n\toption days '730'\n"
+	packages, err := ParseExport(strings.NewReader(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	section := packages[0].Section("defaults")
+	if banner, _ := section.Get("banner"); banner != "Welcome\n\n  to the lab" {
+		t.Errorf("multi-line value is %q", banner)
+	}
+	if motd := section.Option("motd"); motd == nil || !slices.Equal(motd.Values, []string{"first\nsecond"}) {
+		t.Errorf("multi-line list is %+v", motd)
+	}
+	if days, _ := section.Get("days"); days != "730" {
+		t.Errorf("value following multi-line values is %q", days)
+	}
+	if written := packages[0].String(); written != text {
+		t.Errorf("package is written as\n%s", written)
+	}
+	section.Set("banner", "Hello\nworld")
+	reparsed := mustParse(t, "uhttpd", packages[0].String())
+	if banner, _ := reparsed.Section("defaults").Get("banner"); banner != "Hello\nworld" {
+		t.Errorf("written multi-line value is parsed as %q", banner)
+	}
+	_, err = Parse("uhttpd", strings.NewReader("config cert 'defaults'\n\toption banner 'Welcome\n\n"))
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) || parseErr.Line != 2 || !errors.Is(err, errUnterminatedQuote) {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	from := mustParse(t, "network", handwritten)
+	to := mustParse(t, "network", handwritten)
+	lan := to.Section("lan")
+	lan.Set("proto", "dhcp")
+	lan.Delete("ip6assign")
+	to.Remove(to.Section("loopback"))
+	devices := to.SectionsOfType("device")
+	devices[0].SetList("ports", "lan1", "lan3", "lan4")
+	devices[1].Set("type", "bridge")
+	guest := to.Add("interface", "guest")
+	guest.Set("proto", "none")
+	to.Add("device", "").Set("name", "br-iot")
+
+	expected := []string{
+		"delete network.loopback",
+		"del_list network.@device[0].ports='lan2'",
+		"add_list network.@device[0].ports='lan3'",
+		"add_list network.@device[0].ports='lan4'",
+		"delete network.lan.ip6assign",
+		"set network.lan.proto='dhcp'",
+		"set network.@device[1].type='bridge'",
+		"set network.guest=interface",
+		"set network.guest.proto='none'",
+		"add network device",
+		"set network.@device[-1].name='br-iot'",
+	}
+	commands := Diff(from, to)
+	if batch := Batch(commands); batch != strings.Join(expected, "\n")+"\n" {
+		t.Errorf("diff is\n%s", batch)
+	}
+	if applied := apply(t, mustParse(t, "network", handwritten), commands); !equal(applied, to) {
+		t.Errorf("diff applied gives\n%s", applied)
+	}
+	if commands := Diff(to, to); len(commands) != 0 {
+		t.Errorf("diff of the same package is %s", Batch(commands))
+	}
+}
+
+func TestDiffAnonymousSections(t *testing.T) {
+	from := NewPackage("dhcp")
+	for i := range 4 {
+		from.Add("host", "").Set("name", "host"+strconv.Itoa(i))
+	}
+	from.Add("host", "named").Set("name", "named")
+	to := NewPackage("dhcp")
+	to.Add("host", "").Set("name", "host0")
+	to.Add("host", "").Set("name", "host2")
+	to.Add("host", "named").Set("name", "named")
+
+	commands := Diff(from, to)
+	expected := "delete dhcp.@host[3]\ndelete dhcp.@host[2]\nset dhcp.@host[1].name='host2'\n"
+	if batch := Batch(commands); batch != expected {
+		t.Errorf("diff is\n%s", batch)
+	}
+	if applied := apply(t, from, commands); !equal(applied, to) {
+		t.Errorf("diff applied gives\n%s", applied)
+	}
+
+	commands = Diff(nil, to)
+	if applied := apply(t, NewPackage("dhcp"), commands); !equal(applied, to) {
+		t.Errorf("diff from empty package applied gives\n%s", applied)
+	}
+}
+
+func TestDiffLists(t *testing.T) {
+	cases := []struct {
+		from, to []string
+		expected string
+	}{
+		{[]string{"a", "b"}, []string{"a", "b", "c"}, "add_list p.s.l='c'\n"},
+		{[]string{"a", "b", "a", "c"}, []string{"b", "c"}, "del_list p.s.l='a'\n"},
+		{[]string{"a", "b"}, []string{"b", "a"}, "delete p.s.l\nadd_list p.s.l='b'\nadd_list p.s.l='a'\n"},
+	}
+	for _, c := range cases {
+		from, to := NewPackage("p"), NewPackage("p")
+		from.Add("t", "s").SetList("l", c.from...)
+		to.Add("t", "s").SetList("l", c.to...)
+		if batch := Batch(Diff(from, to)); batch != c.expected {
+			t.Errorf("diff of %v and %v is\n%s", c.from, c.to, batch)
+		}
+	}
+}
+
+// apply executes commands on a copy of the package.
+func apply(t *testing.T, pkg *Package, commands []*Command) *Package {
+	t.Helper()
+	pkg = pkg.Clone()
+	for _, command := range commands {
+		if err := pkg.Apply(command); err != nil {
+			t.Fatalf("%s: %v", command, err)
+		}
+	}
+	return pkg
+}
+
+// equal compares packages ignoring order of options.
+func equal(a, b *Package) bool {
+	return len(Diff(a, b)) == 0 && len(a.Sections) == len(b.Sections)
+}
+
+func TestParseCommand(t *testing.T) {
+	for _, line := range []string{
+		"set network.lan=interface",
+		"set network.lan.ipaddr='192.168.1.1'",
+		"set wireless.@wifi-iface[-1].ssid='it'\\''s'",
+		"add_list network.@device[0].ports='lan1'",
+		"del_list network.@device[0].ports='lan1'",
+		"delete network.lan",
+		"delete network.lan.ipaddr",
+		"add network device",
+	} {
+		command, err := ParseCommand(line)
+		if err != nil {
+			t.Errorf("%s: %v", line, err)
+		} else if command.String() != line {
+			t.Errorf("%s is parsed as %s", line, command)
+		}
+	}
+	for _, line := range []string{"set network", "delete network.lan=x", "add_list network.lan='x'", "commit network", "set 'x"} {
+		if _, err := ParseCommand(line); err == nil {
+			t.Errorf("%s is accepted", line)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	pkg := mustParse(t, "network", handwritten)
+	for _, line := range []string{"delete network.guest", "delete network.lan.gateway", "set network.@device[5].name='x'"} {
+		command, _ := ParseCommand(line)
+		if err := pkg.Apply(command); !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s: unexpected error %v", line, err)
+		}
+	}
+	for _, line := range []string{"add_list network.lan.dns='1.1.1.1'", "add_list network.lan.proto='dhcp'", "del_list network.@device[0].ports='lan1'"} {
+		command, _ := ParseCommand(line)
+		if err := pkg.Apply(command); err != nil {
+			t.Errorf("%s: %v", line, err)
+		}
+	}
+	lan := pkg.Section("lan")
+	if dns := lan.Option("dns"); !dns.List || dns.Value() != "1.1.1.1" {
+		t.Errorf("dns is %+v", dns)
+	}
+	if proto := lan.Option("proto"); !slices.Equal(proto.Values, []string{"static", "dhcp"}) {
+		t.Errorf("option turned into list is %+v", proto)
+	}
+	if ports, _ := pkg.Find("@device[-2]").Get("ports"); ports != "lan2" {
+		t.Errorf("ports are %q", ports)
+	}
+}
+
+func TestChanges(t *testing.T) {
+	from := mustParse(t, "network", handwritten)
+	to := from.Clone()
+	to.Remove(to.Section("loopback"))
+	to.Section("lan").Set("proto", "dhcp")
+	to.Section("lan").Delete("ip6assign")
+	to.SectionsOfType("device")[0].SetList("ports", "lan1")
+	to.Add("interface", "guest").Set("proto", "none")
+
+	var changes []string
+	for _, change := range Changes(from, to) {
+		changes = append(changes, change.Kind+" "+change.Path+" "+change.Old+" "+change.New)
+	}
+	expected := []string{
+		"remove network.loopback interface ",
+		"remove network.loopback.device 'lo' ",
+		"remove network.loopback.proto 'static' ",
+		"remove network.loopback.ipaddr '127.0.0.1' ",
+		"change network.@device[0].ports 'lan1' 'lan2' 'lan1'",
+		"change network.lan.proto 'static' 'dhcp'",
+		"remove network.lan.ip6assign '60' ",
+		"add network.guest  interface",
+		"add network.guest.proto  'none'",
+	}
+	if !slices.Equal(changes, expected) {
+		t.Errorf("changes are\n%s", strings.Join(changes, "\n"))
+	}
+}